@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/client"
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+)
+
+const usage = `usage: httpclient [flags] URL
+
+Sends one HTTP/1.1 request and prints the response.
+
+A body given with -d can be literal text, @file to read a file, or @- to
+read stdin. With -chunked the body is streamed with chunked encoding, and
+-trailer adds trailer fields after it.
+
+flags:
+`
+
+// headerList collects repeated "Name: value" flags.
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerList) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("expected \"Name: value\", got %q", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+type options struct {
+	method       string
+	headers      headerList
+	trailers     headerList
+	data         string
+	chunked      bool
+	raw          bool
+	verbose      bool
+	timing       bool
+	follow       bool
+	maxRedirects int
+	timeout      time.Duration
+}
+
+func main() {
+	log.SetFlags(0)
+	opts := options{}
+	flag.StringVar(&opts.method, "X", "", "request method (default GET, or POST when a body is given)")
+	flag.Var(&opts.headers, "H", "request header as \"Name: value\" (repeatable)")
+	flag.Var(&opts.trailers, "trailer", "trailer as \"Name: value\" sent after a chunked body (repeatable)")
+	flag.StringVar(&opts.data, "d", "", "request body: text, @file or @- for stdin")
+	flag.BoolVar(&opts.chunked, "chunked", false, "send the body with chunked transfer encoding")
+	flag.BoolVar(&opts.raw, "raw", false, "print the response exactly as received")
+	flag.BoolVar(&opts.verbose, "verbose", false, "dump the bytes sent and received to stderr")
+	flag.BoolVar(&opts.verbose, "v", false, "shorthand for -verbose")
+	flag.BoolVar(&opts.timing, "timing", false, "print connect, time to first byte and total time to stderr")
+	flag.BoolVar(&opts.follow, "L", false, "follow redirects")
+	flag.IntVar(&opts.maxRedirects, "max-redirs", 10, "maximum number of redirects to follow with -L")
+	flag.DurationVar(&opts.timeout, "timeout", 30*time.Second, "timeout for connecting, for the response headers, and for each write or body read to make progress")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(opts, flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(opts options, rawURL string) error {
+	if len(opts.trailers) > 0 && !opts.chunked {
+		return errors.New("-trailer needs -chunked")
+	}
+	body := newBodySource(opts.data)
+	method := opts.method
+	if method == "" {
+		method = "GET"
+		if opts.data != "" {
+			method = "POST"
+		}
+	}
+
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+
+	timer := &timer{}
+	trace := timer.trace()
+	var dump *wireDump
+	if opts.verbose {
+		dump = &wireDump{w: os.Stderr}
+		trace.WireOut = &dumpSide{dump: dump, prefix: "> "}
+		trace.WireIn = &dumpSide{dump: dump, prefix: "< "}
+	}
+	if opts.raw {
+		trace.WireIn = teeWriters(trace.WireIn, stdout)
+	}
+	c := &client.Client{
+		DialTimeout:           opts.timeout,
+		WriteTimeout:          opts.timeout,
+		ResponseHeaderTimeout: opts.timeout,
+		BodyReadTimeout:       opts.timeout,
+		Trace:                 trace,
+	}
+	defer c.CloseIdleConnections()
+
+	var origin *url.URL
+	for redirects := 0; ; redirects++ {
+		req, err := buildRequest(method, rawURL, opts, body)
+		if err != nil {
+			return err
+		}
+		if origin == nil {
+			origin = req.URL
+		} else if !sameOrigin(origin, req.URL) {
+			// credentials meant for the first site must not leak to another
+			req.Headers.Remove("Authorization")
+			req.Headers.Remove("Cookie")
+		}
+		timer.start()
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+
+		next, ok := redirectTarget(req, resp)
+		if opts.follow && ok {
+			// drain so the connection can be reused for the next hop
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			dump.endLine()
+			if redirects >= opts.maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", opts.maxRedirects)
+			}
+			if resp.StatusCode == 307 || resp.StatusCode == 308 {
+				// the method and body are sent again unchanged
+			} else if method != "HEAD" {
+				method = "GET"
+				body = newBodySource("")
+			}
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "* following %d redirect to %s\n", resp.StatusCode, next)
+			}
+			rawURL = next
+			continue
+		}
+
+		if opts.raw {
+			_, err = io.Copy(io.Discard, resp.Body)
+		} else {
+			err = printResponse(stdout, resp)
+		}
+		resp.Body.Close()
+		dump.endLine()
+		timer.done()
+		if err != nil {
+			return err
+		}
+		if opts.timing {
+			timer.print(os.Stderr)
+		}
+		return nil
+	}
+}
+
+func buildRequest(method, rawURL string, opts options, body *bodySource) (*client.Request, error) {
+	r, err := body.open()
+	if err != nil {
+		return nil, err
+	}
+	req, err := client.NewRequest(method, rawURL, r)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case r != nil && opts.chunked:
+		req.ContentLength = -1
+	case req.ContentLength < 0:
+		// stdin has no known length; read it all to send a Content-Length
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read body: %w", err)
+		}
+		req.Body = bytes.NewReader(data)
+		req.ContentLength = int64(len(data))
+	}
+	req.Headers, err = parseFields(opts.headers)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.trailers) > 0 {
+		req.Trailers, err = parseFields(opts.trailers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// parseFields turns "Name: value" strings into headers, reusing the
+// header parser so the same rules apply as on the wire.
+func parseFields(fields []string) (headers.Headers, error) {
+	h := headers.NewHeaders()
+	for _, field := range fields {
+		line := []byte(field + "\r\n")
+		n, _, err := h.Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		if n != len(line) {
+			return nil, fmt.Errorf("malformed header %q", field)
+		}
+	}
+	return h, nil
+}
+
+// redirectTarget returns the absolute URL a 3xx response points at.
+func redirectTarget(req *client.Request, resp *client.Response) (string, bool) {
+	switch resp.StatusCode {
+	case 301, 302, 303, 307, 308:
+	default:
+		return "", false
+	}
+	location, ok := resp.Headers.Get("Location")
+	if !ok {
+		return "", false
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", false
+	}
+	return req.URL.ResolveReference(u).String(), true
+}
+
+// sameOrigin reports whether a and b share scheme, host and port.
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(hostPort(a), hostPort(b))
+}
+
+// hostPort returns the host and port of u, filling in the scheme's default
+// port.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if strings.EqualFold(u.Scheme, "https") {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// printResponse writes the status line, sorted headers, body and any
+// trailers in a readable form.
+func printResponse(w io.Writer, resp *client.Response) error {
+	fmt.Fprintf(w, "HTTP/%s %d %s\n", resp.Proto, resp.StatusCode, resp.Reason)
+	printFields(w, resp.Headers)
+	fmt.Fprintln(w)
+	_, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if len(resp.Trailers) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "--- trailers ---")
+		printFields(w, resp.Trailers)
+	}
+	return nil
+}
+
+func printFields(w io.Writer, h headers.Headers) {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range h.Values(key) {
+			fmt.Fprintf(w, "%s: %s\n", key, value)
+		}
+	}
+}
+
+// bodySource opens the request body for each attempt, so a redirect that
+// keeps the body can send it again.
+type bodySource struct {
+	data   string
+	opened bool
+}
+
+func newBodySource(data string) *bodySource {
+	return &bodySource{data: data}
+}
+
+func (b *bodySource) open() (io.Reader, error) {
+	defer func() { b.opened = true }()
+	switch {
+	case b.data == "":
+		return nil, nil
+	case b.data == "@-":
+		if b.opened {
+			return nil, errors.New("cannot resend a body read from stdin")
+		}
+		return os.Stdin, nil
+	case strings.HasPrefix(b.data, "@"):
+		data, err := os.ReadFile(b.data[1:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read body: %w", err)
+		}
+		return bytes.NewReader(data), nil
+	default:
+		return strings.NewReader(b.data), nil
+	}
+}
+
+// timer records the phases of the final request.
+type timer struct {
+	begin, connectStart, connected, firstByte, end time.Time
+}
+
+func (t *timer) trace() *client.Trace {
+	return &client.Trace{
+		ConnectStart: func(network, addr string) {
+			t.connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.connected = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.firstByte = time.Now()
+		},
+	}
+}
+
+func (t *timer) start() {
+	*t = timer{begin: time.Now()}
+}
+
+func (t *timer) done() {
+	t.end = time.Now()
+}
+
+func (t *timer) print(w io.Writer) {
+	connect := time.Duration(0)
+	if !t.connectStart.IsZero() {
+		connect = t.connected.Sub(t.connectStart)
+	}
+	fmt.Fprintf(w, "connect: %s\n", connect)
+	fmt.Fprintf(w, "ttfb:    %s\n", t.firstByte.Sub(t.begin))
+	fmt.Fprintf(w, "total:   %s\n", t.end.Sub(t.begin))
+}
+
+// wireDump writes both directions of the conversation to w with every line
+// prefixed by its direction, like curl -v.
+type wireDump struct {
+	w       io.Writer
+	prefix  string
+	midLine bool
+}
+
+type dumpSide struct {
+	dump   *wireDump
+	prefix string
+}
+
+func (s *dumpSide) Write(data []byte) (int, error) {
+	d := s.dump
+	out := &bytes.Buffer{}
+	if d.midLine && d.prefix != s.prefix {
+		out.WriteString("\n")
+		d.midLine = false
+	}
+	d.prefix = s.prefix
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !d.midLine {
+			out.WriteString(s.prefix)
+		}
+		out.Write(line)
+		d.midLine = line[len(line)-1] != '\n'
+	}
+	_, err := d.w.Write(out.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// endLine finishes a partial line, since bodies don't have to end with a
+// newline.
+func (d *wireDump) endLine() {
+	if d != nil && d.midLine {
+		d.w.Write([]byte("\n"))
+		d.midLine = false
+	}
+}
+
+func teeWriters(a, b io.Writer) io.Writer {
+	if a == nil {
+		return b
+	}
+	return io.MultiWriter(a, b)
+}
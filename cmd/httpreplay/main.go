@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/capture"
+)
+
+const usage = `usage: httpreplay [flags] [capture.jsonl ...]
+
+Re-sends every captured request to a server and compares the responses
+with the recorded ones. Captures are read from stdin when no file is given.
+Exits with status 1 if any response differs.
+
+flags:
+`
+
+type summary struct {
+	passed, failed, errors int
+}
+
+func main() {
+	log.SetFlags(0)
+	addr := flag.String("addr", "localhost:42069", "server to replay against")
+	ignore := flag.String("ignore", "date", "comma separated headers and trailers to leave out of the comparison")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout for each request")
+	verbose := flag.Bool("v", false, "also list requests that match")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ignored := strings.Split(*ignore, ",")
+	for i := range ignored {
+		ignored[i] = strings.TrimSpace(ignored[i])
+	}
+
+	sum := &summary{}
+	if flag.NArg() == 0 {
+		replayAll(os.Stdin, "stdin", *addr, ignored, *timeout, *verbose, sum)
+	}
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		replayAll(f, path, *addr, ignored, *timeout, *verbose, sum)
+		f.Close()
+	}
+
+	fmt.Printf("%d passed, %d failed, %d errors\n", sum.passed, sum.failed, sum.errors)
+	if sum.failed > 0 || sum.errors > 0 {
+		os.Exit(1)
+	}
+}
+
+func replayAll(r io.Reader, name, addr string, ignore []string, timeout time.Duration, verbose bool, sum *summary) {
+	// read everything first, so replaying against a server that records to
+	// the same file does not feed itself
+	var records []*capture.Record
+	reader := capture.NewReader(r)
+	for {
+		rec, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// a broken line leaves the decoder unable to continue
+			log.Printf("%s: record %d: %v", name, len(records)+1, err)
+			sum.errors++
+			break
+		}
+		records = append(records, rec)
+	}
+
+	for _, rec := range records {
+		label := describe(rec)
+		start := time.Now()
+		got, err := capture.Replay(addr, rec, timeout)
+		elapsed := time.Since(start)
+		if err != nil {
+			fmt.Printf("ERROR %s: %v\n", label, err)
+			sum.errors++
+			continue
+		}
+		if rec.Response == nil && got != nil {
+			// nothing to compare against, e.g. captures from tcplistener
+			if verbose {
+				fmt.Printf("SENT  %s -> %d (%s)\n", label, got.StatusCode, elapsed.Round(time.Microsecond))
+			}
+			sum.passed++
+			continue
+		}
+		diffs := capture.Diff(rec.Response, got, ignore)
+		if len(diffs) == 0 {
+			if verbose {
+				fmt.Printf("PASS  %s (%s)\n", label, elapsed.Round(time.Microsecond))
+			}
+			sum.passed++
+			continue
+		}
+		fmt.Printf("FAIL  %s\n", label)
+		for _, d := range diffs {
+			fmt.Printf("      %s\n", d)
+		}
+		sum.failed++
+	}
+}
+
+func describe(rec *capture.Record) string {
+	if rec.Request.Error != nil {
+		return fmt.Sprintf("malformed request (%s)", rec.Request.Error.Message)
+	}
+	return rec.Request.Method + " " + rec.Request.Target
+}
@@ -1,26 +1,73 @@
 package main
 
 import (
-	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/capture"
+	"github.com/jonvanw/httpfromtcp/internal/compress"
+	"github.com/jonvanw/httpfromtcp/internal/conditional"
+	"github.com/jonvanw/httpfromtcp/internal/fileserver"
+	"github.com/jonvanw/httpfromtcp/internal/negotiate"
+	"github.com/jonvanw/httpfromtcp/internal/proxy"
 	"github.com/jonvanw/httpfromtcp/internal/request"
 	"github.com/jonvanw/httpfromtcp/internal/response"
 	"github.com/jonvanw/httpfromtcp/internal/server"
+	"github.com/jonvanw/httpfromtcp/internal/sse"
+	"github.com/jonvanw/httpfromtcp/internal/tunnel"
+	"github.com/jonvanw/httpfromtcp/internal/websocket"
 )
 
 const port = 42069
-const bufferSize = 1024
+
+var httpBinProxy = &proxy.ReverseProxy{
+	Upstreams:      []string{"https://httpbin.org"},
+	StripPrefix:    "/httpbin",
+	DigestTrailers: true,
+}
+
+var assets = &fileserver.FileServer{
+	Root:        "assets",
+	StripPrefix: "/assets",
+}
+
+// connectProxy serves CONNECT when CONNECT_ALLOW lists the destinations
+// (comma separated, see tunnel.Proxy.Allow) this server may tunnel to. Only
+// CONNECT_ALLOW=* lets it tunnel anywhere.
+var connectProxy *tunnel.Proxy
 
 func main() {
-	server, err := server.Serve(port, handler)
+	if allow := os.Getenv("CONNECT_ALLOW"); allow != "" {
+		connectProxy = &tunnel.Proxy{
+			Allow: strings.Split(allow, ","),
+			OnClose: func(s tunnel.Stats) {
+				log.Printf("Tunnel to %s closed after %s: %d bytes up, %d bytes down", s.Destination, s.Duration, s.BytesUp, s.BytesDown)
+			},
+		}
+	}
+
+	// RECORD_FILE appends every exchange to a JSON Lines capture for httpreplay
+	var recorder *capture.Recorder
+	if path := os.Getenv("RECORD_FILE"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("Error opening record file: %v", err)
+		}
+		defer f.Close()
+		recorder = capture.NewRecorder(f)
+		log.Println("Recording requests to", path)
+	}
+
+	// ETags are computed on the uncompressed body, so the compressor goes outside
+	compressor := &compress.Compressor{}
+	etagger := &conditional.ETagger{}
+	server, err := server.ServeRecording(port, compressor.Wrap(etagger.Wrap(handler)), recorder)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -37,38 +84,83 @@ func main() {
 func handler(w *response.Writer, req *request.Request) {
 	target := req.RequestLine.RequestTarget
 	switch {
+	case req.RequestLine.Method == "CONNECT":
+		handleConnect(w, req)
 	case target == "/yourproblem":
-		handleYourProblem(w)
+		handleYourProblem(w, req)
 	case target == "/myproblem":
-		handleMyProblem(w)
+		handleMyProblem(w, req)
 	case target == "/video":
-		handleVideo(w)
+		assets.ServeFile(w, req, "vim.mp4")
+	case strings.HasPrefix(target, "/assets/"):
+		assets.Handle(w, req)
+	case target == "/events":
+		handleEvents(w, req)
+	case target == "/ws":
+		handleWebSocketEcho(w, req)
 	case strings.HasPrefix(target, "/httpbin/"):
-		handleHttpBin(w, target)
+		httpBinProxy.Handle(w, req)
 	default:
-		handleOK(w)
+		handleOK(w, req)
 	}
 }
 
-func handleYourProblem(w *response.Writer) {
-	writeSimpleResponse(w, response.StatusBadRequest, BAD_REQUEST_RESPONSE_BODY)
+func handleConnect(w *response.Writer, req *request.Request) {
+	if connectProxy == nil {
+		writeSimpleResponse(w, response.StatusMethodNotAllowed, METHOD_NOT_ALLOWED_RESPONSE_BODY)
+		return
+	}
+	connectProxy.Handle(w, req)
 }
 
-func handleMyProblem(w *response.Writer) {
-	writeSimpleResponse(w, response.StatusInternalServerError, INTERNAL_SERVER_ERROR_RESPONSE_BODY)
+func handleYourProblem(w *response.Writer, req *request.Request) {
+	writePage(w, req, response.StatusBadRequest, BAD_REQUEST_RESPONSE_BODY, "Your request honestly kinda sucked.")
 }
 
-func handleOK(w *response.Writer) {
-	writeSimpleResponse(w, response.StatusOK, OK_RESPONSE_BODY)
+func handleMyProblem(w *response.Writer, req *request.Request) {
+	writePage(w, req, response.StatusInternalServerError, INTERNAL_SERVER_ERROR_RESPONSE_BODY, "Okay, you know what? This one is on me.")
 }
 
-func writeSimpleResponse(w *response.Writer, statusCode response.StatusCode, body string) {
+func handleOK(w *response.Writer, req *request.Request) {
+	writePage(w, req, response.StatusOK, OK_RESPONSE_BODY, "Your request was an absolute banger.")
+}
+
+// pageTypes are the formats the simple pages come in, HTML first for clients
+// that do not say.
+var pageTypes = []string{"text/html", "application/json", "text/plain"}
+
+// writePage writes a simple page as HTML, JSON or plain text, whichever the
+// client's Accept header prefers.
+func writePage(w *response.Writer, req *request.Request, statusCode response.StatusCode, html, message string) {
+	contentType, ok := negotiate.Choose(w, req, pageTypes...)
+	if !ok {
+		return
+	}
+	body := html
+	switch contentType {
+	case "application/json":
+		data, err := json.Marshal(struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		}{int(statusCode), message})
+		if err != nil {
+			log.Printf("Error encoding page: %v", err)
+			return
+		}
+		body = string(data) + "\n"
+	case "text/plain":
+		body = message + "\n"
+	}
+
 	err := w.WriteStatusLine(statusCode)
 	if err != nil {
 		log.Printf("Error writing status line: %v", err)
 		return
 	}
-	err = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	headers := response.GetDefaultHeaders(len(body))
+	headers.Override("content-type", contentType)
+	headers.Override("vary", "Accept")
+	err = w.WriteHeaders(headers)
 	if err != nil {
 		log.Printf("Error writing headers: %v", err)
 		return
@@ -79,23 +171,13 @@ func writeSimpleResponse(w *response.Writer, statusCode response.StatusCode, bod
 	}
 }
 
-func handleVideo(w *response.Writer) {
-	body, err := os.ReadFile("assets/vim.mp4")
-	if err != nil {
-		log.Printf("Error reading video file: %v", err)
-		writeSimpleResponse(w, response.StatusInternalServerError, INTERNAL_SERVER_ERROR_RESPONSE_BODY)
-		return
-	}
-	
-	err = w.WriteStatusLine(response.StatusOK)
+func writeSimpleResponse(w *response.Writer, statusCode response.StatusCode, body string) {
+	err := w.WriteStatusLine(statusCode)
 	if err != nil {
 		log.Printf("Error writing status line: %v", err)
 		return
 	}
-
-	headers := response.GetDefaultHeaders(len(body))
-	headers.Override("content-type", "video/mp4")
-	err = w.WriteHeaders(headers)
+	err = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
 	if err != nil {
 		log.Printf("Error writing headers: %v", err)
 		return
@@ -106,58 +188,55 @@ func handleVideo(w *response.Writer) {
 	}
 }
 
-func handleHttpBin(w *response.Writer, target string) {
-	err := w.WriteStatusLine(response.StatusOK)
+func handleEvents(w *response.Writer, req *request.Request) {
+	stream, err := sse.NewStream(w, req)
 	if err != nil {
-		log.Printf("Error writing status line: %v", err)
+		log.Printf("Error starting event stream: %v", err)
 		return
 	}
-	resHeaders := response.GetDefaultHeaders(0)
-	resHeaders.Remove("content-length")
-	resHeaders.Append("transfer-encoding", "chunked")
-	resHeaders.Append("trailer", "X-Content-SHA256, X-Content-Length")
-	err = w.WriteHeaders(resHeaders)
-	if err != nil {
-		log.Printf("Error writing headers: %v", err)
-		return
+	stream.Heartbeat(15 * time.Second)
+	defer stream.Close()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for i := 1; i <= 10; i++ {
+		select {
+		case <-stream.Done():
+			log.Printf("Event stream ended: %v", stream.Err())
+			return
+		case now := <-ticker.C:
+			err := stream.Send(sse.Event{
+				ID:    fmt.Sprintf("%d", i),
+				Event: "tick",
+				Data:  now.Format(time.RFC3339),
+			})
+			if err != nil {
+				log.Printf("Error sending event: %v", err)
+				return
+			}
+		}
 	}
+}
 
-	x := strings.TrimPrefix(target, "/httpbin/")
-	httpBinUrl := fmt.Sprintf("https://httpbin.org/%s", x)
-	resp, err := http.Get(httpBinUrl)
+func handleWebSocketEcho(w *response.Writer, req *request.Request) {
+	conn, err := websocket.Upgrade(w, req)
 	if err != nil {
-		log.Printf("Error making request to httpbin: %v", err)
+		log.Printf("Error upgrading to websocket: %v", err)
 		return
 	}
-	defer resp.Body.Close()
-	buf := make([]byte, bufferSize)
-	fullBody := []byte{}
+	defer conn.Close(websocket.CloseNormalClosure, "")
 	for {
-		n, err := resp.Body.Read(buf)
-		if n > 0 {
-			fullBody = append(fullBody, buf[:n]...)
-			_, err = w.WriteChunkedBody(buf[:n])
-			if err != nil {
-				log.Printf("Error writing chunked body: %v", err)
-				return
-			}
+		msgType, msg, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("Websocket closed: %v", err)
+			return
 		}
+		err = conn.WriteMessage(msgType, msg)
 		if err != nil {
-			if err.Error() != "EOF" {
-				log.Printf("Error reading response body: %v", err)
-			}
-			break
+			log.Printf("Error writing websocket message: %v", err)
+			return
 		}
 	}
-	w.WriteChunkedBodyDone()
-	trailers := headers.NewHeaders()
-	sh := sha256.Sum256(fullBody)
-	trailers.Append("X-Content-SHA256", fmt.Sprintf("%x", sh))
-	trailers.Append("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
-	err = w.WriteTrailers(trailers)
-	if err != nil {
-		log.Printf("Error writing trailers: %v", err)
-	}
 }
 
 const BAD_REQUEST_RESPONSE_BODY = `<html>
@@ -170,6 +249,16 @@ const BAD_REQUEST_RESPONSE_BODY = `<html>
   </body>
 </html>`
 
+const METHOD_NOT_ALLOWED_RESPONSE_BODY = `<html>
+  <head>
+    <title>405 Method Not Allowed</title>
+  </head>
+  <body>
+    <h1>Method Not Allowed</h1>
+    <p>This server is not a proxy. Set CONNECT_ALLOW to make it one.</p>
+  </body>
+</html>`
+
 const INTERNAL_SERVER_ERROR_RESPONSE_BODY = `<html>
   <head>
     <title>500 Internal Server Error</title>
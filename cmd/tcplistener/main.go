@@ -1,46 +1,197 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/jonvanw/httpfromtcp/internal/capture"
+	"github.com/jonvanw/httpfromtcp/internal/headers"
 	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
 )
 
+type inspector struct {
+	hexDump bool
+	timeout time.Duration
+
+	printMu sync.Mutex
+	out     *capture.Recorder
+}
+
 func main() {
-	listener, err := net.Listen("tcp", ":42069")
+	addr := flag.String("addr", ":42069", "address to listen on")
+	hexDump := flag.Bool("hex", false, "print a hex dump of the bytes received")
+	outPath := flag.String("out", "", "append every request to this file as a JSON Lines capture")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long to wait for a complete request")
+	flag.Parse()
+
+	ins := &inspector{hexDump: *hexDump, timeout: *timeout}
+	if *outPath != "" {
+		f, err := os.OpenFile(*outPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		ins.out = capture.NewRecorder(f)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer func() {
+	fmt.Printf("Listening on %s\n", listener.Addr())
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
 		if err := listener.Close(); err != nil {
 			log.Printf("Failed to close listener: %s", err.Error())
 		} else {
 			fmt.Println("Listener closed successfully.")
 		}
 	}()
+
+	var wg sync.WaitGroup
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
 			log.Printf("Failed to accept connection: %s", err.Error())
 			continue
 		}
-		request, err := request.RequestFromReader(conn)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ins.handle(conn)
+		}()
+	}
+	wg.Wait()
+}
+
+func (ins *inspector) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(ins.timeout))
+
+	start := time.Now()
+	raw := &bytes.Buffer{}
+	req, rest, err := request.ReadRequest(io.TeeReader(conn, raw))
+	rec := &capture.Record{
+		Time:       start.UTC(),
+		RemoteAddr: conn.RemoteAddr().String(),
+	}
+	status := response.StatusOK
+	if err != nil {
+		rec.Request = capture.NewBadRequest(raw.Bytes(), err)
+		status = response.StatusBadRequest
+	} else {
+		// bytes read past the request are not part of it
+		rec.Request = capture.NewRequest(raw.Bytes()[:raw.Len()-len(rest)], req)
+	}
+	rec.Timing.Read = time.Since(start)
+	rec.Timing.Total = rec.Timing.Read
+
+	ins.print(rec)
+	if ins.out != nil {
+		err := ins.out.Write(rec)
 		if err != nil {
-			log.Printf("Failed to parse request: %s", err.Error())
-			continue
+			log.Printf("Failed to write record: %s", err.Error())
 		}
-		fmt.Println("Request line:")
-		fmt.Printf("- Method: %+v\n", request.RequestLine.Method)
-		fmt.Printf("- Target: %+v\n", request.RequestLine.RequestTarget)
-		fmt.Printf("- Version: %+v\n", request.RequestLine.HttpVersion)
-		fmt.Println("Headers:")
-		for key, value := range request.Headers {
-			fmt.Printf("- %s: %s\n", key, value)
+	}
+
+	body, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode record: %s", err.Error())
+		return
+	}
+	body = append(body, '\n')
+	w := response.NewWriter(conn)
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("content-type", "application/json")
+	if err := w.WriteStatusLine(status); err != nil {
+		log.Printf("Failed to write response: %s", err.Error())
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		log.Printf("Failed to write response: %s", err.Error())
+		return
+	}
+	if _, err := w.WriteBody(body); err != nil {
+		log.Printf("Failed to write response: %s", err.Error())
+	}
+}
+
+// print writes a readable summary of rec to stdout in one go, so output from
+// concurrent connections does not interleave.
+func (ins *inspector) print(rec *capture.Record) {
+	req := rec.Request
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "=== %s from %s (%d bytes)\n", rec.Time.Format(time.RFC3339Nano), rec.RemoteAddr, len(req.Raw))
+	if req.Error != nil {
+		fmt.Fprintf(buf, "Parse error in %s at byte %d: %s\n", req.Error.Stage, req.Error.Offset, req.Error.Message)
+		fmt.Fprintf(buf, "Near: %s\n", context(req.Raw, req.Error.Offset))
+	} else {
+		fmt.Fprintln(buf, "Request line:")
+		fmt.Fprintf(buf, "- Method: %s\n", req.Method)
+		fmt.Fprintf(buf, "- Target: %s\n", req.Target)
+		fmt.Fprintf(buf, "- Version: %s\n", req.Version)
+		fmt.Fprintln(buf, "Headers:")
+		printHeaders(buf, req.Headers)
+		fmt.Fprintln(buf, "Body:")
+		if req.Base64 != nil {
+			fmt.Fprintf(buf, "(%d bytes of binary data)\n", len(req.Base64))
+		} else {
+			fmt.Fprintf(buf, "%s\n", req.Text)
 		}
-		fmt.Println("Body:")
-		fmt.Printf("%s\n", string(request.Body))
-		conn.Close()
 	}
-}
\ No newline at end of file
+	if ins.hexDump {
+		fmt.Fprintln(buf, "Raw:")
+		buf.WriteString(hex.Dump(req.Raw))
+	}
+
+	ins.printMu.Lock()
+	defer ins.printMu.Unlock()
+	os.Stdout.Write(buf.Bytes())
+}
+
+func printHeaders(w io.Writer, h headers.Headers) {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "- %s: %s\n", key, h[key])
+	}
+}
+
+// context quotes the rest of the line starting at offset.
+func context(raw []byte, offset int) string {
+	if offset >= len(raw) {
+		return "(end of input)"
+	}
+	line := raw[offset:]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i+1]
+	}
+	if len(line) > 80 {
+		return strconv.Quote(string(line[:80])) + "..."
+	}
+	return strconv.Quote(string(line))
+}
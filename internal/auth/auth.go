@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/server"
+)
+
+var (
+	// ErrNoCredentials means the request has no Authorization header, or
+	// one for a scheme the Authenticator does not accept.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrMalformed means the Authorization header could not be parsed.
+	ErrMalformed = errors.New("malformed credentials")
+	// ErrInvalid means the credentials were parsed but not accepted.
+	ErrInvalid = errors.New("invalid credentials")
+)
+
+// Principal is who a request was authenticated as.
+type Principal struct {
+	// Name is the user name, the owner of the token, or the key ID.
+	Name string
+	// Scheme is the authentication scheme that was used, like "Basic".
+	Scheme string
+}
+
+// Scheme is an HTTP authentication scheme (RFC 9110 section 11).
+type Scheme interface {
+	// Name is the auth-scheme, matched case-insensitively.
+	Name() string
+	// Authenticate checks the credentials that followed the scheme name in
+	// the Authorization header. Errors wrap ErrMalformed or ErrInvalid.
+	Authenticate(req *request.Request, credentials string) (*Principal, error)
+	// Challenge returns the WWW-Authenticate challenge for the scheme. err
+	// is what Authenticate returned if the client tried this scheme, and
+	// nil otherwise.
+	Challenge(realm string, err error) string
+}
+
+// Authenticator is middleware that only lets through requests with valid
+// credentials for one of Schemes. Others get a 401 with a challenge for each
+// scheme. Handlers find out who made the request with FromRequest.
+type Authenticator struct {
+	// Realm names the protection space in challenges. Empty means
+	// "restricted".
+	Realm   string
+	Schemes []Scheme
+}
+
+// principalKey is the request value key for the authenticated principal.
+type principalKey struct{}
+
+// FromRequest returns who the request was authenticated as, if it passed
+// through an Authenticator.
+func FromRequest(req *request.Request) (*Principal, bool) {
+	p, ok := req.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// Wrap returns a handler that calls next only for authenticated requests.
+func (a *Authenticator) Wrap(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		p, tried, err := a.authenticate(req)
+		if err != nil {
+			if !errors.Is(err, ErrNoCredentials) {
+				log.Printf("Authentication failed for %s: %v", req.RemoteAddr, err)
+			}
+			a.challenge(w, tried, err)
+			return
+		}
+		req.SetValue(principalKey{}, p)
+		next(w, req)
+	}
+}
+
+// Authenticate checks the Authorization header of req against Schemes.
+func (a *Authenticator) Authenticate(req *request.Request) (*Principal, error) {
+	p, _, err := a.authenticate(req)
+	return p, err
+}
+
+// authenticate also returns the scheme the client used, if it is one of
+// Schemes.
+func (a *Authenticator) authenticate(req *request.Request) (*Principal, Scheme, error) {
+	value, ok := req.Headers.Get("Authorization")
+	if !ok {
+		return nil, nil, ErrNoCredentials
+	}
+	name, credentials := parseAuthorization(value)
+	for _, scheme := range a.Schemes {
+		if strings.EqualFold(scheme.Name(), name) {
+			p, err := scheme.Authenticate(req, credentials)
+			return p, scheme, err
+		}
+	}
+	return nil, nil, ErrNoCredentials
+}
+
+func (a *Authenticator) challenge(w *response.Writer, tried Scheme, err error) {
+	realm := a.Realm
+	if realm == "" {
+		realm = "restricted"
+	}
+	challenges := make([]string, 0, len(a.Schemes))
+	for _, scheme := range a.Schemes {
+		var schemeErr error
+		if scheme == tried {
+			schemeErr = err
+		}
+		challenges = append(challenges, scheme.Challenge(realm, schemeErr))
+	}
+	response.WriteError(w, response.StatusUnauthorized, headers.Headers{"www-authenticate": strings.Join(challenges, ", ")}, "authentication required")
+}
+
+// parseAuthorization splits an Authorization value into the scheme name and
+// the credentials after it.
+func parseAuthorization(value string) (scheme, credentials string) {
+	value = strings.TrimSpace(value)
+	scheme, credentials, _ = strings.Cut(value, " ")
+	return scheme, strings.TrimSpace(credentials)
+}
+
+// parseParams reads comma-separated auth-params, whose values may be
+// quoted strings. Names are lowercase.
+func parseParams(s string) (map[string]string, bool) {
+	params := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params, true
+		}
+		name, rest, ok := strings.Cut(s, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return nil, false
+		}
+		rest = strings.TrimLeft(rest, " \t")
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			if i == len(rest) {
+				return nil, false
+			}
+			value, s = b.String(), rest[i+1:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value, s = strings.TrimSpace(rest[:end]), rest[end:]
+		}
+		if _, dup := params[name]; dup {
+			return nil, false
+		}
+		params[name] = value
+		s = strings.TrimLeft(s, " \t")
+		if s != "" && s[0] != ',' {
+			return nil, false
+		}
+	}
+}
+
+// quote returns s as a quoted-string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
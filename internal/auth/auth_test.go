@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func htpasswd(t *testing.T) *Htpasswd {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	// htpasswd -B writes the $2y$ prefix
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	data := "# admins\n\nada:" + strings.Replace(string(hash), "$2a$", "$2y$", 1) + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	h, err := LoadHtpasswd(path)
+	require.NoError(t, err)
+	return h
+}
+
+func basic(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// do sends a GET with the given Authorization header through a and returns
+// the response and who the handler saw the request come from.
+func do(t *testing.T, a *Authenticator, req *request.Request, authorization string) (*servertest.Result, *Principal) {
+	t.Helper()
+	if authorization != "" {
+		req.Headers.Override("authorization", authorization)
+	}
+	var seen *Principal
+	res := servertest.Run(t, a.Wrap(func(w *response.Writer, req *request.Request) {
+		p, ok := FromRequest(req)
+		require.True(t, ok)
+		seen = p
+		require.NoError(t, w.WriteStatusLine(response.StatusOK))
+		require.NoError(t, w.WriteHeaders(response.GetDefaultHeaders(0)))
+	}), req)
+	return res, seen
+}
+
+func get() *request.Request {
+	return servertest.NewRequest("GET", "/internal/metrics", nil, nil)
+}
+
+func TestBasic(t *testing.T) {
+	a := &Authenticator{Realm: "internal", Schemes: []Scheme{&Basic{Verifier: htpasswd(t)}}}
+
+	res, p := do(t, a, get(), basic("ada", "s3cret"))
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, &Principal{Name: "ada", Scheme: "Basic"}, p)
+
+	for name, authorization := range map[string]string{
+		"none":           "",
+		"wrong password": basic("ada", "guess"),
+		"unknown user":   basic("bob", "s3cret"),
+		"not base64":     "Basic !!!",
+		"no colon":       "Basic " + base64.StdEncoding.EncodeToString([]byte("ada")),
+		"other scheme":   "Digest username=\"ada\"",
+	} {
+		t.Run(name, func(t *testing.T) {
+			res, p := do(t, a, get(), authorization)
+			assert.Nil(t, p)
+			assert.Equal(t, response.StatusUnauthorized, res.StatusLine.StatusCode)
+			assert.Equal(t, `Basic realm="internal", charset="UTF-8"`, res.Headers["www-authenticate"])
+		})
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	req := get()
+	req.Headers.Override("authorization", "basic "+base64.StdEncoding.EncodeToString([]byte("ada:pass:with:colons")))
+	user, password, ok := BasicAuth(req)
+	assert.True(t, ok)
+	assert.Equal(t, "ada", user)
+	assert.Equal(t, "pass:with:colons", password)
+
+	_, _, ok = BasicAuth(get())
+	assert.False(t, ok)
+}
+
+func TestParseHtpasswdRefusesWeakHashes(t *testing.T) {
+	for _, line := range []string{
+		"ada:$apr1$salt$hash",
+		"ada:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+		"ada:plaintext",
+		"no colon",
+	} {
+		_, err := ParseHtpasswd(strings.NewReader(line))
+		assert.ErrorContains(t, err, "line 1", line)
+	}
+}
+
+func TestBearer(t *testing.T) {
+	a := &Authenticator{Schemes: []Scheme{&Bearer{Verifier: NewTokenList(map[string]string{"tok-123.abc": "deploy-bot"})}}}
+
+	res, p := do(t, a, get(), "Bearer tok-123.abc")
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, &Principal{Name: "deploy-bot", Scheme: "Bearer"}, p)
+
+	for authorization, challenge := range map[string]string{
+		"":                   `Bearer realm="restricted"`,
+		"Bearer tok-124.abc": `Bearer realm="restricted", error="invalid_token"`,
+		"Bearer a b":         `Bearer realm="restricted", error="invalid_request"`,
+	} {
+		res, p := do(t, a, get(), authorization)
+		assert.Nil(t, p)
+		assert.Equal(t, response.StatusUnauthorized, res.StatusLine.StatusCode, authorization)
+		assert.Equal(t, challenge, res.Headers["www-authenticate"], authorization)
+	}
+
+	req := get()
+	req.Headers.Override("authorization", "Bearer tok-123.abc")
+	token, ok := BearerToken(req)
+	assert.True(t, ok)
+	assert.Equal(t, "tok-123.abc", token)
+}
+
+func TestSignature(t *testing.T) {
+	now := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	secret := []byte("shared secret")
+	s := &Signature{Keys: map[string][]byte{"billing": secret}, now: func() time.Time { return now }}
+	a := &Authenticator{Schemes: []Scheme{s}}
+
+	signed := func(method, target, date, body string) *request.Request {
+		req := servertest.NewRequest(method, target, []byte(body), nil)
+		req.Headers.Override("date", date)
+		req.Headers.Override("authorization", SignatureAuthorization("billing", secret, method, target, date, []byte(body)))
+		return req
+	}
+	date := now.Add(-time.Minute).Format(headers.TimeFormat)
+
+	res, p := do(t, a, signed("POST", "/internal/charge?id=7", date, `{"cents":100}`), "")
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, &Principal{Name: "billing", Scheme: SignatureScheme}, p)
+
+	for name, req := range map[string]*request.Request{
+		"stale date":  signed("POST", "/internal/charge", now.Add(-10*time.Minute).Format(headers.TimeFormat), ""),
+		"future date": signed("POST", "/internal/charge", now.Add(10*time.Minute).Format(headers.TimeFormat), ""),
+		"bad date":    signed("POST", "/internal/charge", "yesterday", ""),
+	} {
+		res, p := do(t, a, req, "")
+		assert.Nil(t, p, name)
+		assert.Equal(t, response.StatusUnauthorized, res.StatusLine.StatusCode, name)
+		assert.Equal(t, `HMAC-SHA256 realm="restricted"`, res.Headers["www-authenticate"], name)
+	}
+
+	// changing anything that was signed breaks the signature
+	tampered := []func(req *request.Request){
+		func(req *request.Request) { req.Body = []byte(`{"cents":999}`) },
+		func(req *request.Request) { req.RequestLine.RequestTarget = "/internal/charge?id=8" },
+		func(req *request.Request) { req.RequestLine.Method = "PUT" },
+		func(req *request.Request) { req.Headers.Override("date", now.Format(headers.TimeFormat)) },
+		func(req *request.Request) {
+			req.Headers.Override("authorization", strings.Replace(req.Headers["authorization"], "billing", "shipping", 1))
+		},
+		func(req *request.Request) {
+			req.Headers.Override("authorization", SignatureAuthorization("billing", []byte("guess"), "POST", "/internal/charge?id=7", date, []byte(`{"cents":100}`)))
+		},
+	}
+	for i, tamper := range tampered {
+		req := signed("POST", "/internal/charge?id=7", date, `{"cents":100}`)
+		tamper(req)
+		res, p := do(t, a, req, "")
+		assert.Nil(t, p, i)
+		assert.Equal(t, response.StatusUnauthorized, res.StatusLine.StatusCode, i)
+	}
+}
+
+func TestSignatureCoversStreamedBody(t *testing.T) {
+	now := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	secret := []byte("shared secret")
+	a := &Authenticator{Schemes: []Scheme{&Signature{Keys: map[string][]byte{"billing": secret}, now: func() time.Time { return now }}}}
+	date := now.Format(headers.TimeFormat)
+
+	for body, want := range map[string]response.StatusCode{"--b--\r\n": response.StatusOK, "--x--\r\n": response.StatusUnauthorized} {
+		auth := SignatureAuthorization("billing", secret, "POST", "/upload", date, []byte("--b--\r\n"))
+		raw := "POST /upload HTTP/1.1\r\nHost: x\r\nContent-Type: multipart/form-data; boundary=b\r\nContent-Length: 7\r\n" +
+			"Date: " + date + "\r\nAuthorization: " + auth + "\r\n\r\n" + body
+		req, _, err := request.ReadRequest(strings.NewReader(raw))
+		require.NoError(t, err)
+		require.True(t, req.Streaming())
+		res, _ := do(t, a, req, "")
+		assert.Equal(t, want, res.StatusLine.StatusCode, body)
+	}
+}
+
+func TestMultipleSchemes(t *testing.T) {
+	a := &Authenticator{Realm: `ops "east"`, Schemes: []Scheme{
+		&Basic{Verifier: BasicVerifierFunc(func(user, password string) bool { return user == "ada" && password == "pw" })},
+		&Bearer{Verifier: NewTokenList(map[string]string{"t0k": "ci"})},
+		&Signature{Keys: map[string][]byte{"k": []byte("s")}},
+	}}
+
+	_, p := do(t, a, get(), basic("ada", "pw"))
+	assert.Equal(t, "ada", p.Name)
+	_, p = do(t, a, get(), "bearer t0k")
+	assert.Equal(t, "ci", p.Name)
+
+	res, _ := do(t, a, get(), "Bearer nope")
+	assert.Equal(t, `Basic realm="ops \"east\"", charset="UTF-8", Bearer realm="ops \"east\"", error="invalid_token", HMAC-SHA256 realm="ops \"east\""`, res.Headers["www-authenticate"])
+
+	_, ok := FromRequest(get())
+	assert.False(t, ok)
+}
+
+func TestParseParams(t *testing.T) {
+	params, ok := parseParams(`keyId="a\"b", Signature=abc=, realm = "x,y"`)
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"keyid": `a"b`, "signature": "abc=", "realm": "x,y"}, params)
+
+	for _, bad := range []string{`keyId="open`, `keyId`, `=x`, `a=1, a=2`, `a="1" b=2`} {
+		_, ok := parseParams(bad)
+		assert.False(t, ok, bad)
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicVerifier checks a user name and password.
+type BasicVerifier interface {
+	VerifyBasic(user, password string) bool
+}
+
+// BasicVerifierFunc adapts a function to a BasicVerifier.
+type BasicVerifierFunc func(user, password string) bool
+
+func (f BasicVerifierFunc) VerifyBasic(user, password string) bool {
+	return f(user, password)
+}
+
+// Basic is the Basic scheme (RFC 7617). It sends the password in the clear,
+// so it is only safe over TLS or on a trusted network.
+type Basic struct {
+	Verifier BasicVerifier
+}
+
+func (b *Basic) Name() string {
+	return "Basic"
+}
+
+func (b *Basic) Authenticate(req *request.Request, credentials string) (*Principal, error) {
+	user, password, ok := parseBasic(credentials)
+	if !ok {
+		return nil, fmt.Errorf("%w: Basic credentials are not base64 user:password", ErrMalformed)
+	}
+	if !b.Verifier.VerifyBasic(user, password) {
+		return nil, fmt.Errorf("%w: wrong password for user %q", ErrInvalid, user)
+	}
+	return &Principal{Name: user, Scheme: b.Name()}, nil
+}
+
+func (b *Basic) Challenge(realm string, err error) string {
+	return "Basic realm=" + quote(realm) + `, charset="UTF-8"`
+}
+
+// BasicAuth returns the user name and password of a request with Basic
+// credentials.
+func BasicAuth(req *request.Request) (user, password string, ok bool) {
+	value, ok := req.Headers.Get("Authorization")
+	if !ok {
+		return "", "", false
+	}
+	scheme, credentials := parseAuthorization(value)
+	if !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	return parseBasic(credentials)
+}
+
+func parseBasic(credentials string) (user, password string, ok bool) {
+	decoded, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// Htpasswd verifies Basic credentials against an htpasswd file of
+// "user:hash" lines holding bcrypt hashes, as made by "htpasswd -B".
+type Htpasswd struct {
+	hashes map[string][]byte
+	// dummy is compared against for unknown users, so they take as long
+	// to turn away as wrong passwords
+	dummy []byte
+}
+
+// LoadHtpasswd reads an htpasswd file.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, err := ParseHtpasswd(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return h, nil
+}
+
+// ParseHtpasswd reads htpasswd lines. Blank lines and lines starting with #
+// are skipped. Hashes other than bcrypt are refused, since the older
+// htpasswd formats are easily cracked.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{hashes: map[string][]byte{}}
+	cost := bcrypt.DefaultCost
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", line)
+		}
+		hashCost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: user %q does not have a bcrypt hash", line, user)
+		}
+		cost = max(cost, hashCost)
+		h.hashes[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	dummy, err := bcrypt.GenerateFromPassword([]byte("no such user"), cost)
+	if err != nil {
+		return nil, err
+	}
+	h.dummy = dummy
+	return h, nil
+}
+
+func (h *Htpasswd) VerifyBasic(user, password string) bool {
+	hash, ok := h.hashes[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(h.dummy, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
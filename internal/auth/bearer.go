@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jonvanw/httpfromtcp/internal/request"
+)
+
+// TokenVerifier checks a bearer token and returns who it belongs to.
+type TokenVerifier interface {
+	VerifyToken(token string) (name string, ok bool)
+}
+
+// Bearer is the Bearer scheme (RFC 6750) for opaque tokens.
+type Bearer struct {
+	Verifier TokenVerifier
+}
+
+func (b *Bearer) Name() string {
+	return "Bearer"
+}
+
+func (b *Bearer) Authenticate(req *request.Request, credentials string) (*Principal, error) {
+	if !isToken68(credentials) {
+		return nil, fmt.Errorf("%w: Bearer token has invalid characters", ErrMalformed)
+	}
+	name, ok := b.Verifier.VerifyToken(credentials)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown bearer token", ErrInvalid)
+	}
+	return &Principal{Name: name, Scheme: b.Name()}, nil
+}
+
+// Challenge adds the RFC 6750 error code when the client sent a token.
+func (b *Bearer) Challenge(realm string, err error) string {
+	challenge := "Bearer realm=" + quote(realm)
+	switch {
+	case errors.Is(err, ErrMalformed):
+		challenge += `, error="invalid_request"`
+	case errors.Is(err, ErrInvalid):
+		challenge += `, error="invalid_token"`
+	}
+	return challenge
+}
+
+// BearerToken returns the token of a request with Bearer credentials.
+func BearerToken(req *request.Request) (string, bool) {
+	value, ok := req.Headers.Get("Authorization")
+	if !ok {
+		return "", false
+	}
+	scheme, credentials := parseAuthorization(value)
+	if !strings.EqualFold(scheme, "Bearer") || !isToken68(credentials) {
+		return "", false
+	}
+	return credentials, true
+}
+
+// TokenList is a fixed set of bearer tokens.
+type TokenList struct {
+	// names maps the SHA-256 of each token to its owner, so looking a
+	// token up does not time how much of it matches
+	names map[[sha256.Size]byte]string
+}
+
+// NewTokenList returns a TokenList of tokens, each mapped to the name of
+// its owner.
+func NewTokenList(tokens map[string]string) *TokenList {
+	l := &TokenList{names: make(map[[sha256.Size]byte]string, len(tokens))}
+	for token, name := range tokens {
+		l.names[sha256.Sum256([]byte(token))] = name
+	}
+	return l
+}
+
+func (l *TokenList) VerifyToken(token string) (string, bool) {
+	name, ok := l.names[sha256.Sum256([]byte(token))]
+	return name, ok
+}
+
+// isToken68 reports whether s has the token68 syntax of RFC 9110.
+func isToken68(s string) bool {
+	s = strings.TrimRight(s, "=")
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~+/", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+// MaxRecordedBytes caps how much of a response is kept. Longer responses,
+// like streams or hijacked connections, are recorded as truncated.
+const MaxRecordedBytes = 1 << 20
+
+// Record is one captured exchange. Records are stored as JSON Lines, one
+// object per request.
+type Record struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Request    Request   `json:"request"`
+	Response   *Response `json:"response,omitempty"`
+	Timing     Timing    `json:"timing"`
+}
+
+type Request struct {
+	// Raw is exactly what was received, and what replay sends again.
+	Raw     []byte          `json:"raw"`
+	Method  string          `json:"method,omitempty"`
+	Target  string          `json:"target,omitempty"`
+	Version string          `json:"version,omitempty"`
+	Headers headers.Headers `json:"headers,omitempty"`
+	Body
+	// Error is set instead of the parsed fields when the request was
+	// malformed.
+	Error *ParseError `json:"error,omitempty"`
+}
+
+type Response struct {
+	Raw          []byte          `json:"raw"`
+	StatusCode   int             `json:"status_code,omitempty"`
+	ReasonPhrase string          `json:"reason_phrase,omitempty"`
+	Headers      headers.Headers `json:"headers,omitempty"`
+	Body
+	Trailers headers.Headers `json:"trailers,omitempty"`
+	// Truncated is set when only the first MaxRecordedBytes were kept.
+	Truncated bool        `json:"truncated,omitempty"`
+	Error     *ParseError `json:"error,omitempty"`
+}
+
+// Body holds a message body as text when it is valid UTF-8, so captures stay
+// readable, and as base64 otherwise.
+type Body struct {
+	Text   string `json:"body,omitempty"`
+	Base64 []byte `json:"body_base64,omitempty"`
+}
+
+func newBody(b []byte) Body {
+	if utf8.Valid(b) {
+		return Body{Text: string(b)}
+	}
+	return Body{Base64: b}
+}
+
+func (b Body) Bytes() []byte {
+	if b.Base64 != nil {
+		return b.Base64
+	}
+	return []byte(b.Text)
+}
+
+type ParseError struct {
+	Message string `json:"message"`
+	Stage   string `json:"stage"`
+	// Offset is the byte offset into Raw where parsing failed.
+	Offset int `json:"offset"`
+}
+
+// Timing is measured by whoever made the record. The server records how long
+// reading the request and running the handler took.
+type Timing struct {
+	Read   time.Duration `json:"read_ns"`
+	Handle time.Duration `json:"handle_ns"`
+	Total  time.Duration `json:"total_ns"`
+}
+
+// NewRequest records a request that parsed successfully from raw.
+func NewRequest(raw []byte, req *request.Request) Request {
+	return Request{
+		Raw:     raw,
+		Method:  req.RequestLine.Method,
+		Target:  req.RequestLine.RequestTarget,
+		Version: req.RequestLine.HttpVersion,
+		Headers: req.Headers,
+		Body:    newBody(req.Body),
+	}
+}
+
+// NewBadRequest records the bytes of a request that failed to parse. Errors
+// that are not parse errors, like timeouts, are placed at the end of raw.
+func NewBadRequest(raw []byte, err error) Request {
+	var perr *request.ParseError
+	if errors.As(err, &perr) {
+		return Request{Raw: raw, Error: &ParseError{Message: perr.Err.Error(), Stage: perr.Stage, Offset: perr.Offset}}
+	}
+	return Request{Raw: raw, Error: &ParseError{Message: err.Error(), Stage: "read", Offset: len(raw)}}
+}
+
+// NewResponse parses the raw bytes of a response to a request with the given
+// method. A response that cannot be parsed, for example because it was
+// truncated, keeps only its raw bytes and the error.
+func NewResponse(raw []byte, method string, truncated bool) *Response {
+	r := &Response{Raw: raw, Truncated: truncated}
+	resp, _, err := response.ReadResponse(bytes.NewReader(raw), method)
+	if err != nil {
+		r.Error = &ParseError{Message: err.Error(), Stage: "response"}
+		return r
+	}
+	r.StatusCode = int(resp.StatusLine.StatusCode)
+	r.ReasonPhrase = resp.StatusLine.ReasonPhrase
+	r.Headers = resp.Headers
+	r.Body = newBody(resp.Body)
+	r.Trailers = resp.Trailers
+	return r
+}
+
+// Recorder appends records to a writer as JSON Lines. It is safe for
+// concurrent use.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+func (r *Recorder) Write(rec *Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(rec)
+}
+
+// Reader reads records written by a Recorder.
+type Reader struct {
+	dec *json.Decoder
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next record, or io.EOF when there are no more.
+func (r *Reader) Next() (*Record, error) {
+	rec := &Record{}
+	err := r.dec.Decode(rec)
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// LimitedBuffer keeps the first MaxRecordedBytes written to it and discards
+// the rest. It is safe for concurrent use.
+type LimitedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	room := MaxRecordedBytes - b.buf.Len()
+	if len(p) > room {
+		b.truncated = true
+		b.buf.Write(p[:room])
+		return len(p), nil
+	}
+	b.buf.Write(p)
+	return len(p), nil
+}
+
+// Bytes returns a copy of what was kept and whether anything was discarded.
+func (b *LimitedBuffer) Bytes() ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes()), b.truncated
+}
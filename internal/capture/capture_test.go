@@ -0,0 +1,168 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorderAndReaderRoundTrip(t *testing.T) {
+	raw := []byte("POST /upload HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\n\xff\x00\x01")
+	req, err := request.RequestFromReader(bytes.NewReader(raw))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	rec := NewRecorder(buf)
+	require.NoError(t, rec.Write(&Record{
+		Time:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Request:  NewRequest(raw, req),
+		Response: NewResponse([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"), "POST", false),
+		Timing:   Timing{Read: time.Millisecond},
+	}))
+	require.NoError(t, rec.Write(&Record{Request: NewBadRequest([]byte("oops\r\n"), io.EOF)}))
+	// Test: one JSON object per line
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+
+	reader := NewReader(buf)
+	got, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, raw, got.Request.Raw)
+	assert.Equal(t, "POST", got.Request.Method)
+	assert.Equal(t, "/upload", got.Request.Target)
+	// Test: a binary body survives as base64
+	assert.Equal(t, []byte("\xff\x00\x01"), got.Request.Bytes())
+	assert.Equal(t, 200, got.Response.StatusCode)
+	assert.Equal(t, "ok", got.Response.Text)
+	assert.Equal(t, time.Millisecond, got.Timing.Read)
+
+	got, err = reader.Next()
+	require.NoError(t, err)
+	require.NotNil(t, got.Request.Error)
+	assert.Equal(t, "read", got.Request.Error.Stage)
+
+	_, err = reader.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestNewBadRequestKeepsOffset(t *testing.T) {
+	raw := []byte("GET / HTTP/1.1\r\nBad Header: x\r\n\r\n")
+	_, err := request.RequestFromReader(bytes.NewReader(raw))
+	require.Error(t, err)
+	r := NewBadRequest(raw, err)
+	require.NotNil(t, r.Error)
+	assert.Equal(t, "headers", r.Error.Stage)
+	assert.Equal(t, 16, r.Error.Offset)
+}
+
+func TestNewResponseChunkedWithTrailers(t *testing.T) {
+	raw := []byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\nX-Sum: 1\r\n\r\n")
+	r := NewResponse(raw, "GET", false)
+	assert.Nil(t, r.Error)
+	assert.Equal(t, "abc", r.Text)
+	assert.Equal(t, "1", r.Trailers["x-sum"])
+}
+
+func TestNewResponseTruncated(t *testing.T) {
+	r := NewResponse([]byte("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nabc"), "GET", true)
+	assert.True(t, r.Truncated)
+	assert.NotNil(t, r.Error)
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &LimitedBuffer{}
+	n, err := b.Write(bytes.Repeat([]byte("a"), MaxRecordedBytes-1))
+	require.NoError(t, err)
+	assert.Equal(t, MaxRecordedBytes-1, n)
+	// Test: writes always succeed, but only what fits is kept
+	n, err = b.Write([]byte("bcd"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	got, truncated := b.Bytes()
+	assert.True(t, truncated)
+	assert.Len(t, got, MaxRecordedBytes)
+	assert.Equal(t, byte('b'), got[len(got)-1])
+}
+
+func TestDiff(t *testing.T) {
+	want := &Response{
+		StatusCode: 200,
+		Headers:    headers.Headers{"content-type": "text/plain", "date": "Mon", "x-old": "1"},
+		Body:       Body{Text: "hello world"},
+	}
+	got := &Response{
+		StatusCode: 500,
+		Headers:    headers.Headers{"content-type": "text/html", "date": "Tue", "x-new": "2"},
+		Body:       Body{Text: "hello there"},
+	}
+	assert.Equal(t, []string{
+		"status: 200 != 500",
+		`header content-type: "text/plain" != "text/html"`,
+		`header x-new: missing != "2"`,
+		`header x-old: "1" != missing`,
+		`body: differs at byte 6 (11 bytes recorded, 11 replayed): "world" != "there"`,
+	}, Diff(want, got, []string{"Date"}))
+
+	assert.Empty(t, Diff(want, want, nil))
+	assert.Empty(t, Diff(nil, nil, nil))
+	assert.Equal(t, []string{"response: recorded 200, got none"}, Diff(want, nil, nil))
+
+	// Test: the body of a truncated recording is not compared
+	truncated := *want
+	truncated.Truncated = true
+	truncated.Body = Body{Text: "hello"}
+	assert.Empty(t, Diff(&truncated, want, nil))
+}
+
+func TestReplay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	received := make(chan *request.Request, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, _, err := request.ReadRequest(conn)
+		if err != nil {
+			return
+		}
+		received <- req
+		io.WriteString(conn, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 201 Created\r\nContent-Length: 4\r\n\r\ndone")
+	}()
+
+	rec := &Record{Request: Request{Raw: []byte("PUT /thing HTTP/1.1\r\nContent-Length: 1\r\n\r\nx"), Method: "PUT"}}
+	resp, err := Replay(ln.Addr().String(), rec, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "x", string((<-received).Body))
+	// Test: the interim response is skipped
+	require.NotNil(t, resp)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "done", resp.Text)
+}
+
+func TestReplayWithoutResponse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	rec := &Record{Request: Request{Raw: []byte("nonsense\r\n\r\n")}}
+	resp, err := Replay(ln.Addr().String(), rec, time.Second)
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+}
@@ -0,0 +1,142 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+// Replay sends the raw bytes of rec's request to addr and reads back the
+// response, skipping interim 1xx responses other than 101. It returns a nil
+// response if the server closed the connection without answering, which is
+// what this server does with requests it cannot parse.
+func Replay(addr string, rec *Record, timeout time.Duration) (*Response, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	_, err = conn.Write(rec.Request.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	method := rec.Request.Method
+	if method == "" {
+		method = "GET"
+	}
+	raw := &LimitedBuffer{}
+	var reader io.Reader = io.TeeReader(conn, raw)
+	for {
+		resp, rest, err := response.ReadResponse(reader, method)
+		if err != nil {
+			if got, _ := raw.Bytes(); len(got) == 0 {
+				return nil, nil
+			}
+			return nil, err
+		}
+		code := resp.StatusLine.StatusCode
+		if code >= 100 && code < 200 && code != response.StatusSwitchingProtocols {
+			reader = io.MultiReader(bytes.NewReader(rest), io.TeeReader(conn, raw))
+			continue
+		}
+		got, truncated := raw.Bytes()
+		// whatever was read past this response is not part of it
+		got = got[:max(0, len(got)-len(rest))]
+		return &Response{
+			Raw:          got,
+			StatusCode:   int(code),
+			ReasonPhrase: resp.StatusLine.ReasonPhrase,
+			Headers:      resp.Headers,
+			Body:         newBody(resp.Body),
+			Trailers:     resp.Trailers,
+			Truncated:    truncated,
+		}, nil
+	}
+}
+
+// Diff lists the differences between a recorded and a replayed response.
+// Headers and trailers named in ignore, such as "date", are not compared,
+// and neither is the body of a truncated recording.
+func Diff(want, got *Response, ignore []string) []string {
+	switch {
+	case want == nil && got == nil:
+		return nil
+	case want == nil:
+		return []string{fmt.Sprintf("response: none recorded, got %d", got.StatusCode)}
+	case got == nil:
+		return []string{fmt.Sprintf("response: recorded %d, got none", want.StatusCode)}
+	}
+
+	var diffs []string
+	if want.StatusCode != got.StatusCode {
+		diffs = append(diffs, fmt.Sprintf("status: %d != %d", want.StatusCode, got.StatusCode))
+	}
+	diffs = append(diffs, diffFields("header", want.Headers, got.Headers, ignore)...)
+	if !want.Truncated {
+		if d := diffBody(want.Bytes(), got.Bytes()); d != "" {
+			diffs = append(diffs, d)
+		}
+		diffs = append(diffs, diffFields("trailer", want.Trailers, got.Trailers, ignore)...)
+	}
+	return diffs
+}
+
+func diffFields(kind string, want, got headers.Headers, ignore []string) []string {
+	keys := map[string]bool{}
+	for key := range want {
+		keys[key] = true
+	}
+	for key := range got {
+		keys[key] = true
+	}
+	for _, name := range ignore {
+		delete(keys, strings.ToLower(name))
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	var diffs []string
+	for _, key := range sorted {
+		w, wok := want[key]
+		g, gok := got[key]
+		switch {
+		case !wok:
+			diffs = append(diffs, fmt.Sprintf("%s %s: missing != %q", kind, key, g))
+		case !gok:
+			diffs = append(diffs, fmt.Sprintf("%s %s: %q != missing", kind, key, w))
+		case w != g:
+			diffs = append(diffs, fmt.Sprintf("%s %s: %q != %q", kind, key, w, g))
+		}
+	}
+	return diffs
+}
+
+func diffBody(want, got []byte) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	at := 0
+	for at < len(want) && at < len(got) && want[at] == got[at] {
+		at++
+	}
+	return fmt.Sprintf("body: differs at byte %d (%d bytes recorded, %d replayed): %q != %q",
+		at, len(want), len(got), snippet(want, at), snippet(got, at))
+}
+
+func snippet(b []byte, at int) string {
+	end := min(len(b), at+32)
+	return string(b[at:end])
+}
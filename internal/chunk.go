@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ParseChunkSize reads the hex size from a chunk size line without its CRLF.
+// Chunk extensions after a semicolon are ignored. Only hex digits are
+// accepted, with no sign, and at most 15 of them so the size fits in an int.
+func ParseChunkSize(line []byte) (int, error) {
+	sizeText, _, _ := bytes.Cut(line, []byte(";"))
+	sizeText = bytes.TrimRight(sizeText, " \t")
+	if len(sizeText) == 0 || len(sizeText) > 15 {
+		return 0, fmt.Errorf("invalid chunk size line: %q", line)
+	}
+	size := 0
+	for _, c := range sizeText {
+		var digit byte
+		switch {
+		case c >= '0' && c <= '9':
+			digit = c - '0'
+		case c >= 'a' && c <= 'f':
+			digit = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			digit = c - 'A' + 10
+		default:
+			return 0, fmt.Errorf("invalid chunk size line: %q", line)
+		}
+		size = size<<4 | int(digit)
+	}
+	return size, nil
+}
@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChunkSize(t *testing.T) {
+	for line, want := range map[string]int{
+		"0":           0,
+		"1a":          26,
+		"FF":          255,
+		"a;ext=1":     10,
+		"a \t;ext":    10,
+		"fffffffffff": 0xfffffffffff,
+	} {
+		got, err := ParseChunkSize([]byte(line))
+		require.NoError(t, err, line)
+		assert.Equal(t, want, got, line)
+	}
+
+	// Test: signs, prefixes, spaces in front and oversized sizes are refused
+	for _, line := range []string{"", "+a", "-1", "0x1a", " a", "g", "1000000000000000"} {
+		_, err := ParseChunkSize([]byte(line))
+		assert.Error(t, err, line)
+	}
+}
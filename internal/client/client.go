@@ -0,0 +1,494 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+const (
+	DefaultDialTimeout           = 10 * time.Second
+	DefaultResponseHeaderTimeout = 30 * time.Second
+	DefaultWriteTimeout          = 30 * time.Second
+	DefaultBodyReadTimeout       = 30 * time.Second
+	DefaultIdleConnTimeout       = 90 * time.Second
+	DefaultMaxIdleConnsPerHost   = 2
+)
+
+const userAgent = "httpfromtcp"
+
+// DefaultClient is used by Get and by callers that need no configuration.
+var DefaultClient = &Client{}
+
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers headers.Headers
+	// Body is sent after the headers. With a ContentLength of -1 it is sent
+	// chunked, followed by Trailers.
+	Body          io.Reader
+	ContentLength int64
+	Trailers      headers.Headers
+}
+
+// NewRequest builds a request for an http or https URL. The content length
+// is filled in for bodies whose size is known up front.
+func NewRequest(method, rawURL string, body io.Reader) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("URL %q has no host", rawURL)
+	}
+	req := &Request{
+		Method:  method,
+		URL:     u,
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}
+	switch b := body.(type) {
+	case nil:
+	case *bytes.Reader:
+		req.ContentLength = int64(b.Len())
+	case *bytes.Buffer:
+		req.ContentLength = int64(b.Len())
+	case *strings.Reader:
+		req.ContentLength = int64(b.Len())
+	default:
+		req.ContentLength = -1
+	}
+	return req, nil
+}
+
+// Client sends HTTP/1.1 requests over raw TCP (or TLS) connections and keeps
+// idle connections around for reuse, per host.
+type Client struct {
+	// Zero values mean the package defaults.
+	DialTimeout time.Duration
+	// WriteTimeout bounds each write of the request, so a server that stops
+	// reading cannot stall an upload forever, however long the upload is.
+	WriteTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for the response headers once
+	// the request has been written.
+	ResponseHeaderTimeout time.Duration
+	// BodyReadTimeout bounds each read of the response body: the server must
+	// keep sending at least this often.
+	BodyReadTimeout     time.Duration
+	IdleConnTimeout     time.Duration
+	MaxIdleConnsPerHost int
+	// DisableKeepAlives sends "Connection: close" and never pools connections.
+	DisableKeepAlives bool
+	TLSConfig         *tls.Config
+	// Trace, if set, is told about connections and request progress.
+	Trace *Trace
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+type persistConn struct {
+	key       string
+	conn      net.Conn
+	br        *bufio.Reader
+	idleSince time.Time
+	reused    bool
+}
+
+// Get is shorthand for a GET request with DefaultClient.
+func Get(rawURL string) (*Response, error) {
+	return DefaultClient.Get(rawURL)
+}
+
+func (c *Client) Get(rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req and returns the response once its headers have arrived. The
+// caller must read the body to EOF or close it.
+func (c *Client) Do(req *Request) (*Response, error) {
+	if req.URL == nil {
+		return nil, errors.New("request has no URL")
+	}
+	for attempt := 0; ; attempt++ {
+		pc, err := c.getConn(req.URL)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.roundTrip(pc, req)
+		if err == nil {
+			return resp, nil
+		}
+		pc.conn.Close()
+		// the server may have closed a pooled connection while it sat idle;
+		// replay once on a fresh connection when that is safe
+		if pc.reused && attempt == 0 && canRetry(req) && isStaleConnErr(err) {
+			continue
+		}
+		return nil, err
+	}
+}
+
+// CloseIdleConnections closes every pooled connection.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conns := range c.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+func (c *Client) roundTrip(pc *persistConn, req *Request) (*Response, error) {
+	err := c.writeRequest(&deadlineWriter{conn: pc.conn, timeout: durationOr(c.WriteTimeout, DefaultWriteTimeout)}, req)
+	if c.Trace != nil && c.Trace.WroteRequest != nil {
+		c.Trace.WroteRequest(err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+	pc.conn.SetWriteDeadline(time.Time{})
+	// the header timer starts once the upload is done, so a slow upload does
+	// not look like a slow server
+	pc.conn.SetReadDeadline(time.Now().Add(durationOr(c.ResponseHeaderTimeout, DefaultResponseHeaderTimeout)))
+	if c.Trace != nil && c.Trace.GotFirstResponseByte != nil {
+		_, err = pc.br.Peek(1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read status line: %w", err)
+		}
+		c.Trace.GotFirstResponseByte()
+	}
+	resp, err := readResponse(pc.br, req.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &body{r: resp.Body, pc: pc, client: c, timeout: durationOr(c.BodyReadTimeout, DefaultBodyReadTimeout)}
+	b.reusable = c.canReuse(req, resp)
+	if resp.ContentLength == 0 {
+		// nothing to read, so the connection is free right away
+		b.finish(b.reusable)
+	}
+	resp.Body = b
+	return resp, nil
+}
+
+func (c *Client) writeRequest(w io.Writer, req *Request) error {
+	bw := bufio.NewWriter(w)
+	_, err := fmt.Fprintf(bw, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	if err != nil {
+		return err
+	}
+
+	h := headers.NewHeaders()
+	for key, value := range req.Headers {
+		h[key] = value
+	}
+	if _, ok := h.Get("Host"); !ok {
+		h.Override("host", req.URL.Host)
+	}
+	if _, ok := h.Get("User-Agent"); !ok {
+		h.Override("user-agent", userAgent)
+	}
+	// framing is ours to decide
+	h.Remove("content-length")
+	h.Remove("transfer-encoding")
+	chunked := req.Body != nil && req.ContentLength < 0
+	switch {
+	case chunked:
+		h.Override("transfer-encoding", "chunked")
+		if len(req.Trailers) > 0 {
+			names := make([]string, 0, len(req.Trailers))
+			for name := range req.Trailers {
+				names = append(names, name)
+			}
+			h.Override("trailer", strings.Join(names, ", "))
+		}
+	case req.Body != nil:
+		h.Override("content-length", strconv.FormatInt(req.ContentLength, 10))
+	case req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH":
+		h.Override("content-length", "0")
+	}
+	if c.DisableKeepAlives {
+		h.Override("connection", "close")
+	}
+	err = response.WriteHeaders(bw, h)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case chunked:
+		err = writeChunked(bw, req.Body, req.Trailers)
+	case req.Body != nil:
+		var n int64
+		n, err = io.CopyN(bw, req.Body, req.ContentLength)
+		if err != nil && n < req.ContentLength {
+			err = fmt.Errorf("request body shorter than ContentLength %d: %w", req.ContentLength, err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// deadlineWriter moves the write deadline forward before every write.
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	d.conn.SetWriteDeadline(time.Now().Add(d.timeout))
+	return d.conn.Write(p)
+}
+
+func writeChunked(w io.Writer, body io.Reader, trailers headers.Headers) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			_, werr := fmt.Fprintf(w, "%x\r\n%s\r\n", n, buf[:n])
+			if werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "0\r\n")
+	if err != nil {
+		return err
+	}
+	if trailers == nil {
+		trailers = headers.NewHeaders()
+	}
+	return response.WriteHeaders(w, trailers)
+}
+
+// canReuse decides whether the connection may go back to the pool once the
+// response body has been read.
+func (c *Client) canReuse(req *Request, resp *Response) bool {
+	if c.DisableKeepAlives || resp.closeDelimited || resp.StatusCode == 101 {
+		return false
+	}
+	if v, ok := req.Headers.Get("Connection"); ok && hasToken(v, "close") {
+		return false
+	}
+	connection, _ := resp.Headers.Get("Connection")
+	if hasToken(connection, "close") {
+		return false
+	}
+	// HTTP/1.0 servers only keep the connection open when they say so
+	if resp.Proto == "1.0" && !hasToken(connection, "keep-alive") {
+		return false
+	}
+	return true
+}
+
+func (c *Client) getConn(u *url.URL) (*persistConn, error) {
+	key := connKey(u)
+	c.mu.Lock()
+	idleTimeout := durationOr(c.IdleConnTimeout, DefaultIdleConnTimeout)
+	for len(c.idle[key]) > 0 {
+		conns := c.idle[key]
+		pc := conns[len(conns)-1]
+		c.idle[key] = conns[:len(conns)-1]
+		if time.Since(pc.idleSince) > idleTimeout {
+			pc.conn.Close()
+			continue
+		}
+		c.mu.Unlock()
+		pc.reused = true
+		if c.Trace != nil && c.Trace.GotConn != nil {
+			c.Trace.GotConn(true)
+		}
+		return pc, nil
+	}
+	c.mu.Unlock()
+
+	conn, err := c.dial(u)
+	if err != nil {
+		return nil, err
+	}
+	if c.Trace != nil {
+		conn = &wireConn{Conn: conn, trace: c.Trace}
+		if c.Trace.GotConn != nil {
+			c.Trace.GotConn(false)
+		}
+	}
+	return &persistConn{key: key, conn: conn, br: bufio.NewReader(conn)}, nil
+}
+
+func (c *Client) putIdle(pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	max := c.MaxIdleConnsPerHost
+	if max <= 0 {
+		max = DefaultMaxIdleConnsPerHost
+	}
+	if len(c.idle[pc.key]) >= max {
+		pc.conn.Close()
+		return
+	}
+	if c.idle == nil {
+		c.idle = make(map[string][]*persistConn)
+	}
+	pc.idleSince = time.Now()
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+}
+
+func (c *Client) dial(u *url.URL) (net.Conn, error) {
+	addr := hostPort(u)
+	if c.Trace != nil && c.Trace.ConnectStart != nil {
+		c.Trace.ConnectStart("tcp", addr)
+	}
+	conn, err := c.dialAddr(u, addr)
+	if c.Trace != nil && c.Trace.ConnectDone != nil {
+		c.Trace.ConnectDone("tcp", addr, err)
+	}
+	return conn, err
+}
+
+func (c *Client) dialAddr(u *url.URL, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: durationOr(c.DialTimeout, DefaultDialTimeout)}
+	if u.Scheme != "https" {
+		return dialer.Dial("tcp", addr)
+	}
+	cfg := &tls.Config{}
+	if c.TLSConfig != nil {
+		cfg = c.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+	return tls.DialWithDialer(dialer, "tcp", addr, cfg)
+}
+
+// body releases the connection back to the pool once the response body has
+// been read to the end, or closes it if the body is abandoned.
+type body struct {
+	r        io.Reader
+	pc       *persistConn
+	client   *Client
+	reusable bool
+	// timeout is the client's BodyReadTimeout
+	timeout time.Duration
+
+	mu   sync.Mutex
+	done bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	done := b.done
+	b.mu.Unlock()
+	if done {
+		return 0, io.EOF
+	}
+	// not holding the lock while reading lets Close interrupt a blocked Read
+	b.pc.conn.SetReadDeadline(time.Now().Add(b.timeout))
+	n, err := b.r.Read(p)
+	if err == nil {
+		return n, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.done {
+		b.finish(errors.Is(err, io.EOF) && b.reusable)
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.done {
+		b.finish(false)
+	}
+	return nil
+}
+
+func (b *body) finish(reuse bool) {
+	b.done = true
+	if reuse {
+		b.client.putIdle(b.pc)
+		return
+	}
+	b.pc.conn.Close()
+}
+
+func canRetry(req *Request) bool {
+	if req.Body != nil {
+		return false
+	}
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "DELETE", "PUT", "TRACE":
+		return true
+	}
+	return false
+}
+
+func isStaleConnErr(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+func connKey(u *url.URL) string {
+	return u.Scheme + "://" + hostPort(u)
+}
+
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// hasToken reports whether the comma separated header value contains token,
+// compared case-insensitively.
+func hasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func durationOr(d, fallback time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return fallback
+}
@@ -0,0 +1,346 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentLengthResponseReusesConnection(t *testing.T) {
+	url, accepted := startServer(t, func(conn net.Conn) {
+		serveKeepAlive(conn, func(req *request.Request) string {
+			body := "you asked for " + req.RequestLine.RequestTarget
+			return "HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+		})
+	})
+
+	c := &Client{}
+	for _, path := range []string{"/one", "/two", "/three"} {
+		resp, err := c.Get(url + path)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "OK", resp.Reason)
+		assert.Equal(t, "1.1", resp.Proto)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "you asked for "+path, string(body))
+	}
+	assert.Equal(t, int32(1), accepted.Load())
+}
+
+func TestChunkedResponseWithTrailers(t *testing.T) {
+	url, _ := startServer(t, func(conn net.Conn) {
+		serveKeepAlive(conn, func(req *request.Request) string {
+			return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n" +
+				"5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Sum: 42\r\n\r\n"
+		})
+	})
+
+	resp, err := (&Client{}).Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "42", resp.Trailers["x-sum"])
+}
+
+func TestCloseDelimitedResponse(t *testing.T) {
+	url, accepted := startServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		request.ReadRequest(conn)
+		io.WriteString(conn, "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nread until close")
+	})
+
+	c := &Client{}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(url)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "read until close", string(body))
+	}
+	// a close-delimited body can never leave the connection reusable
+	assert.Equal(t, int32(2), accepted.Load())
+}
+
+func TestTruncatedContentLengthBody(t *testing.T) {
+	url, _ := startServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		request.ReadRequest(conn)
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort")
+	})
+
+	resp, err := (&Client{}).Get(url)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestHeadResponseHasNoBody(t *testing.T) {
+	url, accepted := startServer(t, func(conn net.Conn) {
+		serveKeepAlive(conn, func(req *request.Request) string {
+			if req.RequestLine.Method == "HEAD" {
+				return "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n"
+			}
+			return "HTTP/1.1 204 No Content\r\n\r\n"
+		})
+	})
+
+	c := &Client{}
+	req, err := NewRequest("HEAD", url, nil)
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	resp, err = c.Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, 204, resp.StatusCode)
+	assert.Equal(t, int32(1), accepted.Load())
+}
+
+func TestInterimResponsesAreSkipped(t *testing.T) {
+	url, _ := startServer(t, func(conn net.Conn) {
+		serveKeepAlive(conn, func(req *request.Request) string {
+			return "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n"
+		})
+	})
+	resp, err := (&Client{}).Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+}
+
+func TestChunkedUploadWithTrailers(t *testing.T) {
+	received := make(chan string, 1)
+	url, _ := startServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		raw := &strings.Builder{}
+		for !strings.HasSuffix(raw.String(), "0\r\nx-done: yes\r\n\r\n") {
+			b, err := br.ReadByte()
+			if err != nil {
+				return
+			}
+			raw.WriteByte(b)
+		}
+		received <- raw.String()
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	})
+
+	// io.MultiReader hides the length, so the body goes out chunked
+	req, err := NewRequest("POST", url+"/upload", io.MultiReader(strings.NewReader("abc"), strings.NewReader("def")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), req.ContentLength)
+	req.Trailers = headers.Headers{"x-done": "yes"}
+	_, err = (&Client{}).Do(req)
+	require.NoError(t, err)
+
+	raw := <-received
+	assert.True(t, strings.HasPrefix(raw, "POST /upload HTTP/1.1\r\n"))
+	assert.Contains(t, raw, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, raw, "trailer: x-done\r\n")
+	assert.Contains(t, raw, "\r\n\r\n3\r\nabc\r\n3\r\ndef\r\n0\r\nx-done: yes\r\n\r\n")
+}
+
+func TestContentLengthUpload(t *testing.T) {
+	seen := make(chan *request.Request, 1)
+	url, _ := startServer(t, func(conn net.Conn) {
+		serveKeepAlive(conn, func(req *request.Request) string {
+			seen <- req
+			return "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+		})
+	})
+	req, err := NewRequest("PUT", url+"/thing?x=1", bytes.NewReader([]byte("payload")))
+	require.NoError(t, err)
+	_, err = (&Client{}).Do(req)
+	require.NoError(t, err)
+
+	got := <-seen
+	assert.Equal(t, "/thing?x=1", got.RequestLine.RequestTarget)
+	assert.Equal(t, "payload", string(got.Body))
+	ua, _ := got.Headers.Get("User-Agent")
+	assert.Equal(t, "httpfromtcp", ua)
+}
+
+// slowReader hands out its data one byte per read, pausing before each.
+type slowReader struct {
+	data  string
+	pause time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.EOF
+	}
+	time.Sleep(r.pause)
+	p[0] = r.data[0]
+	r.data = r.data[1:]
+	return 1, nil
+}
+
+func TestSlowUploadIsNotAHeaderTimeout(t *testing.T) {
+	url, _ := startServer(t, func(conn net.Conn) {
+		serveKeepAlive(conn, func(req *request.Request) string {
+			return "HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(req.Body)) + "\r\n\r\n" + string(req.Body)
+		})
+	})
+	req, err := NewRequest("POST", url, &slowReader{data: "trickle", pause: 20 * time.Millisecond})
+	require.NoError(t, err)
+
+	// Test: the upload takes longer than the header timeout, but each write is quick
+	c := &Client{ResponseHeaderTimeout: 50 * time.Millisecond}
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "trickle", string(body))
+}
+
+func TestStalledBodyTimesOut(t *testing.T) {
+	url, _ := startServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		request.ReadRequest(conn)
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhi")
+		// never sends the rest
+		time.Sleep(time.Second)
+	})
+
+	c := &Client{BodyReadTimeout: 50 * time.Millisecond}
+	resp, err := c.Get(url)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = io.ReadAll(resp.Body)
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestStalePooledConnectionIsRetried(t *testing.T) {
+	// the server answers one request per connection but never says so
+	url, accepted := startServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		request.ReadRequest(conn)
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	})
+
+	c := &Client{}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(url)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(body))
+		// give the server a moment to close the idle connection
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, int32(2), accepted.Load())
+}
+
+func TestConnectionCloseIsNotPooled(t *testing.T) {
+	url, accepted := startServer(t, func(conn net.Conn) {
+		serveKeepAlive(conn, func(req *request.Request) string {
+			return "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 0\r\n\r\n"
+		})
+	})
+	c := &Client{}
+	for i := 0; i < 2; i++ {
+		_, err := c.Get(url)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), accepted.Load())
+}
+
+func TestBadStatusLine(t *testing.T) {
+	url, _ := startServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		request.ReadRequest(conn)
+		io.WriteString(conn, "HTTP/2 200 OK\r\n\r\n")
+	})
+	_, err := (&Client{}).Get(url)
+	require.Error(t, err)
+}
+
+func TestTraceHooksAndWireCopies(t *testing.T) {
+	url, _ := startServer(t, func(conn net.Conn) {
+		serveKeepAlive(conn, func(req *request.Request) string {
+			return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nhi"
+		})
+	})
+
+	var events []string
+	out, in := &strings.Builder{}, &strings.Builder{}
+	c := &Client{Trace: &Trace{
+		ConnectStart:         func(network, addr string) { events = append(events, "connect") },
+		ConnectDone:          func(network, addr string, err error) { events = append(events, "connected") },
+		GotConn:              func(reused bool) { events = append(events, "conn reused="+strconv.FormatBool(reused)) },
+		WroteRequest:         func(err error) { events = append(events, "wrote") },
+		GotFirstResponseByte: func() { events = append(events, "first byte") },
+		WireOut:              out,
+		WireIn:               in,
+	}}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(url + "/traced")
+		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{
+		"connect", "connected", "conn reused=false", "wrote", "first byte",
+		"conn reused=true", "wrote", "first byte",
+	}, events)
+	assert.True(t, strings.HasPrefix(out.String(), "GET /traced HTTP/1.1\r\n"))
+	assert.Equal(t, strings.Repeat("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nhi", 2), in.String())
+}
+
+// startServer accepts connections on a random port and hands each to handle.
+// It returns the base URL and a count of accepted connections.
+func startServer(t *testing.T, handle func(net.Conn)) (string, *atomic.Int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	accepted := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go handle(conn)
+		}
+	}()
+	return "http://" + ln.Addr().String(), accepted
+}
+
+// serveKeepAlive answers requests on conn until the client goes away.
+func serveKeepAlive(conn net.Conn, respond func(*request.Request) string) {
+	defer conn.Close()
+	var reader io.Reader = conn
+	for {
+		req, rest, err := request.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		reader = io.MultiReader(bytes.NewReader(rest), conn)
+		_, err = io.WriteString(conn, respond(req))
+		if err != nil {
+			return
+		}
+	}
+}
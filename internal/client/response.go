@@ -0,0 +1,189 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jonvanw/httpfromtcp/internal"
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+type Response struct {
+	// Proto is the version from the status line, e.g. "1.1".
+	Proto      string
+	StatusCode int
+	Reason     string
+	Headers    headers.Headers
+	// Body streams the decoded body from the connection. It must be read to
+	// EOF or closed; reading to EOF lets the connection be reused.
+	Body io.ReadCloser
+	// Trailers is filled in once a chunked body has been read to the end.
+	Trailers headers.Headers
+	// ContentLength is the declared body length, or -1 if unknown.
+	ContentLength int64
+
+	// closeDelimited is set when the body runs until the server closes the
+	// connection, which therefore cannot be reused.
+	closeDelimited bool
+}
+
+// readResponse reads the status line and headers of a response and sets up
+// its body reader. Interim 1xx responses are skipped.
+func readResponse(br *bufio.Reader, method string) (*Response, error) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read status line: %w", err)
+		}
+		n, statusLine, err := response.ParseStatusLine([]byte(line))
+		if err != nil {
+			return nil, err
+		}
+		if n != len(line) {
+			return nil, fmt.Errorf("malformed status line: %q", line)
+		}
+		resp := &Response{
+			Proto:         statusLine.HttpVersion,
+			StatusCode:    int(statusLine.StatusCode),
+			Reason:        statusLine.ReasonPhrase,
+			ContentLength: -1,
+		}
+		resp.Headers, err = readHeaders(br)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != 101 {
+			continue
+		}
+		body, err := bodyReader(resp, br, method)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(body)
+		return resp, nil
+	}
+}
+
+// readHeaders reads header lines up to and including the blank line.
+func readHeaders(br *bufio.Reader) (headers.Headers, error) {
+	h := headers.NewHeaders()
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read headers: %w", err)
+		}
+		n, done, err := h.Parse([]byte(line))
+		if err != nil {
+			return nil, err
+		}
+		if n != len(line) {
+			return nil, fmt.Errorf("malformed header line: %q", line)
+		}
+		if done {
+			return h, nil
+		}
+	}
+}
+
+// bodyReader streams the body with the same framing rules the response
+// parser uses.
+func bodyReader(resp *Response, br *bufio.Reader, method string) (io.Reader, error) {
+	framing, length, err := response.BodyFraming(method, response.StatusCode(resp.StatusCode), resp.Headers)
+	if err != nil {
+		return nil, err
+	}
+	switch framing {
+	case response.FramingNone:
+		resp.ContentLength = 0
+		return strings.NewReader(""), nil
+	case response.FramingChunked:
+		return &chunkedReader{br: br, resp: resp}, nil
+	case response.FramingLength:
+		resp.ContentLength = length
+		return &exactReader{r: io.LimitReader(br, length), remaining: length}, nil
+	default:
+		// no framing: the body runs until the server closes the connection
+		resp.closeDelimited = true
+		return br, nil
+	}
+}
+
+// exactReader turns an early EOF from a Content-Length body into an error,
+// so truncated responses are never mistaken for complete ones.
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	e.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && e.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// chunkedReader decodes a chunked body, storing any trailers on resp.
+type chunkedReader struct {
+	br        *bufio.Reader
+	resp      *Response
+	remaining int64
+	done      bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remaining == 0 {
+		line, err := c.br.ReadString('\n')
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		n, err := internal.ParseChunkSize([]byte(strings.TrimSuffix(line, internal.CRLF)))
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			trailers, err := readHeaders(c.br)
+			if err != nil {
+				return 0, err
+			}
+			c.resp.Trailers = trailers
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = int64(n)
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.remaining -= int64(n)
+	if err != nil {
+		return n, unexpectedEOF(err)
+	}
+	if c.remaining == 0 {
+		crlf := make([]byte, 2)
+		_, err := io.ReadFull(c.br, crlf)
+		if err != nil {
+			return n, unexpectedEOF(err)
+		}
+		if string(crlf) != internal.CRLF {
+			return n, errors.New("missing CRLF after chunk data")
+		}
+	}
+	return n, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"io"
+	"net"
+)
+
+// Trace holds optional hooks that are called as a request makes its way over
+// the wire. Set it before the client is first used; connections dialled
+// without a trace are never traced.
+type Trace struct {
+	ConnectStart         func(network, addr string)
+	ConnectDone          func(network, addr string, err error)
+	GotConn              func(reused bool)
+	WroteRequest         func(err error)
+	GotFirstResponseByte func()
+
+	// WireOut and WireIn receive a copy of every byte written to and read
+	// from the connection. For https this is the plaintext inside TLS.
+	WireOut io.Writer
+	WireIn  io.Writer
+}
+
+// wireConn copies connection traffic to the trace's wire writers.
+type wireConn struct {
+	net.Conn
+	trace *Trace
+}
+
+func (w *wireConn) Read(p []byte) (int, error) {
+	n, err := w.Conn.Read(p)
+	if n > 0 && w.trace.WireIn != nil {
+		w.trace.WireIn.Write(p[:n])
+	}
+	return n, err
+}
+
+func (w *wireConn) Write(p []byte) (int, error) {
+	n, err := w.Conn.Write(p)
+	if n > 0 && w.trace.WireOut != nil {
+		w.trace.WireOut.Write(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,485 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/server"
+)
+
+// DefaultMinSize is the smallest body compressed when Compressor.MinSize is
+// not set. Below about a packet's worth, compression rarely pays for itself.
+const DefaultMinSize = 1024
+
+// DefaultTypes are the media types compressed when Compressor.Types is not
+// set. Anything else, such as images and video, is usually compressed
+// already.
+var DefaultTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"image/svg+xml",
+	"*+json",
+	"*+xml",
+}
+
+// Compressor is middleware that gzip or deflate encodes response bodies for
+// clients that accept it. Compressed responses are sent chunked.
+type Compressor struct {
+	// MinSize is the smallest body, in bytes, worth compressing. Zero means
+	// DefaultMinSize.
+	MinSize int
+	// Level is the gzip and deflate compression level. Zero means
+	// gzip.DefaultCompression.
+	Level int
+	// Types lists the media types to compress: exact types like
+	// "application/json", whole families like "text/*", and structured
+	// syntax suffixes like "*+json". Nil means DefaultTypes.
+	Types []string
+
+	gzipWriters    sync.Pool
+	deflateWriters sync.Pool
+}
+
+// Wrap returns a handler that compresses what next writes.
+func (c *Compressor) Wrap(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+		f := &filter{w: w, c: c, encoding: Negotiate(acceptEncoding)}
+		if req.RequestLine.Method == "HEAD" {
+			f.encoding = ""
+		}
+		next(response.NewFilterWriter(w, f), req)
+		f.finish()
+	}
+}
+
+// Negotiate picks the encoding to use for an Accept-Encoding header value:
+// "gzip", "deflate", or "" for none. It honours q-values, treats "*" as any
+// coding not listed, and prefers gzip when both are equally acceptable.
+func Negotiate(acceptEncoding string) string {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q, ok := parseQ(params)
+		if !ok {
+			continue
+		}
+		weights[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := weights[coding]
+		if !ok && coding == "gzip" {
+			q, ok = weights["x-gzip"]
+		}
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	// a client may rank the uncompressed form above every coding we offer
+	if q, ok := weights["identity"]; ok && q > bestQ {
+		return ""
+	}
+	return best
+}
+
+// parseQ reads the q parameter from the parameters after a coding. A coding
+// without one has weight 1; one with a malformed weight is ignored.
+func parseQ(params string) (float64, bool) {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0, false
+		}
+		return q, true
+	}
+	return 1, true
+}
+
+func (c *Compressor) minSize() int {
+	if c.MinSize > 0 {
+		return c.MinSize
+	}
+	return DefaultMinSize
+}
+
+func (c *Compressor) level() int {
+	if c.Level != 0 {
+		return c.Level
+	}
+	return gzip.DefaultCompression
+}
+
+// compressible reports whether a Content-Type is one of c.Types.
+func (c *Compressor) compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	types := c.Types
+	if types == nil {
+		types = DefaultTypes
+	}
+	for _, t := range types {
+		switch {
+		case strings.HasPrefix(t, "*+"):
+			if strings.HasSuffix(mediaType, t[1:]) {
+				return true
+			}
+		case strings.HasSuffix(t, "/*"):
+			if strings.HasPrefix(mediaType, t[:len(t)-1]) {
+				return true
+			}
+		case mediaType == t:
+			return true
+		}
+	}
+	return false
+}
+
+// encoder is what gzip.Writer and zlib.Writer have in common.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+func (c *Compressor) getEncoder(encoding string, dst io.Writer) (encoder, error) {
+	pool := &c.gzipWriters
+	if encoding == "deflate" {
+		pool = &c.deflateWriters
+	}
+	if e, ok := pool.Get().(encoder); ok {
+		e.Reset(dst)
+		return e, nil
+	}
+	// "deflate" in HTTP means the zlib format, not raw deflate
+	if encoding == "deflate" {
+		return zlib.NewWriterLevel(dst, c.level())
+	}
+	return gzip.NewWriterLevel(dst, c.level())
+}
+
+func (c *Compressor) putEncoder(encoding string, e encoder) {
+	if encoding == "deflate" {
+		c.deflateWriters.Put(e)
+	} else {
+		c.gzipWriters.Put(e)
+	}
+}
+
+type filterState int
+
+const (
+	// the headers have not been written yet
+	filterStateInitialized filterState = iota
+	// a chunked body is held back until it is large enough to compress
+	filterStateDeciding
+	filterStatePassthrough
+	filterStateCompressing
+)
+
+var errTrailersAfterBody = errors.New("compress: cannot write trailers after WriteBody")
+
+// filter is the response.Filter behind a Compressor. It decides whether to
+// compress once it knows the content type and either the body size or enough
+// of the body.
+type filter struct {
+	w        *response.Writer
+	c        *Compressor
+	encoding string
+	state    filterState
+	status   response.StatusCode
+	header   headers.Headers
+	// pending holds body bytes written while deciding
+	pending bytes.Buffer
+	enc     encoder
+	// fixedBody is set when the handler wrote a Content-Length body that is
+	// being sent compressed and chunked instead
+	fixedBody bool
+	// out collects the encoder's output until it is sent as a chunk
+	out bytes.Buffer
+}
+
+func (f *filter) WriteStatusLine(statusCode response.StatusCode) error {
+	f.status = statusCode
+	return f.w.WriteStatusLine(statusCode)
+}
+
+func (f *filter) WriteHeaders(h headers.Headers) error {
+	contentType, _ := h.Get("Content-Type")
+	typeOK := f.c.compressible(contentType)
+	if typeOK {
+		// caches must not hand a compressed copy to clients that cannot read it
+		addVary(h, "Accept-Encoding")
+	}
+	_, encoded := h.Get("Content-Encoding")
+	_, isRange := h.Get("Content-Range")
+	if !typeOK || encoded || isRange || f.encoding == "" || !bodyAllowed(f.status) {
+		f.state = filterStatePassthrough
+		return f.w.WriteHeaders(h)
+	}
+	if value, ok := h.Get("Content-Length"); ok {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < f.c.minSize() {
+			f.state = filterStatePassthrough
+			return f.w.WriteHeaders(h)
+		}
+		f.header = h
+		return f.startCompressing()
+	}
+	// the size is unknown, so hold back until enough of the body arrives
+	f.header = h
+	f.state = filterStateDeciding
+	return nil
+}
+
+func (f *filter) WriteBody(p []byte) (int, error) {
+	switch f.state {
+	case filterStateDeciding:
+		// without a Content-Length, go by the size of the first write
+		if len(p) < f.c.minSize() {
+			f.state = filterStatePassthrough
+			if err := f.w.WriteHeaders(f.header); err != nil {
+				return 0, err
+			}
+			return f.w.WriteBody(p)
+		}
+		if err := f.startCompressing(); err != nil {
+			return 0, err
+		}
+	case filterStateCompressing:
+	default:
+		return f.w.WriteBody(p)
+	}
+	// the body is only known to be complete when the handler returns, so
+	// finish ends the compressed stream
+	f.fixedBody = true
+	if _, err := f.enc.Write(p); err != nil {
+		return 0, err
+	}
+	if err := f.sendOutput(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom keeps the zero-copy path of the underlying Writer for bodies that
+// are not being compressed, such as video.
+func (f *filter) ReadFrom(r io.Reader) (int64, error) {
+	if f.state == filterStatePassthrough {
+		return f.w.ReadFrom(r)
+	}
+	return io.Copy(bodyWriter{f}, r)
+}
+
+// bodyWriter feeds WriteBody without exposing ReadFrom, so io.Copy into it
+// does not loop back.
+type bodyWriter struct {
+	f *filter
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.f.WriteBody(p)
+}
+
+func (f *filter) WriteChunkedBody(p []byte) (int, error) {
+	switch f.state {
+	case filterStateDeciding:
+		f.pending.Write(p)
+		if f.pending.Len() < f.c.minSize() {
+			return len(p), nil
+		}
+		if err := f.startCompressing(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	case filterStateCompressing:
+		if _, err := f.enc.Write(p); err != nil {
+			return 0, err
+		}
+		if err := f.sendOutput(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	default:
+		return f.w.WriteChunkedBody(p)
+	}
+}
+
+func (f *filter) WriteChunkedBodyDone() (int, error) {
+	switch f.state {
+	case filterStateDeciding:
+		// the whole body turned out too small to bother
+		if err := f.passPending(); err != nil {
+			return 0, err
+		}
+	case filterStateCompressing:
+		if err := f.closeEncoder(); err != nil {
+			return 0, err
+		}
+		f.state = filterStatePassthrough
+		return 0, nil
+	}
+	return f.w.WriteChunkedBodyDone()
+}
+
+func (f *filter) WriteTrailers(h headers.Headers) error {
+	if f.fixedBody {
+		return errTrailersAfterBody
+	}
+	return f.w.WriteTrailers(h)
+}
+
+func (f *filter) Flush() error {
+	switch f.state {
+	case filterStateDeciding:
+		// the handler wants what it has written on the wire now, like a
+		// stream would; it is not worth compressing if it is still small
+		if err := f.passPending(); err != nil {
+			return err
+		}
+	case filterStateCompressing:
+		if err := f.enc.Flush(); err != nil {
+			return err
+		}
+		if err := f.sendOutput(); err != nil {
+			return err
+		}
+	}
+	return f.w.Flush()
+}
+
+// finish runs after the handler returns, so that a body still held back is
+// not lost.
+func (f *filter) finish() {
+	if f.state == filterStateDeciding {
+		f.passPending()
+	}
+	if f.state == filterStateCompressing && f.fixedBody {
+		if err := f.closeEncoder(); err != nil {
+			log.Printf("Error finishing compressed body: %v", err)
+		} else if err := f.w.WriteTrailers(headers.NewHeaders()); err != nil {
+			log.Printf("Error finishing compressed body: %v", err)
+		}
+	}
+	if f.enc != nil {
+		f.c.putEncoder(f.encoding, f.enc)
+		f.enc = nil
+	}
+}
+
+// startCompressing writes the headers for an encoded, chunked body and
+// compresses anything already held back.
+func (f *filter) startCompressing() error {
+	h := f.header
+	h.Remove("Content-Length")
+	h.Override("Content-Encoding", f.encoding)
+	h.Override("Transfer-Encoding", "chunked")
+	// the encoded bytes differ, so a strong validator no longer applies
+	if etag, ok := h.Get("ETag"); ok && !strings.HasPrefix(etag, "W/") {
+		h.Override("ETag", "W/"+etag)
+	}
+	if err := f.w.WriteHeaders(h); err != nil {
+		return err
+	}
+	enc, err := f.c.getEncoder(f.encoding, &f.out)
+	if err != nil {
+		return err
+	}
+	f.enc = enc
+	f.state = filterStateCompressing
+	if f.pending.Len() == 0 {
+		return nil
+	}
+	if _, err := f.enc.Write(f.pending.Bytes()); err != nil {
+		return err
+	}
+	f.pending.Reset()
+	return f.sendOutput()
+}
+
+// passPending gives up on compressing and sends the headers and anything
+// held back as they are.
+func (f *filter) passPending() error {
+	f.state = filterStatePassthrough
+	if err := f.w.WriteHeaders(f.header); err != nil {
+		return err
+	}
+	if f.pending.Len() == 0 {
+		return nil
+	}
+	_, err := f.w.WriteChunkedBody(f.pending.Bytes())
+	f.pending.Reset()
+	return err
+}
+
+// closeEncoder sends the end of the compressed stream and the last chunk.
+func (f *filter) closeEncoder() error {
+	if err := f.enc.Close(); err != nil {
+		return err
+	}
+	if err := f.sendOutput(); err != nil {
+		return err
+	}
+	_, err := f.w.WriteChunkedBodyDone()
+	return err
+}
+
+// sendOutput writes what the encoder has produced so far as a chunk. An
+// empty chunk would end the body, so nothing is sent if there is none.
+func (f *filter) sendOutput() error {
+	if f.out.Len() == 0 {
+		return nil
+	}
+	_, err := f.w.WriteChunkedBody(f.out.Bytes())
+	f.out.Reset()
+	return err
+}
+
+// bodyAllowed reports whether responses with this status carry a body.
+func bodyAllowed(status response.StatusCode) bool {
+	return status >= 200 && status != 204 && status != 206 && status != 304
+}
+
+// addVary adds field to the Vary header unless it is already covered.
+func addVary(h headers.Headers, field string) {
+	vary, ok := h.Get("Vary")
+	if !ok {
+		h.Override("Vary", field)
+		return
+	}
+	for _, existing := range strings.Split(vary, ",") {
+		existing = strings.TrimSpace(existing)
+		if existing == "*" || strings.EqualFold(existing, field) {
+			return
+		}
+	}
+	h.Append("Vary", field)
+}
@@ -0,0 +1,269 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var page = strings.Repeat("<p>The quick brown fox jumps over the lazy dog.</p>\n", 100)
+
+func TestNegotiate(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                             "",
+		"gzip":                         "gzip",
+		"deflate":                      "deflate",
+		"gzip, deflate, br":            "gzip",
+		"deflate, gzip;q=0.5":          "deflate",
+		"gzip;q=0, deflate;q=0.1":      "deflate",
+		"br":                           "",
+		"*":                            "gzip",
+		"*;q=0.3, gzip;q=0":            "deflate",
+		"x-gzip":                       "gzip",
+		"GZIP;Q=0.7":                   "gzip",
+		"gzip;q=0.5, identity":         "",
+		"gzip;q=1.5, deflate;q=0.2":    "deflate",
+		"gzip;q=abc":                   "",
+		"identity;q=0, gzip;q=0.001":   "gzip",
+		" gzip ; q=0.8 , deflate ;q=1": "deflate",
+	} {
+		assert.Equal(t, want, Negotiate(header), "%q", header)
+	}
+}
+
+func fixedBody(contentType, body string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetDefaultHeaders(len(body))
+		h.Override("content-type", contentType)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func chunkedBody(chunks ...string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetDefaultHeaders(0)
+		h.Remove("content-length")
+		h.Override("content-type", "application/json")
+		h.Override("transfer-encoding", "chunked")
+		w.WriteHeaders(h)
+		for _, chunk := range chunks {
+			w.WriteChunkedBody([]byte(chunk))
+		}
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.Headers{"x-chunks": strconv.Itoa(len(chunks))})
+	}
+}
+
+func get(t *testing.T, handler func(w *response.Writer, req *request.Request), acceptEncoding string) *servertest.Result {
+	t.Helper()
+	var h map[string]string
+	if acceptEncoding != "" {
+		h = map[string]string{"accept-encoding": acceptEncoding}
+	}
+	return servertest.Run(t, (&Compressor{}).Wrap(handler), servertest.NewRequest("GET", "/", nil, h))
+}
+
+func gunzip(t *testing.T, data []byte) string {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestCompressesLargeBody(t *testing.T) {
+	res := get(t, fixedBody("text/html; charset=utf-8", page), "gzip, deflate")
+	assert.Equal(t, "gzip", res.Headers["content-encoding"])
+	assert.Equal(t, "chunked", res.Headers["transfer-encoding"])
+	assert.Equal(t, "Accept-Encoding", res.Headers["vary"])
+	assert.NotContains(t, res.Headers, "content-length")
+	assert.Less(t, len(res.Body), len(page))
+	assert.Equal(t, page, gunzip(t, res.Body))
+}
+
+func TestDeflateUsesZlibFormat(t *testing.T) {
+	res := get(t, fixedBody("application/json", page), "deflate")
+	assert.Equal(t, "deflate", res.Headers["content-encoding"])
+	r, err := zlib.NewReader(bytes.NewReader(res.Body))
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(out))
+}
+
+func TestLeavesResponsesAlone(t *testing.T) {
+	for name, tc := range map[string]struct {
+		handler        func(w *response.Writer, req *request.Request)
+		acceptEncoding string
+		vary           bool
+	}{
+		"small body":          {fixedBody("text/html", "<p>hi</p>"), "gzip", true},
+		"client without gzip": {fixedBody("text/html", page), "", true},
+		"client wants br":     {fixedBody("text/html", page), "br", true},
+		"video":               {fixedBody("video/mp4", page), "gzip", false},
+		"no content type":     {fixedBody("", page), "gzip", false},
+		"already encoded": {func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			h := response.GetDefaultHeaders(len(page))
+			h.Override("content-encoding", "br")
+			w.WriteHeaders(h)
+			w.WriteBody([]byte(page))
+		}, "gzip", true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := get(t, tc.handler, tc.acceptEncoding)
+			assert.NotEqual(t, "gzip", res.Headers["content-encoding"])
+			assert.Equal(t, strconv.Itoa(len(res.Body)), res.Headers["content-length"])
+			_, hasVary := res.Headers["vary"]
+			assert.Equal(t, tc.vary, hasVary)
+		})
+	}
+}
+
+func TestVaryIsMerged(t *testing.T) {
+	res := get(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetDefaultHeaders(len(page))
+		h.Override("vary", "Origin")
+		h.Override("etag", `"v1"`)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(page))
+	}, "gzip")
+	assert.Equal(t, "Origin, Accept-Encoding", res.Headers["vary"])
+	assert.Equal(t, `W/"v1"`, res.Headers["etag"])
+}
+
+func TestCompressesChunkedBodyAndKeepsTrailers(t *testing.T) {
+	chunks := []string{`[`, strings.Repeat(`{"id":1,"name":"widget"},`, 60), strings.Repeat(`{"id":2,"name":"gadget"},`, 60), `{}]`}
+	res := get(t, chunkedBody(chunks...), "gzip")
+	assert.Equal(t, "gzip", res.Headers["content-encoding"])
+	assert.Equal(t, strings.Join(chunks, ""), gunzip(t, res.Body))
+	assert.Equal(t, "4", res.Trailers["x-chunks"])
+}
+
+func TestSmallChunkedBodyIsSentAsIs(t *testing.T) {
+	res := get(t, chunkedBody(`{"a":`, `1}`), "gzip")
+	assert.NotContains(t, res.Headers, "content-encoding")
+	assert.Equal(t, "chunked", res.Headers["transfer-encoding"])
+	assert.Equal(t, `{"a":1}`, string(res.Body))
+	assert.Equal(t, "2", res.Trailers["x-chunks"])
+}
+
+func TestFlushSendsHeldBackBody(t *testing.T) {
+	// Test: a stream that flushes before it is large enough stays uncompressed
+	buf := &bytes.Buffer{}
+	req := servertest.NewRequest("GET", "/", nil, nil)
+	req.Headers.Override("accept-encoding", "gzip")
+	(&Compressor{}).Wrap(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"content-type": "text/event-stream", "transfer-encoding": "chunked"})
+		w.WriteChunkedBody([]byte("data: tick\n\n"))
+		require.NoError(t, w.Flush())
+		assert.Contains(t, buf.String(), "data: tick")
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.NewHeaders())
+	})(response.NewWriter(buf), req)
+	assert.NotContains(t, buf.String(), "content-encoding")
+}
+
+func TestHeadRequestIsNotCompressed(t *testing.T) {
+	req := servertest.NewRequest("HEAD", "/", nil, nil)
+	req.Headers.Override("accept-encoding", "gzip")
+	buf := &bytes.Buffer{}
+	(&Compressor{}).Wrap(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(page)))
+	})(response.NewWriter(buf), req)
+	assert.NotContains(t, buf.String(), "content-encoding")
+	assert.Contains(t, buf.String(), "content-length: "+strconv.Itoa(len(page)))
+}
+
+func TestCompressedResponseOverConnection(t *testing.T) {
+	res, err := servertest.Do((&Compressor{Level: gzip.BestSpeed}).Wrap(fixedBody("text/plain", page)),
+		"GET / HTTP/1.1\r\nHost: x\r\nAccept-Encoding: gzip\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", res.Headers["content-encoding"])
+	assert.Equal(t, page, gunzip(t, res.Body))
+}
+
+func TestHijackThroughCompressor(t *testing.T) {
+	conn := servertest.Dial((&Compressor{}).Wrap(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusSwitchingProtocols)
+		w.WriteHeaders(headers.Headers{"upgrade": "echo", "connection": "upgrade"})
+		c, _, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.WriteString(c, "hijacked\n")
+	}))
+	defer conn.Close()
+
+	go io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nAccept-Encoding: gzip\r\n\r\n")
+	br := bufio.NewReader(conn)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "hijacked\n", line)
+}
+
+func TestCompressesStreamedBody(t *testing.T) {
+	// Test: a Content-Length body written in pieces is compressed as a whole
+	res := get(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(page)))
+		for i := 0; i < len(page); i += 1000 {
+			_, err := w.WriteBody([]byte(page[i:min(i+1000, len(page))]))
+			require.NoError(t, err)
+		}
+		assert.Error(t, w.WriteTrailers(headers.NewHeaders()))
+	}, "gzip")
+	assert.Equal(t, "gzip", res.Headers["content-encoding"])
+	assert.Equal(t, page, gunzip(t, res.Body))
+}
+
+func TestReadFromThroughCompressor(t *testing.T) {
+	for contentType, compressed := range map[string]bool{"text/html": true, "video/mp4": false} {
+		res := get(t, func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			h := response.GetDefaultHeaders(len(page))
+			h.Override("content-type", contentType)
+			w.WriteHeaders(h)
+			n, err := w.ReadFrom(strings.NewReader(page))
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(page)), n)
+		}, "gzip")
+		if compressed {
+			assert.Equal(t, "gzip", res.Headers["content-encoding"])
+			assert.Equal(t, page, gunzip(t, res.Body))
+		} else {
+			assert.NotContains(t, res.Headers, "content-encoding")
+			assert.Equal(t, page, string(res.Body))
+		}
+	}
+}
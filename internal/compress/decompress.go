@@ -0,0 +1,46 @@
+package compress
+
+import (
+	"errors"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/server"
+)
+
+// DefaultMaxDecodedSize is the decoded body limit when
+// Decompressor.MaxDecodedSize is not set.
+const DefaultMaxDecodedSize = 10 << 20
+
+// Decompressor is middleware that decodes gzip and deflate request bodies
+// before the handler sees them, using request.DecodeBody. Requests with any
+// other Content-Encoding are answered with 415, bodies that decode past the
+// limit with 413, and corrupt ones with 400.
+type Decompressor struct {
+	// MaxDecodedSize caps the decoded body in bytes. Zero means
+	// DefaultMaxDecodedSize.
+	MaxDecodedSize int
+}
+
+// Wrap returns a handler that decodes request bodies for next.
+func (d *Decompressor) Wrap(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		maxSize := d.MaxDecodedSize
+		if maxSize <= 0 {
+			maxSize = DefaultMaxDecodedSize
+		}
+		err := req.DecodeBody(maxSize)
+		switch {
+		case err == nil:
+			next(w, req)
+		case errors.Is(err, request.ErrUnsupportedEncoding):
+			// tells the client which encodings it may use instead (RFC 7694)
+			response.WriteError(w, response.StatusUnsupportedMediaType, headers.Headers{"accept-encoding": "gzip, deflate"}, err.Error())
+		case errors.Is(err, request.ErrBodyTooLarge):
+			response.WriteError(w, response.StatusContentTooLarge, nil, err.Error())
+		default:
+			response.WriteError(w, response.StatusBadRequest, nil, err.Error())
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lengths echoes the decoded body and both body lengths.
+func lengths(w *response.Writer, req *request.Request) {
+	body := []byte(strconv.Itoa(req.RawBodyLength) + " " + strconv.Itoa(len(req.Body)) + " " + string(req.Body))
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func upload(t *testing.T, d *Decompressor, body []byte, contentEncoding string) *servertest.Result {
+	t.Helper()
+	raw := "POST /upload HTTP/1.1\r\nHost: x\r\n" +
+		"Content-Encoding: " + contentEncoding + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body)
+	res, err := servertest.Do(d.Wrap(lengths), raw)
+	require.NoError(t, err)
+	return res
+}
+
+func TestDecompressorDecodesBody(t *testing.T) {
+	gzipped := gzipBytes(t, []byte("hello, hello, hello"))
+	res := upload(t, &Decompressor{}, gzipped, "gzip")
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, strconv.Itoa(len(gzipped))+" 19 hello, hello, hello", string(res.Body))
+}
+
+func TestDecompressorErrors(t *testing.T) {
+	res := upload(t, &Decompressor{}, []byte("abc"), "br")
+	assert.Equal(t, response.StatusUnsupportedMediaType, res.StatusLine.StatusCode)
+	assert.Equal(t, "gzip, deflate", res.Headers["accept-encoding"])
+
+	res = upload(t, &Decompressor{MaxDecodedSize: 100}, gzipBytes(t, make([]byte, 101)), "gzip")
+	assert.Equal(t, response.StatusContentTooLarge, res.StatusLine.StatusCode)
+
+	res = upload(t, &Decompressor{}, []byte("not gzip at all"), "gzip")
+	assert.Equal(t, response.StatusBadRequest, res.StatusLine.StatusCode)
+}
@@ -0,0 +1,192 @@
+package conditional
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+// Validators describe the current representation of a resource, for
+// checking a request's preconditions against.
+type Validators struct {
+	// ETag is the entity tag, quoted and with a "W/" prefix if weak, or
+	// empty if there is none.
+	ETag string
+	// LastModified is when the representation last changed, or the zero
+	// time if unknown.
+	LastModified time.Time
+	// Missing is set when the resource has no current representation, so
+	// that "*" does not match it.
+	Missing bool
+}
+
+// ETag returns a strong entity tag for body: the first 128 bits of its
+// SHA-256, hex encoded.
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Weak marks an entity tag weak, for representations that are equivalent
+// but not byte for byte the same, such as a compressed copy.
+func Weak(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
+
+// Evaluate checks the If-Match, If-Unmodified-Since, If-None-Match and
+// If-Modified-Since headers of req against v, in the order RFC 9110 section
+// 13.2.2 gives. It returns StatusPreconditionFailed or StatusNotModified if
+// the request should be answered with that instead, or zero if it should go
+// ahead.
+//
+// Handlers of unsafe methods must call it before making any change.
+func Evaluate(req *request.Request, v Validators) response.StatusCode {
+	safe := req.RequestLine.Method == "GET" || req.RequestLine.Method == "HEAD"
+	if value, ok := req.Headers.Get("If-Match"); ok {
+		if !matches(value, v, true) {
+			return response.StatusPreconditionFailed
+		}
+	} else if value, ok := req.Headers.Get("If-Unmodified-Since"); ok && !v.LastModified.IsZero() {
+		date, ok := headers.ParseHTTPDate(value)
+		if ok && v.LastModified.Truncate(time.Second).After(date) {
+			return response.StatusPreconditionFailed
+		}
+	}
+
+	if value, ok := req.Headers.Get("If-None-Match"); ok {
+		if matches(value, v, false) {
+			if safe {
+				return response.StatusNotModified
+			}
+			return response.StatusPreconditionFailed
+		}
+	} else if value, ok := req.Headers.Get("If-Modified-Since"); ok && safe && !v.LastModified.IsZero() {
+		date, ok := headers.ParseHTTPDate(value)
+		if ok && !v.LastModified.Truncate(time.Second).After(date) {
+			return response.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// Check evaluates the preconditions of req and, if they decide the
+// response, writes it and returns true. h holds the headers the full
+// response would have had; a 304 carries over the ones a cache needs.
+func Check(w *response.Writer, req *request.Request, v Validators, h headers.Headers) bool {
+	switch Evaluate(req, v) {
+	case response.StatusNotModified:
+		writeNotModified(w, v, h)
+		return true
+	case response.StatusPreconditionFailed:
+		response.WriteError(w, response.StatusPreconditionFailed, nil, "precondition failed")
+		return true
+	}
+	return false
+}
+
+// IfRange reports whether a Range header should be honoured. If-Range holds
+// either a strong ETag or the exact Last-Modified date; when it does not
+// match, the client should get the whole representation.
+func IfRange(req *request.Request, v Validators) bool {
+	value, ok := req.Headers.Get("If-Range")
+	if !ok {
+		return true
+	}
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		return strongMatch(value, v.ETag)
+	}
+	date, ok := headers.ParseHTTPDate(value)
+	return ok && !v.LastModified.IsZero() && date.Equal(v.LastModified.Truncate(time.Second))
+}
+
+// matches reports whether the entity tag list in value matches v, using
+// the strong comparison for If-Match and the weak one for If-None-Match.
+func matches(value string, v Validators, strong bool) bool {
+	if strings.TrimSpace(value) == "*" {
+		return !v.Missing
+	}
+	for _, tag := range parseETags(value) {
+		if strong && strongMatch(tag, v.ETag) {
+			return true
+		}
+		if !strong && weakMatch(tag, v.ETag) {
+			return true
+		}
+	}
+	return false
+}
+
+func strongMatch(a, b string) bool {
+	return a != "" && a == b && !strings.HasPrefix(a, "W/")
+}
+
+func weakMatch(a, b string) bool {
+	return a != "" && b != "" && strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// parseETags splits a list of entity tags. Commas inside the quotes are
+// part of the tag, so the list cannot simply be split on them.
+func parseETags(value string) []string {
+	var tags []string
+	for {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			return tags
+		}
+		start := 0
+		if strings.HasPrefix(value, "W/") {
+			start = 2
+		}
+		if len(value) <= start || value[start] != '"' {
+			// not an entity tag; skip to the next element
+			_, value, _ = strings.Cut(value, ",")
+			continue
+		}
+		end := strings.IndexByte(value[start+1:], '"')
+		if end < 0 {
+			return tags
+		}
+		end += start + 2
+		tags = append(tags, value[:end])
+		value = value[end:]
+	}
+}
+
+// notModifiedFields are the headers a 304 repeats from the full response
+// (RFC 9110 section 15.4.5).
+var notModifiedFields = []string{"cache-control", "content-location", "date", "etag", "expires", "last-modified", "vary"}
+
+func writeNotModified(w *response.Writer, v Validators, h headers.Headers) {
+	out := headers.NewHeaders()
+	out.Override("connection", "close")
+	for _, field := range notModifiedFields {
+		if value, ok := h.Get(field); ok {
+			out.Override(field, value)
+		}
+	}
+	if v.ETag != "" {
+		out.Override("etag", v.ETag)
+	}
+	if _, ok := out.Get("last-modified"); !ok && !v.LastModified.IsZero() {
+		out.Override("last-modified", v.LastModified.UTC().Format(headers.TimeFormat))
+	}
+	err := w.WriteStatusLine(response.StatusNotModified)
+	if err != nil {
+		log.Printf("Error writing status line: %v", err)
+		return
+	}
+	err = w.WriteHeaders(out)
+	if err != nil {
+		log.Printf("Error writing headers: %v", err)
+	}
+}
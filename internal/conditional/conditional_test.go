@@ -0,0 +1,113 @@
+package conditional
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	current = Validators{ETag: `"v2"`, LastModified: modTime}
+)
+
+const (
+	before = "Wed, 01 May 2024 11:00:00 GMT"
+	same   = "Wed, 01 May 2024 12:00:00 GMT"
+	after  = "Wed, 01 May 2024 13:00:00 GMT"
+)
+
+func TestETag(t *testing.T) {
+	etag := ETag([]byte("hello"))
+	assert.Equal(t, `"2cf24dba5fb0a30e26e83b2ac5b9e29e"`, etag)
+	assert.Equal(t, "W/"+etag, Weak(etag))
+	assert.Equal(t, "W/"+etag, Weak(Weak(etag)))
+	assert.NotEqual(t, etag, ETag([]byte("hello!")))
+}
+
+func TestParseETags(t *testing.T) {
+	assert.Equal(t, []string{`"a"`, `W/"b"`, `"c,d"`}, parseETags(` "a", W/"b" ,"c,d"`))
+	assert.Equal(t, []string{`"a"`}, parseETags(`junk, "a", "unterminated`))
+	assert.Empty(t, parseETags(""))
+}
+
+func TestEvaluate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		method  string
+		headers map[string]string
+		v       Validators
+		want    response.StatusCode
+	}{
+		"no preconditions":              {"GET", nil, current, 0},
+		"if-match matches":              {"PUT", map[string]string{"if-match": `"v1", "v2"`}, current, 0},
+		"if-match stale":                {"PUT", map[string]string{"if-match": `"v1"`}, current, response.StatusPreconditionFailed},
+		"if-match is strong":            {"PUT", map[string]string{"if-match": `W/"v2"`}, current, response.StatusPreconditionFailed},
+		"if-match star":                 {"PUT", map[string]string{"if-match": "*"}, current, 0},
+		"if-match star missing":         {"PUT", map[string]string{"if-match": "*"}, Validators{Missing: true}, response.StatusPreconditionFailed},
+		"unmodified since":              {"DELETE", map[string]string{"if-unmodified-since": same}, current, 0},
+		"modified since unmodified":     {"DELETE", map[string]string{"if-unmodified-since": before}, current, response.StatusPreconditionFailed},
+		"if-match beats unmodified":     {"PUT", map[string]string{"if-match": `"v2"`, "if-unmodified-since": before}, current, 0},
+		"none-match get":                {"GET", map[string]string{"if-none-match": `W/"v2"`}, current, response.StatusNotModified},
+		"none-match head":               {"HEAD", map[string]string{"if-none-match": `"v2"`}, current, response.StatusNotModified},
+		"none-match put":                {"PUT", map[string]string{"if-none-match": `"v2"`}, current, response.StatusPreconditionFailed},
+		"none-match star creates":       {"PUT", map[string]string{"if-none-match": "*"}, Validators{Missing: true}, 0},
+		"none-match star exists":        {"PUT", map[string]string{"if-none-match": "*"}, current, response.StatusPreconditionFailed},
+		"none-match other":              {"GET", map[string]string{"if-none-match": `"v1"`}, current, 0},
+		"not modified since":            {"GET", map[string]string{"if-modified-since": same}, current, response.StatusNotModified},
+		"modified since":                {"GET", map[string]string{"if-modified-since": before}, current, 0},
+		"modified since ignored on put": {"PUT", map[string]string{"if-modified-since": after}, current, 0},
+		"none-match beats date":         {"GET", map[string]string{"if-none-match": `"v1"`, "if-modified-since": after}, current, 0},
+		"bad date ignored":              {"GET", map[string]string{"if-modified-since": "soon"}, current, 0},
+		"no last-modified":              {"GET", map[string]string{"if-modified-since": after}, Validators{ETag: `"v2"`}, 0},
+		"if-match before none-match":    {"GET", map[string]string{"if-match": `"v1"`, "if-none-match": `"v2"`}, current, response.StatusPreconditionFailed},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, Evaluate(servertest.NewRequest(tc.method, "/", nil, tc.headers), tc.v))
+		})
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	for value, want := range map[string]bool{
+		`"v2"`:   true,
+		`W/"v2"`: false,
+		`"v1"`:   false,
+		same:     true,
+		after:    false,
+		"junk":   false,
+	} {
+		assert.Equal(t, want, IfRange(servertest.NewRequest("GET", "/", nil, map[string]string{"if-range": value}), current), value)
+	}
+	assert.True(t, IfRange(servertest.NewRequest("GET", "/", nil, nil), current))
+}
+
+func TestCheckWritesNotModified(t *testing.T) {
+	req := servertest.NewRequest("GET", "/", nil, map[string]string{"if-none-match": `"v2"`})
+	res, err := servertest.Record(func(w *response.Writer, req *request.Request) {
+		full := response.GetDefaultHeaders(100)
+		full.Override("cache-control", "max-age=60")
+		full.Override("content-type", "text/html")
+		require.True(t, Check(w, req, current, full))
+	}, req)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusNotModified, res.StatusLine.StatusCode)
+	assert.Equal(t, `"v2"`, res.Headers["etag"])
+	assert.Equal(t, same, res.Headers["last-modified"])
+	assert.Equal(t, "max-age=60", res.Headers["cache-control"])
+	assert.NotContains(t, res.Headers, "content-type")
+	assert.NotContains(t, res.Headers, "content-length")
+}
+
+func TestCheckWritesPreconditionFailed(t *testing.T) {
+	req := servertest.NewRequest("PUT", "/", nil, map[string]string{"if-match": `"v1"`})
+	res, err := servertest.Record(func(w *response.Writer, req *request.Request) {
+		require.True(t, Check(w, req, current, nil))
+	}, req)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusPreconditionFailed, res.StatusLine.StatusCode)
+}
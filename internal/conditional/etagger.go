@@ -0,0 +1,263 @@
+package conditional
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strconv"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/server"
+)
+
+// DefaultMaxSize is the largest body ETagger buffers when MaxSize is not set.
+const DefaultMaxSize = 1 << 20
+
+// ETagger is middleware that answers conditional GET and HEAD requests with
+// 304 or 412. It goes by the ETag and Last-Modified headers the handler
+// sets, and gives 200 responses to GET with a Content-Length body and no
+// ETag one computed from the body, which it buffers for that.
+//
+// It only looks at the response, so the handler runs in full. Handlers that
+// change state, or that can tell cheaply that nothing changed, should call
+// Check themselves first.
+type ETagger struct {
+	// MaxSize is the largest body, in bytes, buffered to compute an ETag.
+	// Zero means DefaultMaxSize.
+	MaxSize int
+	// Weak makes computed ETags weak, for handlers whose output may vary in
+	// ways that do not matter, like the order of JSON fields.
+	Weak bool
+}
+
+// Wrap returns a handler that evaluates preconditions for what next writes.
+func (e *ETagger) Wrap(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		f := &etagFilter{w: w, e: e, req: req}
+		next(response.NewFilterWriter(w, f), req)
+		f.finish()
+	}
+}
+
+func (e *ETagger) maxSize() int {
+	if e.MaxSize <= 0 {
+		return DefaultMaxSize
+	}
+	return e.MaxSize
+}
+
+type etagState int
+
+const (
+	// the status line has not been written yet
+	etagStateInitialized etagState = iota
+	// a 200 status line is held back until the headers arrive
+	etagStateHeldStatus
+	// the body is buffered until it is complete
+	etagStateBuffering
+	etagStatePassthrough
+	// a 304 or 412 was sent instead, so the handler's body is dropped
+	etagStateDiscarding
+)
+
+// etagFilter is the response.Filter behind an ETagger.
+type etagFilter struct {
+	w      *response.Writer
+	e      *ETagger
+	req    *request.Request
+	state  etagState
+	header headers.Headers
+	// length is the Content-Length of a buffered body
+	length int
+	body   bytes.Buffer
+}
+
+func (f *etagFilter) WriteStatusLine(statusCode response.StatusCode) error {
+	method := f.req.RequestLine.Method
+	if statusCode == response.StatusOK && (method == "GET" || method == "HEAD") {
+		f.state = etagStateHeldStatus
+		return nil
+	}
+	f.state = etagStatePassthrough
+	return f.w.WriteStatusLine(statusCode)
+}
+
+func (f *etagFilter) WriteHeaders(h headers.Headers) error {
+	if f.state != etagStateHeldStatus {
+		return f.w.WriteHeaders(h)
+	}
+	v := validatorsFrom(h)
+	if v.ETag != "" || !v.LastModified.IsZero() {
+		return f.decide(h, v)
+	}
+	length, ok := bufferableLength(h)
+	if !ok || f.req.RequestLine.Method != "GET" || length > f.e.maxSize() {
+		return f.decide(h, v)
+	}
+	f.header = h
+	f.length = length
+	f.state = etagStateBuffering
+	if length == 0 {
+		return f.finishBody()
+	}
+	return nil
+}
+
+func (f *etagFilter) WriteBody(p []byte) (int, error) {
+	switch f.state {
+	case etagStateBuffering:
+		f.body.Write(p)
+		if f.body.Len() >= f.length {
+			if err := f.finishBody(); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case etagStateDiscarding:
+		return len(p), nil
+	}
+	return f.w.WriteBody(p)
+}
+
+// ReadFrom keeps the zero-copy path of the underlying Writer for bodies that
+// are not being buffered.
+func (f *etagFilter) ReadFrom(r io.Reader) (int64, error) {
+	switch f.state {
+	case etagStateBuffering:
+		return io.Copy(bodyWriter{f}, r)
+	case etagStateDiscarding:
+		return 0, nil
+	}
+	return f.w.ReadFrom(r)
+}
+
+// bodyWriter feeds WriteBody without exposing ReadFrom, so io.Copy into it
+// does not loop back.
+type bodyWriter struct {
+	f *etagFilter
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.f.WriteBody(p)
+}
+
+func (f *etagFilter) WriteChunkedBody(p []byte) (int, error) {
+	if f.state == etagStateDiscarding {
+		return len(p), nil
+	}
+	return f.w.WriteChunkedBody(p)
+}
+
+func (f *etagFilter) WriteChunkedBodyDone() (int, error) {
+	if f.state == etagStateDiscarding {
+		return 0, nil
+	}
+	return f.w.WriteChunkedBodyDone()
+}
+
+func (f *etagFilter) WriteTrailers(h headers.Headers) error {
+	if f.state == etagStateDiscarding {
+		return nil
+	}
+	return f.w.WriteTrailers(h)
+}
+
+func (f *etagFilter) Flush() error {
+	// the handler wants its output sent now, so give up on an ETag
+	if err := f.release(); err != nil {
+		return err
+	}
+	return f.w.Flush()
+}
+
+// finish runs after the handler returns, so that anything held back is not
+// lost.
+func (f *etagFilter) finish() {
+	if err := f.release(); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// release sends whatever is held back as it is.
+func (f *etagFilter) release() error {
+	switch f.state {
+	case etagStateHeldStatus:
+		f.state = etagStatePassthrough
+		return f.w.WriteStatusLine(response.StatusOK)
+	case etagStateBuffering:
+		f.state = etagStatePassthrough
+		if err := f.writeHead(f.header); err != nil {
+			return err
+		}
+		return f.writeBuffered()
+	}
+	return nil
+}
+
+// finishBody computes the ETag of a complete buffered body and answers.
+func (f *etagFilter) finishBody() error {
+	etag := ETag(f.body.Bytes())
+	if f.e.Weak {
+		etag = Weak(etag)
+	}
+	f.header.Override("etag", etag)
+	if err := f.decide(f.header, Validators{ETag: etag}); err != nil {
+		return err
+	}
+	if f.state == etagStatePassthrough {
+		return f.writeBuffered()
+	}
+	f.body.Reset()
+	return nil
+}
+
+// decide answers with a 304 or 412 if the preconditions call for it, and
+// otherwise writes the held back status line and h.
+func (f *etagFilter) decide(h headers.Headers, v Validators) error {
+	if Check(f.w, f.req, v, h) {
+		f.state = etagStateDiscarding
+		return nil
+	}
+	f.state = etagStatePassthrough
+	return f.writeHead(h)
+}
+
+func (f *etagFilter) writeHead(h headers.Headers) error {
+	if err := f.w.WriteStatusLine(response.StatusOK); err != nil {
+		return err
+	}
+	return f.w.WriteHeaders(h)
+}
+
+func (f *etagFilter) writeBuffered() error {
+	if f.body.Len() == 0 {
+		return nil
+	}
+	_, err := f.w.WriteBody(f.body.Bytes())
+	f.body.Reset()
+	return err
+}
+
+func validatorsFrom(h headers.Headers) Validators {
+	var v Validators
+	v.ETag, _ = h.Get("ETag")
+	if value, ok := h.Get("Last-Modified"); ok {
+		v.LastModified, _ = headers.ParseHTTPDate(value)
+	}
+	return v
+}
+
+// bufferableLength returns the Content-Length of a body that is not chunked.
+func bufferableLength(h headers.Headers) (int, bool) {
+	if _, ok := h.Get("Transfer-Encoding"); ok {
+		return 0, false
+	}
+	value, ok := h.Get("Content-Length")
+	if !ok {
+		return 0, false
+	}
+	length, err := strconv.Atoi(value)
+	return length, err == nil && length >= 0
+}
@@ -0,0 +1,127 @@
+package conditional
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/server"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const body = "<p>hello</p>"
+
+func page(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusOK)
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("cache-control", "no-cache")
+	w.WriteHeaders(h)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	// written in two pieces to check the buffering
+	w.WriteBody([]byte(body[:4]))
+	w.WriteBody([]byte(body[4:]))
+}
+
+func record(t *testing.T, e *ETagger, handler server.Handler, req *request.Request) *servertest.Result {
+	t.Helper()
+	return servertest.Run(t, e.Wrap(handler), req)
+}
+
+func TestETaggerAddsETag(t *testing.T) {
+	res := record(t, &ETagger{}, page, servertest.NewRequest("GET", "/", nil, nil))
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, ETag([]byte(body)), res.Headers["etag"])
+	assert.Equal(t, body, string(res.Body))
+
+	res = record(t, &ETagger{Weak: true}, page, servertest.NewRequest("GET", "/", nil, nil))
+	assert.Equal(t, Weak(ETag([]byte(body))), res.Headers["etag"])
+}
+
+func TestETaggerAnswersNotModified(t *testing.T) {
+	req := servertest.NewRequest("GET", "/", nil, map[string]string{"if-none-match": ETag([]byte(body))})
+	res := record(t, &ETagger{}, page, req)
+	assert.Equal(t, response.StatusNotModified, res.StatusLine.StatusCode)
+	assert.Equal(t, "no-cache", res.Headers["cache-control"])
+	assert.Empty(t, res.Body)
+}
+
+func TestETaggerUsesHandlerValidators(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetDefaultHeaders(len(body))
+		h.Override("etag", `"mine"`)
+		h.Override("last-modified", same)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+	for value, want := range map[string]response.StatusCode{
+		`"mine"`: response.StatusNotModified,
+		`"old"`:  response.StatusOK,
+	} {
+		res := record(t, &ETagger{}, handler, servertest.NewRequest("GET", "/", nil, map[string]string{"if-none-match": value}))
+		assert.Equal(t, want, res.StatusLine.StatusCode, value)
+		assert.Equal(t, `"mine"`, res.Headers["etag"])
+	}
+	res := record(t, &ETagger{}, handler, servertest.NewRequest("HEAD", "/", nil, map[string]string{"if-modified-since": after}))
+	assert.Equal(t, response.StatusNotModified, res.StatusLine.StatusCode)
+	res = record(t, &ETagger{}, handler, servertest.NewRequest("GET", "/", nil, map[string]string{"if-match": `"old"`}))
+	assert.Equal(t, response.StatusPreconditionFailed, res.StatusLine.StatusCode)
+}
+
+func TestETaggerLeavesResponsesAlone(t *testing.T) {
+	chunked := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked"})
+		w.WriteChunkedBody([]byte(body))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.NewHeaders())
+	}
+	notFound := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusNotFound)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+	for name, tc := range map[string]struct {
+		e       *ETagger
+		handler server.Handler
+		method  string
+	}{
+		"chunked":   {&ETagger{}, chunked, "GET"},
+		"not found": {&ETagger{}, notFound, "GET"},
+		"post":      {&ETagger{}, page, "POST"},
+		"head":      {&ETagger{}, page, "HEAD"},
+		"too large": {&ETagger{MaxSize: 4}, page, "GET"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := servertest.NewRequest(tc.method, "/", nil, map[string]string{"if-none-match": ETag([]byte(body))})
+			res := servertest.Run(t, tc.e.Wrap(tc.handler), req)
+			assert.NotEqual(t, response.StatusNotModified, res.StatusLine.StatusCode)
+			assert.NotContains(t, res.Headers, "etag")
+		})
+	}
+}
+
+func TestETaggerReleasesShortBody(t *testing.T) {
+	// Test: a handler that stops short of its Content-Length still has its
+	// output sent, without an ETag
+	buf := &strings.Builder{}
+	(&ETagger{}).Wrap(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body[:4]))
+	})(response.NewWriter(buf), servertest.NewRequest("GET", "/", nil, nil))
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"+body[:4]))
+	assert.NotContains(t, buf.String(), "etag")
+}
+
+func TestETaggerOverConnection(t *testing.T) {
+	res, err := servertest.Do((&ETagger{}).Wrap(page), "GET / HTTP/1.1\r\nHost: x\r\nIf-None-Match: "+ETag([]byte(body))+"\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusNotModified, res.StatusLine.StatusCode)
+}
@@ -0,0 +1,244 @@
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+)
+
+// SameSite is the SameSite attribute of a cookie.
+type SameSite string
+
+const (
+	// SameSiteDefault leaves the attribute out, so the browser's default
+	// applies.
+	SameSiteDefault SameSite = ""
+	SameSiteLax     SameSite = "Lax"
+	SameSiteStrict  SameSite = "Strict"
+	// SameSiteNone sends the cookie on cross-site requests too. It requires
+	// Secure.
+	SameSiteNone SameSite = "None"
+)
+
+// Cookie is a cookie sent by the client or set by the server (RFC 6265).
+// Only Name and Value come from a Cookie request header.
+type Cookie struct {
+	Name  string
+	Value string
+
+	// Expires is when the cookie expires. The zero time leaves it out.
+	Expires time.Time
+	// MaxAge is the lifetime in seconds. Zero leaves it out; a negative
+	// value sends Max-Age=0, which deletes the cookie.
+	MaxAge   int
+	Domain   string
+	Path     string
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned keeps the cookie in storage partitioned by the top-level
+	// site (CHIPS). It requires Secure.
+	Partitioned bool
+}
+
+// Parse reads the name/value pairs of a Cookie header value. Malformed
+// pairs are skipped.
+func Parse(value string) []*Cookie {
+	var cookies []*Cookie
+	for _, pair := range strings.Split(value, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !isToken(name) {
+			continue
+		}
+		value, ok = parseValue(value)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// FromRequest returns the cookies the client sent, in order.
+func FromRequest(req *request.Request) []*Cookie {
+	value, ok := req.Headers.Get("Cookie")
+	if !ok {
+		return nil
+	}
+	return Parse(value)
+}
+
+// Get returns the first cookie named name that the client sent.
+func Get(req *request.Request, name string) (*Cookie, bool) {
+	for _, c := range FromRequest(req) {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Set validates c and adds it to h as a Set-Cookie field. Each cookie is
+// written as a field line of its own.
+func Set(h headers.Headers, c *Cookie) error {
+	value, err := c.SetCookie()
+	if err != nil {
+		return err
+	}
+	h.Append("Set-Cookie", value)
+	return nil
+}
+
+// Delete adds a Set-Cookie field to h that removes the named cookie. Path and
+// Domain must match those it was set with.
+func Delete(h headers.Headers, name, path, domain string) error {
+	return Set(h, &Cookie{Name: name, Path: path, Domain: domain, MaxAge: -1, Expires: time.Unix(0, 0)})
+}
+
+// SetCookie returns the Set-Cookie field value for c, or an error if c has
+// an invalid name, value or attribute.
+func (c *Cookie) SetCookie() (string, error) {
+	if err := c.Valid(); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString(c.Name)
+	sb.WriteByte('=')
+	sb.WriteString(c.Value)
+	if !c.Expires.IsZero() {
+		sb.WriteString("; Expires=")
+		sb.WriteString(c.Expires.UTC().Format(headers.TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		sb.WriteString("; Max-Age=")
+		sb.WriteString(strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		sb.WriteString("; Max-Age=0")
+	}
+	if c.Domain != "" {
+		sb.WriteString("; Domain=")
+		sb.WriteString(strings.TrimPrefix(c.Domain, "."))
+	}
+	if c.Path != "" {
+		sb.WriteString("; Path=")
+		sb.WriteString(c.Path)
+	}
+	if c.Secure {
+		sb.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		sb.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		sb.WriteString("; SameSite=")
+		sb.WriteString(string(c.SameSite))
+	}
+	if c.Partitioned {
+		sb.WriteString("; Partitioned")
+	}
+	return sb.String(), nil
+}
+
+// Valid reports whether c can be sent in a Set-Cookie field.
+func (c *Cookie) Valid() error {
+	if !isToken(c.Name) {
+		return fmt.Errorf("invalid cookie name %q", c.Name)
+	}
+	if !isValue(c.Value) {
+		return fmt.Errorf("invalid value for cookie %q", c.Name)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("invalid Expires for cookie %q: before 1601", c.Name)
+	}
+	if c.Domain != "" && !isDomain(strings.TrimPrefix(c.Domain, ".")) {
+		return fmt.Errorf("invalid Domain for cookie %q: %q", c.Name, c.Domain)
+	}
+	if !isAttributeValue(c.Path) {
+		return fmt.Errorf("invalid Path for cookie %q: %q", c.Name, c.Path)
+	}
+	switch c.SameSite {
+	case SameSiteDefault, SameSiteLax, SameSiteStrict:
+	case SameSiteNone:
+		if !c.Secure {
+			return fmt.Errorf("cookie %q has SameSite=None without Secure", c.Name)
+		}
+	default:
+		return fmt.Errorf("invalid SameSite for cookie %q: %q", c.Name, c.SameSite)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("cookie %q is Partitioned without Secure", c.Name)
+	}
+	return nil
+}
+
+// parseValue strips the optional quotes around a cookie value.
+func parseValue(value string) (string, bool) {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return value, isValue(value)
+}
+
+// isValue reports whether v holds only cookie-octets: visible ASCII except
+// double quote, comma, semicolon and backslash.
+func isValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// isToken reports whether s is a non-empty RFC 9110 token.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isAttributeValue reports whether s can be an attribute value: no control
+// characters and no semicolons.
+func isAttributeValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c == 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// isDomain reports whether s looks like a host name: dot-separated labels
+// of letters, digits and hyphens.
+func isDomain(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package cookie
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cookies := Parse(`session=abc123; theme="dark";  lang=en-GB; bad name=x; noequals; empty=; quote=a"b`)
+	var pairs []string
+	for _, c := range cookies {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	assert.Equal(t, []string{"session=abc123", "theme=dark", "lang=en-GB", "empty="}, pairs)
+}
+
+func TestGetFromRequest(t *testing.T) {
+	req := servertest.NewRequest("GET", "/", nil, nil)
+	_, ok := Get(req, "session")
+	assert.False(t, ok)
+
+	// a second Cookie field is joined to the first with a semicolon
+	req.Headers.Append("Cookie", "a=1; session=first")
+	req.Headers.Append("Cookie", "session=second")
+	c, ok := Get(req, "session")
+	require.True(t, ok)
+	assert.Equal(t, "first", c.Value)
+	assert.Len(t, FromRequest(req), 3)
+}
+
+func TestSetCookie(t *testing.T) {
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Expires:     time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+		MaxAge:      3600,
+		Domain:      ".example.com",
+		Path:        "/app",
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	value, err := c.SetCookie()
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc123; Expires=Wed, 02 Jan 2030 02:04:05 GMT; Max-Age=3600; Domain=example.com; Path=/app; Secure; HttpOnly; SameSite=None; Partitioned", value)
+
+	value, err = (&Cookie{Name: "a", Value: "1"}).SetCookie()
+	require.NoError(t, err)
+	assert.Equal(t, "a=1", value)
+}
+
+func TestSetCookieInvalid(t *testing.T) {
+	for name, c := range map[string]*Cookie{
+		"empty name":         {Value: "x"},
+		"name with space":    {Name: "a b"},
+		"value with comma":   {Name: "a", Value: "1,2"},
+		"value with space":   {Name: "a", Value: "1 2"},
+		"old expires":        {Name: "a", Expires: time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"bad domain":         {Name: "a", Domain: "exa mple.com"},
+		"path injection":     {Name: "a", Path: "/; Domain=evil.com"},
+		"unknown samesite":   {Name: "a", SameSite: "Loose"},
+		"insecure none":      {Name: "a", SameSite: SameSiteNone},
+		"insecure partition": {Name: "a", Partitioned: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.SetCookie()
+			assert.Error(t, err)
+			h := headers.NewHeaders()
+			assert.Error(t, Set(h, c))
+			assert.Empty(t, h)
+		})
+	}
+}
+
+func TestSetWritesSeparateLines(t *testing.T) {
+	res, err := servertest.Record(func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		require.NoError(t, Set(h, &Cookie{Name: "a", Value: "1", Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)}))
+		require.NoError(t, Set(h, &Cookie{Name: "b", Value: "2", HttpOnly: true}))
+		require.NoError(t, Delete(h, "old", "/", ""))
+		require.NoError(t, w.WriteStatusLine(response.StatusOK))
+		require.NoError(t, w.WriteHeaders(h))
+	}, servertest.NewRequest("GET", "/", nil, nil))
+	require.NoError(t, err)
+
+	raw := string(res.Raw)
+	assert.Contains(t, raw, "set-cookie: a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT\r\n")
+	assert.Contains(t, raw, "set-cookie: b=2; HttpOnly\r\n")
+	assert.Contains(t, raw, "set-cookie: old=; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; Path=/\r\n")
+	assert.Equal(t, 3, strings.Count(raw, "set-cookie:"))
+	assert.Len(t, res.Headers.Values("Set-Cookie"), 3)
+}
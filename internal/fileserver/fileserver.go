@@ -0,0 +1,333 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	iofs "io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jonvanw/httpfromtcp/internal/conditional"
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+// FileServer serves the files under a directory. Files are streamed rather
+// than read into memory, and support range requests and conditional GETs.
+type FileServer struct {
+	// Root is the directory to serve. Paths that would leave it, through ".."
+	// or a symlink, are refused.
+	Root string
+	// StripPrefix is removed from the request path before it is looked up,
+	// so "/static/app.js" with StripPrefix "/static" serves Root/app.js.
+	StripPrefix string
+	// IndexFiles are tried in order when a directory is requested. Nil means
+	// "index.html".
+	IndexFiles []string
+	// ListDirectories serves an HTML listing of directories that have no
+	// index file. Otherwise they get 403.
+	ListDirectories bool
+}
+
+// Handle is a server.Handler that serves the file named by the request path.
+func (fs *FileServer) Handle(w *response.Writer, req *request.Request) {
+	if !allowedMethod(w, req) {
+		return
+	}
+	rawPath, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	name, ok := fs.resolve(rawPath)
+	if !ok {
+		response.WriteError(w, response.StatusNotFound, nil, "not found")
+		return
+	}
+	root, err := os.OpenRoot(fs.Root)
+	if err != nil {
+		log.Printf("Error opening file server root: %v", err)
+		response.WriteError(w, response.StatusInternalServerError, nil, "cannot open root directory")
+		return
+	}
+	defer root.Close()
+
+	f, info, ok := open(w, root, name)
+	if !ok {
+		return
+	}
+	defer f.Close()
+	if !info.IsDir() {
+		serveContent(w, req, name, f, info)
+		return
+	}
+
+	// relative links in the page only work from a path ending in a slash
+	if !strings.HasSuffix(rawPath, "/") {
+		location := rawPath + "/"
+		if query != "" {
+			location += "?" + query
+		}
+		writeResponse(w, response.StatusMovedPermanently, headers.Headers{"location": location}, "moved to "+location+"\n")
+		return
+	}
+	for _, index := range fs.indexFiles() {
+		indexName := path.Join(name, index)
+		indexFile, err := root.Open(indexName)
+		if err != nil {
+			continue
+		}
+		defer indexFile.Close()
+		indexInfo, err := indexFile.Stat()
+		if err != nil || indexInfo.IsDir() {
+			continue
+		}
+		serveContent(w, req, indexName, indexFile, indexInfo)
+		return
+	}
+	if !fs.ListDirectories {
+		response.WriteError(w, response.StatusForbidden, nil, "directory listing not allowed")
+		return
+	}
+	listDirectory(w, req, f, rawPath)
+}
+
+// ServeFile serves name, a path relative to Root, whatever the request
+// path. It is useful for fixed routes such as "/video".
+func (fs *FileServer) ServeFile(w *response.Writer, req *request.Request, name string) {
+	if !allowedMethod(w, req) {
+		return
+	}
+	root, err := os.OpenRoot(fs.Root)
+	if err != nil {
+		log.Printf("Error opening file server root: %v", err)
+		response.WriteError(w, response.StatusInternalServerError, nil, "cannot open root directory")
+		return
+	}
+	defer root.Close()
+	f, info, ok := open(w, root, name)
+	if !ok {
+		return
+	}
+	defer f.Close()
+	if info.IsDir() {
+		response.WriteError(w, response.StatusNotFound, nil, "not found")
+		return
+	}
+	serveContent(w, req, name, f, info)
+}
+
+func (fs *FileServer) indexFiles() []string {
+	if fs.IndexFiles == nil {
+		return []string{"index.html"}
+	}
+	return fs.IndexFiles
+}
+
+// resolve turns a request path into a name relative to Root. It refuses
+// paths with ".." segments outright rather than trying to make sense of them.
+func (fs *FileServer) resolve(rawPath string) (string, bool) {
+	p, err := url.PathUnescape(rawPath)
+	if err != nil || !strings.HasPrefix(p, "/") || strings.ContainsRune(p, 0) {
+		return "", false
+	}
+	if fs.StripPrefix != "" {
+		rest, found := strings.CutPrefix(p, strings.TrimSuffix(fs.StripPrefix, "/"))
+		if !found || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			return "", false
+		}
+		p = "/" + strings.TrimPrefix(rest, "/")
+	}
+	if slices.Contains(strings.Split(p, "/"), "..") {
+		return "", false
+	}
+	name := strings.TrimPrefix(path.Clean(p), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, true
+}
+
+func allowedMethod(w *response.Writer, req *request.Request) bool {
+	switch req.RequestLine.Method {
+	case "GET", "HEAD":
+		return true
+	}
+	writeResponse(w, response.StatusMethodNotAllowed, headers.Headers{"allow": "GET, HEAD"}, "method not allowed\n")
+	return false
+}
+
+// open opens name under root, answering the request itself if it cannot.
+func open(w *response.Writer, root *os.Root, name string) (*os.File, iofs.FileInfo, bool) {
+	f, err := root.Open(name)
+	if err == nil {
+		info, statErr := f.Stat()
+		if statErr == nil {
+			return f, info, true
+		}
+		f.Close()
+		err = statErr
+	}
+	switch {
+	case errors.Is(err, iofs.ErrPermission):
+		response.WriteError(w, response.StatusForbidden, nil, "forbidden")
+	default:
+		// includes paths that escape the root through a symlink
+		response.WriteError(w, response.StatusNotFound, nil, "not found")
+	}
+	return nil, nil, false
+}
+
+// serveContent answers a request for a regular file, honouring conditional
+// and range headers.
+func serveContent(w *response.Writer, req *request.Request, name string, f *os.File, info iofs.FileInfo) {
+	size := info.Size()
+	modTime := info.ModTime()
+	etag := fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+
+	h := headers.NewHeaders()
+	h.Override("etag", etag)
+	h.Override("last-modified", modTime.UTC().Format(headers.TimeFormat))
+	h.Override("accept-ranges", "bytes")
+	h.Override("connection", "close")
+
+	v := conditional.Validators{ETag: etag, LastModified: modTime}
+	if conditional.Check(w, req, v, h) {
+		return
+	}
+
+	contentType, err := contentTypeOf(name, f)
+	if err != nil {
+		log.Printf("Error reading %s: %v", name, err)
+		response.WriteError(w, response.StatusInternalServerError, nil, "cannot read file")
+		return
+	}
+
+	if rangeHeader, ok := req.Headers.Get("Range"); ok && conditional.IfRange(req, v) {
+		ranges, err := parseRange(rangeHeader, size)
+		switch {
+		case errors.Is(err, errUnsatisfiable):
+			h.Override("content-range", fmt.Sprintf("bytes */%d", size))
+			writeResponse(w, response.StatusRangeNotSatisfiable, h, "")
+			return
+		case err != nil:
+			// a Range header we cannot use is ignored, per RFC 9110 section 14.2
+		case len(ranges) == 1:
+			r := ranges[0]
+			h.Override("content-type", contentType)
+			h.Override("content-range", r.contentRange(size))
+			h.Override("content-length", strconv.FormatInt(r.length, 10))
+			writeHead(w, response.StatusPartialContent, h)
+			if req.RequestLine.Method != "HEAD" {
+				sendRange(w, f, r)
+			}
+			return
+		default:
+			serveMultipart(w, req, h, f, size, contentType, ranges)
+			return
+		}
+	}
+
+	h.Override("content-type", contentType)
+	h.Override("content-length", strconv.FormatInt(size, 10))
+	writeHead(w, response.StatusOK, h)
+	if req.RequestLine.Method != "HEAD" {
+		sendRange(w, f, byteRange{start: 0, length: size})
+	}
+}
+
+// sendRange writes part of f as a Content-Length body. Reading through an
+// io.LimitedReader over the file itself lets the Writer use sendfile.
+func sendRange(w *response.Writer, f *os.File, r byteRange) bool {
+	if _, err := f.Seek(r.start, io.SeekStart); err != nil {
+		log.Printf("Error reading file: %v", err)
+		return false
+	}
+	if _, err := w.ReadFrom(io.LimitReader(f, r.length)); err != nil {
+		log.Printf("Error writing body: %v", err)
+		return false
+	}
+	return true
+}
+
+// listDirectory writes an HTML page linking to each entry of dir.
+func listDirectory(w *response.Writer, req *request.Request, dir *os.File, rawPath string) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		log.Printf("Error listing directory: %v", err)
+		response.WriteError(w, response.StatusInternalServerError, nil, "cannot list directory")
+		return
+	}
+	slices.SortFunc(entries, func(a, b iofs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+
+	title := "Index of " + html.EscapeString(rawPath)
+	if p, err := url.PathUnescape(rawPath); err == nil {
+		title = "Index of " + html.EscapeString(p)
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<ul>\n", title, title)
+	if rawPath != "/" {
+		b.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		// "./" stops a name like "a:b" from being read as a URL scheme
+		href := "./" + url.PathEscape(entry.Name())
+		if entry.IsDir() {
+			href += "/"
+		}
+		fmt.Fprintf(b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+
+	body := b.String()
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("content-type", "text/html; charset=utf-8")
+	writeHead(w, response.StatusOK, h)
+	if req.RequestLine.Method != "HEAD" {
+		if _, err := w.WriteBody([]byte(body)); err != nil {
+			log.Printf("Error writing body: %v", err)
+		}
+	}
+}
+
+func writeHead(w *response.Writer, status response.StatusCode, h headers.Headers) bool {
+	err := w.WriteStatusLine(status)
+	if err != nil {
+		log.Printf("Error writing status line: %v", err)
+		return false
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("Error writing headers: %v", err)
+		return false
+	}
+	return true
+}
+
+// writeResponse writes a complete response with a small body. Fields in h
+// are added to the defaults.
+func writeResponse(w *response.Writer, status response.StatusCode, h headers.Headers, body string) {
+	all := response.GetDefaultHeaders(len(body))
+	all.Override("content-type", "text/plain")
+	if body == "" {
+		all.Remove("content-type")
+	}
+	for key, value := range h {
+		all.Override(key, value)
+	}
+	if !writeHead(w, status, all) || body == "" {
+		return
+	}
+	_, err := w.WriteBody([]byte(body))
+	if err != nil {
+		log.Printf("Error writing body: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package fileserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+)
+
+const benchFileSize = 16 << 20
+
+// writeBodyHandler serves the file the way handlers did before ReadFrom,
+// copying it through WriteBody in 32KB pieces.
+func writeBodyHandler(path string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		f, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		info, _ := f.Stat()
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(int(info.Size())))
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := f.Read(buf)
+			if n > 0 {
+				w.WriteBody(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func BenchmarkServeFile(b *testing.B) {
+	dir := b.TempDir()
+	path := filepath.Join(dir, "big.bin")
+	data := make([]byte, benchFileSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+	fs := &FileServer{Root: dir}
+
+	b.Run("WriteBody", func(b *testing.B) {
+		benchmarkServe(b, writeBodyHandler(path))
+	})
+	b.Run("ReadFrom", func(b *testing.B) {
+		benchmarkServe(b, fs.Handle)
+	})
+}
+
+func benchmarkServe(b *testing.B, handler func(w *response.Writer, req *request.Request)) {
+	srv := servertest.NewServer(b, handler)
+	b.SetBytes(benchFileSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		conn, err := net.Dial("tcp", srv.Addr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		fmt.Fprint(conn, "GET /big.bin HTTP/1.1\r\nHost: x\r\n\r\n")
+		n, err := io.Copy(io.Discard, conn)
+		conn.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n < benchFileSize {
+			b.Fatalf("read %d bytes, want more than %d", n, benchFileSize)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package fileserver
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const alphabet = "abcdefghijklmnopqrstuvwxyz"
+
+// newRoot creates a directory with a few files to serve.
+func newRoot(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	write("alphabet.txt", alphabet)
+	write("docs/index.html", "<h1>docs</h1>")
+	write("files/b <&>.txt", "b")
+	write("files/a.txt", "a")
+	write("files/sub/c.txt", "c")
+	write("noext", "just some text\n")
+	write("image", "\x89PNG\r\n\x1a\n....")
+	write("clip", "\x00\x00\x00\x18ftypmp42")
+	return dir
+}
+
+func get(t *testing.T, fs *FileServer, target string, h map[string]string) *servertest.Result {
+	t.Helper()
+	return do(t, fs, "GET", target, h)
+}
+
+func do(t *testing.T, fs *FileServer, method, target string, h map[string]string) *servertest.Result {
+	t.Helper()
+	return servertest.Run(t, fs.Handle, servertest.NewRequest(method, target, nil, h))
+}
+
+func TestServesFile(t *testing.T) {
+	fs := &FileServer{Root: newRoot(t)}
+	res := get(t, fs, "/alphabet.txt", nil)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, alphabet, string(res.Body))
+	assert.Equal(t, "26", res.Headers["content-length"])
+	assert.Equal(t, "text/plain; charset=utf-8", res.Headers["content-type"])
+	assert.Equal(t, "bytes", res.Headers["accept-ranges"])
+	assert.NotEmpty(t, res.Headers["etag"])
+	assert.NotEmpty(t, res.Headers["last-modified"])
+}
+
+func TestStreamsLargeFile(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 100*1024)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big.bin"), data, 0o644))
+	res := get(t, &FileServer{Root: dir}, "/big.bin", nil)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, data, res.Body)
+}
+
+func TestStripPrefixAndEscapedPath(t *testing.T) {
+	fs := &FileServer{Root: newRoot(t), StripPrefix: "/static"}
+	res := get(t, fs, "/static/files/b%20%3C&%3E.txt?v=1", nil)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "b", string(res.Body))
+
+	assert.Equal(t, response.StatusNotFound, get(t, fs, "/alphabet.txt", nil).StatusLine.StatusCode)
+	assert.Equal(t, response.StatusNotFound, get(t, fs, "/staticalphabet.txt", nil).StatusLine.StatusCode)
+}
+
+func TestRefusesPathEscapes(t *testing.T) {
+	dir := newRoot(t)
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "link")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "linkdir")))
+	rel, err := filepath.Rel(dir, filepath.Join(outside, "secret"))
+	require.NoError(t, err)
+
+	fs := &FileServer{Root: dir, ListDirectories: true}
+	for _, target := range []string{
+		"/../" + filepath.ToSlash(rel),
+		"/files/../../" + filepath.ToSlash(rel),
+		"/%2e%2e/" + filepath.ToSlash(rel),
+		"/files/..%2f..%2f" + strings.ReplaceAll(filepath.ToSlash(rel), "/", "%2f"),
+		"/link",
+		"/linkdir/secret",
+		"/alphabet.txt%00.html",
+	} {
+		res := get(t, fs, target, nil)
+		assert.Equal(t, response.StatusNotFound, res.StatusLine.StatusCode, target)
+		assert.NotContains(t, string(res.Body), "secret", target)
+	}
+}
+
+func TestDirectories(t *testing.T) {
+	dir := newRoot(t)
+
+	res := get(t, &FileServer{Root: dir}, "/docs?x=1", nil)
+	assert.Equal(t, response.StatusMovedPermanently, res.StatusLine.StatusCode)
+	assert.Equal(t, "/docs/?x=1", res.Headers["location"])
+
+	res = get(t, &FileServer{Root: dir}, "/docs/", nil)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "<h1>docs</h1>", string(res.Body))
+	assert.Equal(t, "text/html; charset=utf-8", res.Headers["content-type"])
+
+	res = get(t, &FileServer{Root: dir, IndexFiles: []string{}}, "/docs/", nil)
+	assert.Equal(t, response.StatusForbidden, res.StatusLine.StatusCode)
+
+	res = get(t, &FileServer{Root: dir}, "/files/", nil)
+	assert.Equal(t, response.StatusForbidden, res.StatusLine.StatusCode)
+}
+
+func TestDirectoryListing(t *testing.T) {
+	res := get(t, &FileServer{Root: newRoot(t), ListDirectories: true}, "/files/", nil)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", res.Headers["content-type"])
+	body := string(res.Body)
+	assert.Contains(t, body, `<a href="../">../</a>`)
+	assert.Contains(t, body, `<a href="./b%20%3C&amp;%3E.txt">b &lt;&amp;&gt;.txt</a>`)
+	assert.Contains(t, body, `<a href="./sub/">sub/</a>`)
+	assert.Less(t, strings.Index(body, "a.txt"), strings.Index(body, "b &lt;"))
+}
+
+func TestSniffsContentType(t *testing.T) {
+	fs := &FileServer{Root: newRoot(t)}
+	for target, want := range map[string]string{
+		"/noext": "text/plain; charset=utf-8",
+		"/image": "image/png",
+		"/clip":  "video/mp4",
+	} {
+		res := get(t, fs, target, nil)
+		assert.Equal(t, want, res.Headers["content-type"], target)
+	}
+	// sniffing must not eat the start of the body
+	assert.Equal(t, "just some text\n", string(get(t, fs, "/noext", nil).Body))
+}
+
+func TestSingleRange(t *testing.T) {
+	fs := &FileServer{Root: newRoot(t)}
+	for header, want := range map[string]string{
+		"bytes=0-4":    "abcde",
+		"bytes=20-":    "uvwxyz",
+		"bytes=-3":     "xyz",
+		"bytes=24-100": "yz",
+		"bytes=-100":   alphabet,
+	} {
+		res := get(t, fs, "/alphabet.txt", map[string]string{"range": header})
+		assert.Equal(t, response.StatusPartialContent, res.StatusLine.StatusCode, header)
+		assert.Equal(t, want, string(res.Body), header)
+	}
+	res := get(t, fs, "/alphabet.txt", map[string]string{"range": "bytes=1-2"})
+	assert.Equal(t, "bytes 1-2/26", res.Headers["content-range"])
+	assert.Equal(t, "2", res.Headers["content-length"])
+}
+
+func TestMultipleRanges(t *testing.T) {
+	res := get(t, &FileServer{Root: newRoot(t)}, "/alphabet.txt", map[string]string{"range": "bytes=0-1, 10-12,-2"})
+	assert.Equal(t, response.StatusPartialContent, res.StatusLine.StatusCode)
+	mediaType, params, err := mime.ParseMediaType(res.Headers["content-type"])
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	var parts, ranges []string
+	r := multipart.NewReader(bytes.NewReader(res.Body), params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		parts = append(parts, string(data))
+		ranges = append(ranges, part.Header.Get("Content-Range"))
+	}
+	assert.Equal(t, []string{"ab", "klm", "yz"}, parts)
+	assert.Equal(t, []string{"bytes 0-1/26", "bytes 10-12/26", "bytes 24-25/26"}, ranges)
+}
+
+func TestUnusableRanges(t *testing.T) {
+	fs := &FileServer{Root: newRoot(t)}
+	for _, header := range []string{"bytes=5-1", "lines=1-2", "bytes=a-b", "bytes=0-25,0-25", "bytes="} {
+		res := get(t, fs, "/alphabet.txt", map[string]string{"range": header})
+		assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode, header)
+		assert.Equal(t, alphabet, string(res.Body), header)
+	}
+	for _, header := range []string{"bytes=26-", "bytes=100-200", "bytes=-0"} {
+		res := get(t, fs, "/alphabet.txt", map[string]string{"range": header})
+		assert.Equal(t, response.StatusRangeNotSatisfiable, res.StatusLine.StatusCode, header)
+		assert.Equal(t, "bytes */26", res.Headers["content-range"], header)
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	fs := &FileServer{Root: newRoot(t)}
+	full := get(t, fs, "/alphabet.txt", nil)
+	etag, lastModified := full.Headers["etag"], full.Headers["last-modified"]
+
+	for value, want := range map[string]response.StatusCode{
+		etag:                            response.StatusPartialContent,
+		lastModified:                    response.StatusPartialContent,
+		`"stale"`:                       response.StatusOK,
+		"W/" + etag:                     response.StatusOK,
+		"Mon, 02 Jan 2006 15:04:05 GMT": response.StatusOK,
+	} {
+		res := get(t, fs, "/alphabet.txt", map[string]string{"range": "bytes=0-0", "if-range": value})
+		assert.Equal(t, want, res.StatusLine.StatusCode, value)
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	dir := newRoot(t)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "alphabet.txt"), modTime, modTime))
+	fs := &FileServer{Root: dir}
+	full := get(t, fs, "/alphabet.txt", nil)
+	etag := full.Headers["etag"]
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", full.Headers["last-modified"])
+
+	for name, tc := range map[string]struct {
+		headers map[string]string
+		want    response.StatusCode
+	}{
+		"matching etag":      {map[string]string{"if-none-match": `"x", ` + etag}, response.StatusNotModified},
+		"weak etag":          {map[string]string{"if-none-match": "W/" + etag}, response.StatusNotModified},
+		"star":               {map[string]string{"if-none-match": "*"}, response.StatusNotModified},
+		"other etag":         {map[string]string{"if-none-match": `"x"`}, response.StatusOK},
+		"not modified since": {map[string]string{"if-modified-since": "Wed, 01 May 2024 12:00:00 GMT"}, response.StatusNotModified},
+		"obsolete date":      {map[string]string{"if-modified-since": "Wednesday, 01-May-24 12:00:00 GMT"}, response.StatusNotModified},
+		"modified since":     {map[string]string{"if-modified-since": "Wed, 01 May 2024 11:59:59 GMT"}, response.StatusOK},
+		"bad date":           {map[string]string{"if-modified-since": "yesterday"}, response.StatusOK},
+		"if-match":           {map[string]string{"if-match": etag}, response.StatusOK},
+		"if-match other":     {map[string]string{"if-match": `"x"`}, response.StatusPreconditionFailed},
+		"unmodified since":   {map[string]string{"if-unmodified-since": "Wed, 01 May 2024 11:00:00 GMT"}, response.StatusPreconditionFailed},
+		"etag wins over date": {map[string]string{
+			"if-none-match":     `"x"`,
+			"if-modified-since": "Wed, 01 May 2024 12:00:00 GMT",
+		}, response.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := get(t, fs, "/alphabet.txt", tc.headers)
+			assert.Equal(t, tc.want, res.StatusLine.StatusCode)
+			if tc.want == response.StatusNotModified {
+				assert.Empty(t, res.Body)
+				assert.Equal(t, etag, res.Headers["etag"])
+				assert.NotContains(t, res.Headers, "content-length")
+			}
+		})
+	}
+}
+
+func TestHeadAndMethods(t *testing.T) {
+	fs := &FileServer{Root: newRoot(t)}
+	res := do(t, fs, "HEAD", "/alphabet.txt", nil)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "26", res.Headers["content-length"])
+	assert.Empty(t, res.Body)
+
+	res = do(t, fs, "POST", "/alphabet.txt", nil)
+	assert.Equal(t, response.StatusMethodNotAllowed, res.StatusLine.StatusCode)
+	assert.Equal(t, "GET, HEAD", res.Headers["allow"])
+}
+
+func TestServeFile(t *testing.T) {
+	fs := &FileServer{Root: newRoot(t)}
+	res, err := servertest.Do(func(w *response.Writer, req *request.Request) {
+		fs.ServeFile(w, req, "alphabet.txt")
+	}, "GET /video HTTP/1.1\r\nHost: x\r\nRange: bytes=-4\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusPartialContent, res.StatusLine.StatusCode)
+	assert.Equal(t, "wxyz", string(res.Body))
+}
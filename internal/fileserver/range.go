@@ -0,0 +1,156 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+// maxRanges caps how many ranges a request may ask for before the whole file
+// is sent instead.
+const maxRanges = 32
+
+var (
+	errUnsatisfiable = errors.New("no satisfiable range")
+	errInvalidRange  = errors.New("invalid range")
+)
+
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header such as "bytes=0-499, -500" against a
+// file of the given size. Ranges that start past the end are dropped; if none
+// are left it returns errUnsatisfiable. Headers that are malformed, or that
+// ask for more than the file in total, return errInvalidRange so the caller
+// can ignore them.
+func parseRange(value string, size int64) ([]byteRange, error) {
+	unit, spec, ok := strings.Cut(value, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, errInvalidRange
+	}
+	var ranges []byteRange
+	var total int64
+	count := 0
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		count++
+		if count > maxRanges {
+			return nil, errInvalidRange
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		var r byteRange
+		if first == "" {
+			// a suffix range: the last n bytes
+			n, ok := parseDigits(last)
+			if !ok {
+				return nil, errInvalidRange
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, ok := parseDigits(first)
+			if !ok {
+				return nil, errInvalidRange
+			}
+			end := size - 1
+			if last != "" {
+				end, ok = parseDigits(last)
+				if !ok || end < start {
+					return nil, errInvalidRange
+				}
+			}
+			if start >= size {
+				continue
+			}
+			end = min(end, size-1)
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		total += r.length
+		ranges = append(ranges, r)
+	}
+	if count == 0 {
+		return nil, errInvalidRange
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	if total > size {
+		return nil, errInvalidRange
+	}
+	return ranges, nil
+}
+
+func parseDigits(s string) (int64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// serveMultipart sends several ranges as a multipart/byteranges body.
+func serveMultipart(w *response.Writer, req *request.Request, h headers.Headers, f *os.File, size int64, contentType string, ranges []byteRange) {
+	boundary := newBoundary()
+	partHeaders := make([]string, len(ranges))
+	length := int64(len("--" + boundary + "--\r\n"))
+	for i, r := range ranges {
+		partHeaders[i] = fmt.Sprintf("--%s\r\ncontent-type: %s\r\ncontent-range: %s\r\n\r\n", boundary, contentType, r.contentRange(size))
+		length += int64(len(partHeaders[i])) + r.length + 2
+	}
+
+	h.Override("content-type", "multipart/byteranges; boundary="+boundary)
+	h.Override("content-length", strconv.FormatInt(length, 10))
+	if !writeHead(w, response.StatusPartialContent, h) || req.RequestLine.Method == "HEAD" {
+		return
+	}
+	for i, r := range ranges {
+		if _, err := w.WriteBody([]byte(partHeaders[i])); err != nil {
+			log.Printf("Error writing body: %v", err)
+			return
+		}
+		if !sendRange(w, f, r) {
+			return
+		}
+		if _, err := w.WriteBody([]byte("\r\n")); err != nil {
+			log.Printf("Error writing body: %v", err)
+			return
+		}
+	}
+	if _, err := w.WriteBody([]byte("--" + boundary + "--\r\n")); err != nil {
+		log.Printf("Error writing body: %v", err)
+	}
+}
+
+func newBoundary() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
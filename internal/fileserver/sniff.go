@@ -0,0 +1,96 @@
+package fileserver
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file is looked at to guess its type.
+const sniffLen = 512
+
+// extraTypes covers extensions missing from Go's built-in table, so the
+// answer does not depend on the system's mime.types.
+var extraTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mp3":  "audio/mpeg",
+	".txt":  "text/plain; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+	".ico":  "image/x-icon",
+}
+
+var signatures = []struct {
+	prefix      string
+	contentType string
+}{
+	{"%PDF-", "application/pdf"},
+	{"\x89PNG\r\n\x1a\n", "image/png"},
+	{"\xff\xd8\xff", "image/jpeg"},
+	{"GIF87a", "image/gif"},
+	{"GIF89a", "image/gif"},
+	{"\x1aE\xdf\xa3", "video/webm"},
+	{"PK\x03\x04", "application/zip"},
+	{"\x1f\x8b\x08", "application/gzip"},
+}
+
+// contentTypeOf picks a Content-Type from the file extension or, failing
+// that, from the first bytes of the file. f is left at the start.
+func contentTypeOf(name string, f io.ReadSeeker) (string, error) {
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := extraTypes[ext]; ok {
+		return contentType, nil
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType, nil
+	}
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return sniff(buf[:n]), nil
+}
+
+func sniff(data []byte) string {
+	for _, sig := range signatures {
+		if bytes.HasPrefix(data, []byte(sig.prefix)) {
+			return sig.contentType
+		}
+	}
+	if len(data) >= 8 && string(data[4:8]) == "ftyp" {
+		return "video/mp4"
+	}
+	text := bytes.TrimLeft(data, " \t\r\n")
+	for _, prefix := range []string{"<!doctype html", "<html"} {
+		if len(text) >= len(prefix) && strings.EqualFold(string(text[:len(prefix)]), prefix) {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// isText reports whether data looks like UTF-8 text. A rune cut off at the
+// end of the sniffed prefix is not held against it.
+func isText(data []byte) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			return len(data) < utf8.UTFMax && !utf8.FullRune(data)
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' && r != 0x1b {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
@@ -0,0 +1,22 @@
+package headers
+
+import (
+	"strings"
+	"time"
+)
+
+// TimeFormat is the HTTP date format, as used by Date, Last-Modified and
+// Expires. Times must be in UTC.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// ParseHTTPDate accepts the preferred date format and the two obsolete ones
+// that RFC 9110 still requires recipients to understand.
+func ParseHTTPDate(value string) (time.Time, bool) {
+	for _, layout := range []string{TimeFormat, time.RFC850, time.ANSIC} {
+		t, err := time.Parse(layout, strings.TrimSpace(value))
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,25 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHTTPDate(t *testing.T) {
+	want := time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC)
+	for _, value := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+		" Sun, 06 Nov 1994 08:49:37 GMT ",
+	} {
+		got, ok := ParseHTTPDate(value)
+		assert.True(t, ok, value)
+		assert.True(t, want.Equal(got), value)
+	}
+	_, ok := ParseHTTPDate("yesterday")
+	assert.False(t, ok)
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", want.Format(TimeFormat))
+}
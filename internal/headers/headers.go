@@ -1,6 +1,7 @@
 package headers
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 
@@ -14,13 +15,39 @@ func (h Headers) Get(key string) (string, bool) {
 	return value, ok
 }
 
+// Append adds a value to a field, joining it to any earlier value with a
+// comma. Set-Cookie values cannot be joined that way, since dates in them
+// hold commas, so they are kept one per line and written as separate fields;
+// see Values. Cookie values are joined with "; ", as RFC 9113 does.
 func (h Headers) Append(key, value string) {
-	originalValue, ok := h[strings.ToLower(key)]
-	if ok {
-		h[strings.ToLower(key)] = originalValue + ", " + value
-	} else {
-		h[strings.ToLower(key)] = value
+	key = strings.ToLower(key)
+	originalValue, ok := h[key]
+	if !ok {
+		h[key] = value
+		return
+	}
+	switch key {
+	case "set-cookie":
+		h[key] = originalValue + "\n" + value
+	case "cookie":
+		h[key] = originalValue + "; " + value
+	default:
+		h[key] = originalValue + ", " + value
+	}
+}
+
+// Values returns the field lines for a field: one per Set-Cookie value, and
+// a single line for any other field.
+func (h Headers) Values(key string) []string {
+	key = strings.ToLower(key)
+	value, ok := h[key]
+	if !ok {
+		return nil
+	}
+	if key == "set-cookie" {
+		return strings.Split(value, "\n")
 	}
+	return []string{value}
 }
 
 func (h Headers) Override(key, value string) {
@@ -34,38 +61,93 @@ func (h Headers) Remove(key string) {
 	delete(h, strings.ToLower(key))
 }
 
+var crlf = []byte(internal.CRLF)
+
+// Parse reads one field line from data into h, merging repeated fields.
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
-	text := string(data)
-	lineEndIndex := strings.Index(text, internal.CRLF)
+	n, key, value, done, err := ParseLine(data)
+	if err != nil {
+		return 0, false, err
+	}
+	if n > 0 && !done {
+		h.Append(key, value)
+	}
+	return n, done, nil
+}
+
+// ParseLine reads one field line from the start of data without storing it.
+// It returns 0 bytes consumed if data does not yet hold a full line, and done
+// for the empty line that ends a field section. The key is lowercase. data is
+// scanned in place; only the value and field names missing from commonKeys
+// are allocated.
+func ParseLine(data []byte) (n int, key, value string, done bool, err error) {
+	lineEndIndex := bytes.Index(data, crlf)
 	if lineEndIndex == -1 {
-		return 0, false, nil
+		return 0, "", "", false, nil
 	}
 
 	if lineEndIndex == 0 {
-		return 2, true, nil
+		return 2, "", "", true, nil
 	}
 
-	line := text[:lineEndIndex]
-	parts := strings.SplitN(line, ":", 2)
-	key := strings.TrimSpace(parts[0])
-	value := strings.TrimSpace(parts[1])
-	if key != parts[0] {
-		return 0, false, fmt.Errorf("invalid header line: key cannot contain spaces: '%s'", parts[0])
+	line := data[:lineEndIndex]
+	colon := bytes.IndexByte(line, ':')
+	if colon == -1 {
+		return 0, "", "", false, fmt.Errorf("invalid header line: missing colon: '%s'", line)
+	}
+	rawKey := line[:colon]
+	if len(bytes.TrimSpace(rawKey)) != len(rawKey) {
+		return 0, "", "", false, fmt.Errorf("invalid header line: key cannot contain spaces: '%s'", rawKey)
 	}
 	// ensure key contains only allowed characters per RFC7230 token:
 	// alphanumeric and !#$%&'*+-.^_`|~
-	if !isValidKey(key) {
-		return 0, false, fmt.Errorf("invalid header line: key contains invalid characters: '%s'", parts[0])
+	if !isValidKey(rawKey) {
+		return 0, "", "", false, fmt.Errorf("invalid header line: key contains invalid characters: '%s'", rawKey)
 	}
-	
-	key = strings.ToLower(key)
-	if existingValue, ok := h[key]; ok {
-		value = existingValue + ", " + value
+	// only spaces and tabs count as whitespace around a field value
+	rawValue := bytes.Trim(line[colon+1:], " \t")
+	if !isValidValue(rawValue) {
+		return 0, "", "", false, fmt.Errorf("invalid header line: value of '%s' contains control characters: %q", rawKey, rawValue)
 	}
 
-	h[key] = value
-	
-	return lineEndIndex + 2, false, nil
+	return lineEndIndex + 2, lowerKey(rawKey), string(rawValue), false, nil
+}
+
+// commonKeys interns the field names most requests carry, so parsing them
+// does not allocate a new key string each time.
+var commonKeys = func() map[string]string {
+	m := map[string]string{}
+	for _, k := range []string{
+		"accept", "accept-encoding", "accept-language", "authorization",
+		"cache-control", "connection", "content-encoding", "content-length",
+		"content-type", "cookie", "expect", "host", "if-match",
+		"if-modified-since", "if-none-match", "if-range", "origin", "range",
+		"referer", "te", "trailer", "transfer-encoding", "upgrade",
+		"user-agent", "x-forwarded-for",
+	} {
+		m[k] = k
+	}
+	return m
+}()
+
+// lowerKey returns the lowercase form of a valid field name.
+func lowerKey(k []byte) string {
+	var buf [32]byte
+	if len(k) > len(buf) {
+		return strings.ToLower(string(k))
+	}
+	lower := buf[:len(k)]
+	for i, c := range k {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	// the compiler does not allocate for a conversion used only as a map key
+	if key, ok := commonKeys[string(lower)]; ok {
+		return key
+	}
+	return string(lower)
 }
 
 // validKeyChars reports whether r is a character permitted in header field names.
@@ -86,13 +168,25 @@ func validKeyChars(r rune) bool {
 	return false
 }
 
-// isValidKey checks that the entire key string only consists of allowed characters.
-func isValidKey(k string) bool {
-	if k == "" {
+// isValidKey checks that the entire key only consists of allowed characters.
+func isValidKey(k []byte) bool {
+	if len(k) == 0 {
 		return false
 	}
-	for _, r := range k {
-		if !validKeyChars(r) {
+	for _, c := range k {
+		if !validKeyChars(rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidValue checks a field value holds only visible characters, spaces,
+// tabs and obs-text (bytes from 0x80), as RFC 9110 section 5.5 allows.
+func isValidValue(v []byte) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < ' ' && c != '\t' || c == 0x7f {
 			return false
 		}
 	}
@@ -0,0 +1,79 @@
+package headers
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"Host: localhost:42069\r\n\r\n",
+		"Foo:    bar   \r\n\r\n",
+		"Fiz: baz\r\nFiz: qux\r\n\r\n",
+		"Cookie: a=1\r\nCookie: b=2\r\nSet-Cookie: c=3\r\nSet-Cookie: d=4\r\n\r\n",
+		"       Host : localhost:42069       \r\n\r\n",
+		"H©st: localhost:42069\r\n\r\n",
+		"foo\r\n\r\n",
+		": empty\r\n\r\n",
+		"X-Tab:\tvalue\t\r\n\r\n",
+		"\r\n",
+		"no end",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h := NewHeaders()
+		total := 0
+		done := false
+		for !done {
+			n, d, err := h.Parse(data[total:])
+			if err != nil {
+				return
+			}
+			if n < 0 || total+n > len(data) {
+				t.Fatalf("consumed %d bytes at offset %d of %d", n, total, len(data))
+			}
+			if n == 0 {
+				// the only reason to consume nothing is an incomplete line
+				if bytes.Contains(data[total:], []byte("\r\n")) {
+					t.Fatalf("no progress on a complete line: %q", data[total:])
+				}
+				return
+			}
+			total += n
+			done = d
+		}
+
+		// everything we accept, textproto must read the same way
+		tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(data[:total])))
+		want, err := tp.ReadMIMEHeader()
+		if err != nil {
+			t.Fatalf("accepted %q, textproto rejected it: %v", data[:total], err)
+		}
+		if len(want) != len(h) {
+			t.Fatalf("got %d fields, textproto got %d: %q", len(h), len(want), data[:total])
+		}
+		for key, values := range want {
+			got, ok := h.Get(key)
+			if !ok || got != joinValues(key, values) {
+				t.Fatalf("field %q: got %q, textproto got %q", key, got, values)
+			}
+		}
+	})
+}
+
+// joinValues combines repeated field values the way Append does.
+func joinValues(key string, values []string) string {
+	switch strings.ToLower(key) {
+	case "set-cookie":
+		return strings.Join(values, "\n")
+	case "cookie":
+		return strings.Join(values, "; ")
+	default:
+		return strings.Join(values, ", ")
+	}
+}
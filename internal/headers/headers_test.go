@@ -139,3 +139,40 @@ func TestGoodDuplicateHeaderKey(t *testing.T) {
 	assert.True(t, done)
 	assert.Equal(t, l, total)
 }
+
+func TestMissingColon(t *testing.T) {
+	// Test: a line without a colon is an error, not a panic
+	headers := NewHeaders()
+	n, done, err := headers.Parse([]byte("foo\r\n\r\n"))
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+}
+
+func TestControlCharacterInValue(t *testing.T) {
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("Foo: a\x00b\r\n\r\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"a\x00b"`)
+
+	// Test: tabs are allowed and trimmed like spaces, other whitespace is kept
+	data := []byte("Foo:\tbar\u00a0\t\r\n")
+	n, _, err := headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, "bar\u00a0", headers["foo"])
+}
+
+func TestAppendKeepsSetCookieFieldsApart(t *testing.T) {
+	h := NewHeaders()
+	h.Append("Set-Cookie", "a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT")
+	h.Append("set-cookie", "b=2")
+	h.Append("Cookie", "a=1")
+	h.Append("Cookie", "b=2")
+	h.Append("Accept", "text/html")
+	h.Append("Accept", "*/*")
+	assert.Equal(t, []string{"a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT", "b=2"}, h.Values("Set-Cookie"))
+	assert.Equal(t, []string{"a=1; b=2"}, h.Values("cookie"))
+	assert.Equal(t, []string{"text/html, */*"}, h.Values("accept"))
+	assert.Nil(t, h.Values("missing"))
+}
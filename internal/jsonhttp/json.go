@@ -0,0 +1,156 @@
+package jsonhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"strings"
+
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+// DefaultMaxBodySize is the body size limit when Decoder.MaxBodySize is not
+// set.
+const DefaultMaxBodySize = 1 << 20
+
+// Decoder reads JSON request bodies.
+type Decoder struct {
+	// MaxBodySize caps the body in bytes. Zero means DefaultMaxBodySize.
+	// The server has already read the body by the time Decode runs, bounded
+	// by request.DefaultMaxBodySize, so this is a check on what the
+	// endpoint accepts rather than a bound on reading.
+	MaxBodySize int
+	// AllowUnknownFields accepts object members that have no matching
+	// struct field. By default they are an error, which catches typos.
+	AllowUnknownFields bool
+}
+
+// Decode unmarshals the body of req into v with a zero Decoder.
+func Decode(req *request.Request, v any) error {
+	return (&Decoder{}).Decode(req, v)
+}
+
+// Read decodes the body of req into v with a zero Decoder, answering the
+// request with a problem response if it cannot.
+func Read(w *response.Writer, req *request.Request, v any) bool {
+	return (&Decoder{}).Read(w, req, v)
+}
+
+// Decode unmarshals the body of req, which must be a single JSON value,
+// into v. Errors are *Problem values: 415 for a Content-Type that is not
+// JSON, 413 for a body over the limit, and 400 for anything wrong with the
+// JSON itself.
+func (d *Decoder) Decode(req *request.Request, v any) error {
+	if err := checkContentType(req); err != nil {
+		return err
+	}
+	maxSize := d.MaxBodySize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+	if len(req.Body) > maxSize {
+		return NewProblem(response.StatusContentTooLarge, fmt.Sprintf("request body is larger than %d bytes", maxSize))
+	}
+	if len(bytes.TrimSpace(req.Body)) == 0 {
+		return NewProblem(response.StatusBadRequest, "request body is empty")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(req.Body))
+	if !d.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return NewProblem(response.StatusBadRequest, describe(err))
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return NewProblem(response.StatusBadRequest, "request body has data after the JSON value")
+	}
+	return nil
+}
+
+// Read decodes the body of req into v and reports whether it could. If not,
+// it has already answered with the problem.
+func (d *Decoder) Read(w *response.Writer, req *request.Request, v any) bool {
+	err := d.Decode(req, v)
+	if err == nil {
+		return true
+	}
+	var p *Problem
+	if !errors.As(err, &p) {
+		p = NewProblem(response.StatusBadRequest, err.Error())
+	}
+	WriteProblem(w, p)
+	return false
+}
+
+// Write sends v as a JSON response with the given status.
+func Write(w *response.Writer, status response.StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	writeBody(w, status, "application/json", body)
+	return nil
+}
+
+// checkContentType accepts application/json and the +json types, like
+// application/merge-patch+json. JSON is always UTF-8, so any other charset
+// is refused.
+func checkContentType(req *request.Request) error {
+	value, ok := req.Headers.Get("Content-Type")
+	if !ok {
+		return NewProblem(response.StatusUnsupportedMediaType, "Content-Type must be application/json")
+	}
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return NewProblem(response.StatusUnsupportedMediaType, fmt.Sprintf("Content-Type must be application/json, not %q", value))
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		return NewProblem(response.StatusUnsupportedMediaType, fmt.Sprintf("unsupported charset %q", charset))
+	}
+	return nil
+}
+
+// describe turns a decoding error into a message for the client.
+func describe(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("malformed JSON at byte %d: %v", syntaxErr.Offset, syntaxErr)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("field %q must be %s, not %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("body must be %s, not %s", typeErr.Type, typeErr.Value)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "malformed JSON: unexpected end of body"
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return "unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	}
+	return err.Error()
+}
+
+func writeBody(w *response.Writer, status response.StatusCode, contentType string, body []byte) {
+	body = append(body, '\n')
+	err := w.WriteStatusLine(status)
+	if err != nil {
+		log.Printf("Error writing status line: %v", err)
+		return
+	}
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("content-type", contentType)
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("Error writing headers: %v", err)
+		return
+	}
+	_, err = w.WriteBody(body)
+	if err != nil {
+		log.Printf("Error writing body: %v", err)
+	}
+}
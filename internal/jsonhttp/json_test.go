@@ -0,0 +1,141 @@
+package jsonhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type widget struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func jsonRequest(contentType, body string) *request.Request {
+	var h map[string]string
+	if contentType != "" {
+		h = map[string]string{"content-type": contentType}
+	}
+	return servertest.NewRequest("POST", "/widgets", []byte(body), h)
+}
+
+func TestDecode(t *testing.T) {
+	for _, contentType := range []string{"application/json", "application/json; charset=UTF-8", "application/merge-patch+json"} {
+		var got widget
+		require.NoError(t, Decode(jsonRequest(contentType, `{"name":"bolt","count":3}`), &got), contentType)
+		assert.Equal(t, widget{"bolt", 3}, got)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		contentType string
+		body        string
+		status      response.StatusCode
+		detail      string
+	}{
+		"no content type":  {"", `{}`, response.StatusUnsupportedMediaType, "Content-Type"},
+		"form":             {"application/x-www-form-urlencoded", `a=1`, response.StatusUnsupportedMediaType, "Content-Type"},
+		"latin-1":          {"application/json; charset=latin1", `{}`, response.StatusUnsupportedMediaType, "charset"},
+		"empty":            {"application/json", "  ", response.StatusBadRequest, "empty"},
+		"syntax":           {"application/json", `{"name":}`, response.StatusBadRequest, "byte 9"},
+		"truncated":        {"application/json", `{"name":"bolt"`, response.StatusBadRequest, "unexpected end"},
+		"wrong type":       {"application/json", `{"count":"three"}`, response.StatusBadRequest, `field "count" must be int, not string`},
+		"not an object":    {"application/json", `[1]`, response.StatusBadRequest, "must be jsonhttp.widget, not array"},
+		"unknown field":    {"application/json", `{"nmae":"bolt"}`, response.StatusBadRequest, `unknown field "nmae"`},
+		"trailing data":    {"application/json", `{"name":"a"} {"name":"b"}`, response.StatusBadRequest, "after the JSON value"},
+		"trailing garbage": {"application/json", `{"name":"a"}x`, response.StatusBadRequest, "after the JSON value"},
+		"too large":        {"application/json", `{"name":"` + strings.Repeat("x", DefaultMaxBodySize) + `"}`, response.StatusContentTooLarge, "larger than"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var got widget
+			err := Decode(jsonRequest(tc.contentType, tc.body), &got)
+			var p *Problem
+			require.True(t, errors.As(err, &p), "%v", err)
+			assert.Equal(t, tc.status, p.Status)
+			assert.Contains(t, p.Detail, tc.detail)
+		})
+	}
+}
+
+func TestDecoderOptions(t *testing.T) {
+	var got widget
+	d := &Decoder{AllowUnknownFields: true, MaxBodySize: 64}
+	require.NoError(t, d.Decode(jsonRequest("application/json", `{"name":"bolt","extra":1}`), &got))
+	assert.Equal(t, "bolt", got.Name)
+	err := d.Decode(jsonRequest("application/json", `{"name":"`+strings.Repeat("x", 64)+`"}`), &got)
+	assert.ErrorContains(t, err, "413")
+}
+
+func TestReadWritesProblem(t *testing.T) {
+	req := jsonRequest("text/plain", "hi")
+	res, err := servertest.Record(func(w *response.Writer, req *request.Request) {
+		var got widget
+		assert.False(t, Read(w, req, &got))
+	}, req)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusUnsupportedMediaType, res.StatusLine.StatusCode)
+	assert.Equal(t, ProblemContentType, res.Headers["content-type"])
+
+	var p Problem
+	require.NoError(t, json.Unmarshal(res.Body, &p))
+	assert.Equal(t, "Unsupported Media Type", p.Title)
+	assert.Equal(t, response.StatusUnsupportedMediaType, p.Status)
+}
+
+func TestWrite(t *testing.T) {
+	res, err := servertest.Record(func(w *response.Writer, req *request.Request) {
+		require.NoError(t, Write(w, response.StatusOK, widget{"bolt", 3}))
+	}, servertest.NewRequest("GET", "/", nil, nil))
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", res.Headers["content-type"])
+	assert.Equal(t, "{\"name\":\"bolt\",\"count\":3}\n", string(res.Body))
+
+	err = Write(response.NewWriter(&strings.Builder{}), response.StatusOK, make(chan int))
+	assert.Error(t, err)
+}
+
+func TestProblemJSON(t *testing.T) {
+	p := NewProblem(response.StatusBadRequest, "name is required")
+	p.Type = "https://example.com/probs/validation"
+	p.Instance = "/widgets/7"
+	p.Extensions = map[string]any{"fields": []string{"name"}, "title": "ignored"}
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "https://example.com/probs/validation",
+		"title": "Bad Request",
+		"status": 400,
+		"detail": "name is required",
+		"instance": "/widgets/7",
+		"fields": ["name"]
+	}`, string(data))
+
+	var back Problem
+	require.NoError(t, json.Unmarshal(data, &back))
+	assert.Equal(t, p.Type, back.Type)
+	assert.Equal(t, p.Status, back.Status)
+	assert.Equal(t, []any{"name"}, back.Extensions["fields"])
+	assert.Equal(t, "400 Bad Request: name is required", back.Error())
+
+	// about:blank problems leave out the empty members
+	data, err = json.Marshal(&Problem{Status: response.StatusNotFound})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":404}`, string(data))
+}
+
+func TestWriteProblem(t *testing.T) {
+	res, err := servertest.Record(func(w *response.Writer, req *request.Request) {
+		WriteProblem(w, &Problem{Title: "Out of widgets"})
+	}, servertest.NewRequest("GET", "/", nil, nil))
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusInternalServerError, res.StatusLine.StatusCode)
+	assert.JSONEq(t, `{"title":"Out of widgets"}`, string(res.Body))
+}
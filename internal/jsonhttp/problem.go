@@ -0,0 +1,120 @@
+package jsonhttp
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+// ProblemContentType is the media type of problem details (RFC 9457).
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 9457 problem details object, the body of an error
+// response. It is also an error, so helpers can return one for the handler
+// to pass to WriteProblem.
+type Problem struct {
+	// Type is a URI identifying the kind of problem. Empty means
+	// "about:blank": nothing more than the status code.
+	Type string
+	// Title is a short summary of the kind of problem.
+	Title string
+	// Status is the HTTP status code.
+	Status response.StatusCode
+	// Detail explains this occurrence of the problem.
+	Detail string
+	// Instance is a URI identifying this occurrence of the problem.
+	Instance string
+	// Extensions are extra members, such as a list of invalid fields.
+	// Members with the names above are ignored.
+	Extensions map[string]any
+}
+
+// NewProblem returns a Problem for a status code, titled with its reason
+// phrase.
+func NewProblem(status response.StatusCode, detail string) *Problem {
+	return &Problem{
+		Title:  response.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func (p *Problem) Error() string {
+	msg := strconv.Itoa(int(p.Status)) + " " + p.Title
+	if p.Detail != "" {
+		msg += ": " + p.Detail
+	}
+	return msg
+}
+
+// MarshalJSON writes the standard members alongside the extensions.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(p.Extensions)+5)
+	for key, value := range p.Extensions {
+		members[key] = value
+	}
+	// the standard members always win over extensions of the same name
+	for key, value := range map[string]string{"type": p.Type, "title": p.Title, "detail": p.Detail, "instance": p.Instance} {
+		delete(members, key)
+		if value != "" {
+			members[key] = value
+		}
+	}
+	delete(members, "status")
+	if p.Status != 0 {
+		members["status"] = int(p.Status)
+	}
+	return json.Marshal(members)
+}
+
+// UnmarshalJSON reads the standard members and keeps the rest as extensions.
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	*p = Problem{}
+	for key, raw := range members {
+		var err error
+		switch key {
+		case "type":
+			err = json.Unmarshal(raw, &p.Type)
+		case "title":
+			err = json.Unmarshal(raw, &p.Title)
+		case "status":
+			err = json.Unmarshal(raw, &p.Status)
+		case "detail":
+			err = json.Unmarshal(raw, &p.Detail)
+		case "instance":
+			err = json.Unmarshal(raw, &p.Instance)
+		default:
+			var value any
+			err = json.Unmarshal(raw, &value)
+			if p.Extensions == nil {
+				p.Extensions = map[string]any{}
+			}
+			p.Extensions[key] = value
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteProblem writes p as an application/problem+json response with its
+// status code. A Problem without a status is sent as a 500.
+func WriteProblem(w *response.Writer, p *Problem) {
+	status := p.Status
+	if status == 0 {
+		status = response.StatusInternalServerError
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("Error encoding problem: %v", err)
+		body, _ = json.Marshal(NewProblem(status, ""))
+	}
+	writeBody(w, status, ProblemContentType, body)
+}
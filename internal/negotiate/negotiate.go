@@ -0,0 +1,244 @@
+package negotiate
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+// Range is one element of an Accept-style header: a media range like
+// "text/*", a language range or a charset, with its parameters and weight.
+type Range struct {
+	// Value is the range in lower case, without parameters.
+	Value string
+	// Params holds the parameters before the weight, with lower case names.
+	// Only media ranges have them.
+	Params map[string]string
+	// Q is the weight, from 0 to 1.
+	Q float64
+}
+
+// Parse splits an Accept, Accept-Language or Accept-Charset value into its
+// ranges, in the order given. Elements with a malformed weight are dropped.
+func Parse(value string) []Range {
+	var ranges []Range
+	for _, element := range splitQuoted(value, ',') {
+		parts := splitQuoted(element, ';')
+		r := Range{Value: strings.ToLower(strings.TrimSpace(parts[0])), Q: 1}
+		if r.Value == "" {
+			continue
+		}
+		ok := true
+		for _, param := range parts[1:] {
+			key, value, _ := strings.Cut(param, "=")
+			key = strings.ToLower(strings.TrimSpace(key))
+			value = unquote(strings.TrimSpace(value))
+			if key == "q" {
+				q, err := strconv.ParseFloat(value, 64)
+				ok = err == nil && q >= 0 && q <= 1
+				r.Q = q
+				// anything after the weight is an extension, not a parameter
+				break
+			}
+			if key == "" {
+				continue
+			}
+			if r.Params == nil {
+				r.Params = map[string]string{}
+			}
+			r.Params[key] = value
+		}
+		if ok {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// ContentType picks the offer, a media type like "application/json", that
+// the Accept header of h ranks highest. The most specific range matching an
+// offer gives its weight, and ties go to the earlier offer. Without an
+// Accept header the first offer is chosen. It returns "" when the client
+// accepts none of the offers.
+func ContentType(h headers.Headers, offers ...string) string {
+	ranges, ok := parseHeader(h, "Accept")
+	if !ok {
+		return first(offers)
+	}
+	return best(offers, func(offer string) (float64, bool) {
+		offerType, offerParams := splitMediaType(offer)
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			s, ok := mediaMatch(r, offerType, offerParams)
+			if ok && s > specificity {
+				q, specificity = r.Q, s
+			}
+		}
+		return q, specificity >= 0
+	})
+}
+
+// Language picks the offer, a language tag like "en-GB", that the
+// Accept-Language header of h ranks highest. A range matches a tag equal to
+// it or starting with it followed by "-", as in RFC 4647 basic filtering,
+// and the longest matching range gives the weight. Without the header the
+// first offer is chosen; it returns "" when none is acceptable.
+func Language(h headers.Headers, offers ...string) string {
+	ranges, ok := parseHeader(h, "Accept-Language")
+	if !ok {
+		return first(offers)
+	}
+	return best(offers, func(offer string) (float64, bool) {
+		tag := strings.ToLower(offer)
+		q, length := 0.0, -1
+		for _, r := range ranges {
+			matched := r.Value == "*" || tag == r.Value || strings.HasPrefix(tag, r.Value+"-")
+			l := len(r.Value)
+			if r.Value == "*" {
+				l = 0
+			}
+			if matched && l > length {
+				q, length = r.Q, l
+			}
+		}
+		return q, length >= 0
+	})
+}
+
+// Charset picks the offer, a charset like "utf-8", that the Accept-Charset
+// header of h ranks highest. Without the header the first offer is chosen;
+// it returns "" when none is acceptable.
+func Charset(h headers.Headers, offers ...string) string {
+	ranges, ok := parseHeader(h, "Accept-Charset")
+	if !ok {
+		return first(offers)
+	}
+	return best(offers, func(offer string) (float64, bool) {
+		wildcard, hasWildcard := 0.0, false
+		for _, r := range ranges {
+			if r.Value == strings.ToLower(offer) {
+				return r.Q, true
+			}
+			if r.Value == "*" {
+				wildcard, hasWildcard = r.Q, true
+			}
+		}
+		return wildcard, hasWildcard
+	})
+}
+
+// Choose picks a content type for req from offers, as ContentType does. If
+// the client accepts none of them it answers 406 Not Acceptable, listing the
+// offers, and returns false.
+//
+// The response depends on the Accept header, so handlers that use it should
+// add "Accept" to Vary.
+func Choose(w *response.Writer, req *request.Request, offers ...string) (string, bool) {
+	contentType := ContentType(req.Headers, offers...)
+	if contentType != "" {
+		return contentType, true
+	}
+	response.WriteError(w, response.StatusNotAcceptable, headers.Headers{"vary": "Accept"}, "not acceptable; available types: "+strings.Join(offers, ", "))
+	return "", false
+}
+
+// parseHeader parses the named header. An empty one is treated as missing,
+// as clients that send it mean no preference.
+func parseHeader(h headers.Headers, name string) ([]Range, bool) {
+	value, ok := h.Get(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, false
+	}
+	return Parse(value), true
+}
+
+// best returns the offer with the highest weight above zero, preferring
+// earlier offers on ties.
+func best(offers []string, weigh func(offer string) (float64, bool)) string {
+	chosen, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, ok := weigh(offer)
+		if ok && q > bestQ {
+			chosen, bestQ = offer, q
+		}
+	}
+	return chosen
+}
+
+func first(offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	return offers[0]
+}
+
+// mediaMatch reports whether the media range r matches an offer, and how
+// specifically: 0 for "*/*", 1 for "type/*", 2 for the exact type, plus one
+// for each parameter the range requires.
+func mediaMatch(r Range, offerType string, offerParams map[string]string) (int, bool) {
+	rangeType, rangeSubtype, _ := strings.Cut(r.Value, "/")
+	offerMain, offerSubtype, _ := strings.Cut(offerType, "/")
+	switch {
+	case rangeType == "*" && rangeSubtype == "*":
+		return 0, true
+	case rangeType != offerMain:
+		return 0, false
+	case rangeSubtype == "*":
+		return 1, true
+	case rangeSubtype != offerSubtype:
+		return 0, false
+	}
+	for key, value := range r.Params {
+		if !strings.EqualFold(offerParams[key], value) {
+			return 0, false
+		}
+	}
+	return 2 + len(r.Params), true
+}
+
+func splitMediaType(mediaType string) (string, map[string]string) {
+	parts := splitQuoted(mediaType, ';')
+	params := map[string]string{}
+	for _, param := range parts[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		params[strings.ToLower(strings.TrimSpace(key))] = unquote(strings.TrimSpace(value))
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), params
+}
+
+// splitQuoted splits s on sep, except inside quoted strings, where
+// parameter values may contain commas and semicolons.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	inQuotes, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && inQuotes:
+			i++
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
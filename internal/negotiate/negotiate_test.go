@@ -0,0 +1,104 @@
+package negotiate
+
+import (
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+)
+
+var pageTypes = []string{"text/html", "application/json", "text/plain"}
+
+func TestParse(t *testing.T) {
+	ranges := Parse(`Text/HTML;Level=1;q=0.5;ext=x, application/json;profile="a,b", */*;q=oops, , en`)
+	assert.Equal(t, []Range{
+		{Value: "text/html", Params: map[string]string{"level": "1"}, Q: 0.5},
+		{Value: "application/json", Params: map[string]string{"profile": "a,b"}, Q: 1},
+		{Value: "en", Q: 1},
+	}, ranges)
+}
+
+func TestContentType(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                    "text/html",
+		"*/*":                                 "text/html",
+		"application/json":                    "application/json",
+		"text/*":                              "text/html",
+		"text/*, text/html;q=0":               "text/plain",
+		"text/html;q=0.5, application/json":   "application/json",
+		"text/plain, application/json":        "application/json",
+		"image/png":                           "",
+		"*/*;q=0":                             "",
+		"application/*;q=0.2, */*;q=0.1":      "application/json",
+		"TEXT/PLAIN;q=0.9, text/html;q=0.8":   "text/plain",
+		"text/html;level=1, text/plain;q=0.5": "text/plain",
+		"application/json;q=1.1, text/plain":  "text/plain",
+		"text/html;q=0.001, application/json;q=0": "text/html",
+	} {
+		h := headers.NewHeaders()
+		if accept != "" {
+			h.Override("accept", accept)
+		}
+		assert.Equal(t, want, ContentType(h, pageTypes...), "%q", accept)
+	}
+}
+
+func TestContentTypeWithParameters(t *testing.T) {
+	h := headers.Headers{"accept": "text/html;level=1, text/html;q=0.1"}
+	assert.Equal(t, "text/html;level=1", ContentType(h, "text/html", "text/html;level=1"))
+	h = headers.Headers{"accept": "text/html;level=2"}
+	assert.Equal(t, "", ContentType(h, "text/html;level=1"))
+}
+
+func TestLanguage(t *testing.T) {
+	offers := []string{"en", "en-GB", "fr"}
+	for accept, want := range map[string]string{
+		"":                          "en",
+		"fr":                        "fr",
+		"en-gb, en;q=0.8":           "en-GB",
+		"en-US, fr;q=0.5":           "fr",
+		"de, *;q=0.1":               "en",
+		"de":                        "",
+		"en;q=0.4, *;q=0.5":         "fr",
+		"fr-CH, fr;q=0.9, en;q=0.8": "fr",
+		"en;q=0.9, en-gb;q=0":       "en",
+	} {
+		h := headers.NewHeaders()
+		if accept != "" {
+			h.Override("accept-language", accept)
+		}
+		assert.Equal(t, want, Language(h, offers...), "%q", accept)
+	}
+}
+
+func TestCharset(t *testing.T) {
+	offers := []string{"utf-8", "iso-8859-1"}
+	for accept, want := range map[string]string{
+		"":                     "utf-8",
+		"ISO-8859-1":           "iso-8859-1",
+		"utf-8;q=0.5, *":       "iso-8859-1",
+		"utf-8;q=0.5, *;q=0.5": "utf-8",
+		"shift_jis":            "",
+	} {
+		h := headers.NewHeaders()
+		if accept != "" {
+			h.Override("accept-charset", accept)
+		}
+		assert.Equal(t, want, Charset(h, offers...), "%q", accept)
+	}
+}
+
+func TestChooseAnswersNotAcceptable(t *testing.T) {
+	called := false
+	res := servertest.Run(t, func(w *response.Writer, req *request.Request) {
+		_, ok := Choose(w, req, pageTypes...)
+		called = !ok
+	}, servertest.NewRequest("GET", "/", nil, map[string]string{"accept": "image/png"}))
+	assert.True(t, called)
+	assert.Equal(t, response.StatusNotAcceptable, res.StatusLine.StatusCode)
+	assert.Equal(t, "Accept", res.Headers["vary"])
+	assert.Contains(t, string(res.Body), "application/json")
+}
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jonvanw/httpfromtcp/internal"
+	"github.com/jonvanw/httpfromtcp/internal/client"
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+// hopByHopHeaders apply to a single connection and must not be forwarded.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ReverseProxy forwards requests to one of a set of upstream servers and
+// streams the responses back to the client.
+type ReverseProxy struct {
+	// Upstreams are base URLs such as "http://10.0.0.5:8080" or
+	// "https://httpbin.org/anything". Requests are spread over them
+	// round-robin and the request target is appended to the base path.
+	Upstreams []string
+	// StripPrefix is removed from the request target before forwarding, so
+	// "/httpbin/get" with StripPrefix "/httpbin" is sent upstream as "/get".
+	// It only matches whole path segments: "/httpbinfoo" is left alone.
+	StripPrefix string
+	// PreserveHost forwards the client's Host header instead of the
+	// upstream's host.
+	PreserveHost bool
+	// DigestTrailers adds X-Content-SHA256 and X-Content-Length trailers
+	// computed over the body as it streams through.
+	DigestTrailers bool
+	// Client sends the upstream requests. Nil means client.DefaultClient.
+	// Its dial and response header timeouts decide when to reply 504, and
+	// its body read timeout ends a response whose upstream stops sending.
+	Client *client.Client
+
+	next atomic.Uint64
+}
+
+// Handle is a server.Handler that forwards req upstream.
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	if len(p.Upstreams) == 0 {
+		response.WriteError(w, response.StatusBadGateway, nil, "no upstream configured")
+		return
+	}
+	raw := p.Upstreams[(p.next.Add(1)-1)%uint64(len(p.Upstreams))]
+	upstream, err := url.Parse(raw)
+	if err != nil || (upstream.Scheme != "http" && upstream.Scheme != "https") {
+		log.Printf("Invalid upstream %q: %v", raw, err)
+		response.WriteError(w, response.StatusBadGateway, nil, "invalid upstream")
+		return
+	}
+
+	upstreamReq, err := client.NewRequest(req.RequestLine.Method, upstream.Scheme+"://"+upstream.Host+p.upstreamTarget(upstream, req.RequestLine.RequestTarget), nil)
+	if err != nil {
+		log.Printf("Error building upstream request: %v", err)
+		response.WriteError(w, response.StatusBadGateway, nil, "invalid upstream request")
+		return
+	}
+	upstreamReq.Headers = p.outgoingHeaders(req, upstream)
+	if req.Streaming() {
+		// pass an upload on as it arrives, framed the way it came in
+		upstreamReq.Body = req.BodyReader()
+		upstreamReq.ContentLength = -1
+		if length, ok := req.Headers.Get("Content-Length"); ok {
+			upstreamReq.ContentLength, _ = strconv.ParseInt(length, 10, 64)
+		}
+	} else if _, ok := req.Headers.Get("Content-Length"); ok || len(req.Body) > 0 {
+		upstreamReq.Body = bytes.NewReader(req.Body)
+		upstreamReq.ContentLength = int64(len(req.Body))
+	}
+
+	c := p.Client
+	if c == nil {
+		c = client.DefaultClient
+	}
+	resp, err := c.Do(upstreamReq)
+	if err != nil {
+		log.Printf("Error forwarding request upstream: %v", err)
+		writeUpstreamError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	p.copyResponse(w, req, resp)
+}
+
+func (p *ReverseProxy) copyResponse(w *response.Writer, req *request.Request, resp *client.Response) {
+	err := w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+	if err != nil {
+		log.Printf("Error writing status line: %v", err)
+		return
+	}
+	h := resp.Headers
+	removeHopByHop(h)
+	h.Override("connection", "close")
+	if resp.StatusCode == 204 || resp.StatusCode == 304 || resp.StatusCode < 200 || req.RequestLine.Method == "HEAD" {
+		err = w.WriteHeaders(h)
+		if err != nil {
+			log.Printf("Error writing headers: %v", err)
+		}
+		return
+	}
+
+	// whatever framing the upstream used, the body is re-chunked to the client
+	h.Remove("content-length")
+	h.Override("transfer-encoding", "chunked")
+	if p.DigestTrailers {
+		h.Append("trailer", "X-Content-SHA256, X-Content-Length")
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("Error writing headers: %v", err)
+		return
+	}
+
+	var digest hash.Hash
+	if p.DigestTrailers {
+		digest = sha256.New()
+	}
+	total := 0
+	buf := make([]byte, internal.BUFFSIZE)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			total += n
+			if digest != nil {
+				digest.Write(buf[:n])
+			}
+			_, werr := w.WriteChunkedBody(buf[:n])
+			if werr == nil {
+				werr = w.Flush()
+			}
+			if werr != nil {
+				log.Printf("Error writing chunked body: %v", werr)
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				// the status line is already out, so all we can do is stop
+				log.Printf("Error reading upstream body: %v", err)
+				return
+			}
+			break
+		}
+	}
+
+	_, err = w.WriteChunkedBodyDone()
+	if err != nil {
+		log.Printf("Error finishing chunked body: %v", err)
+		return
+	}
+	trailers := resp.Trailers
+	if trailers == nil {
+		trailers = headers.NewHeaders()
+	}
+	if digest != nil {
+		trailers.Override("X-Content-SHA256", fmt.Sprintf("%x", digest.Sum(nil)))
+		trailers.Override("X-Content-Length", strconv.Itoa(total))
+	}
+	err = w.WriteTrailers(trailers)
+	if err != nil {
+		log.Printf("Error writing trailers: %v", err)
+	}
+}
+
+// upstreamTarget joins the upstream's base path with the request target.
+func (p *ReverseProxy) upstreamTarget(upstream *url.URL, target string) string {
+	target = stripPrefix(target, p.StripPrefix)
+	if !strings.HasPrefix(target, "/") {
+		target = "/" + target
+	}
+	base := strings.TrimSuffix(upstream.EscapedPath(), "/")
+	return base + target
+}
+
+// stripPrefix removes prefix from target when it ends at a segment boundary:
+// the end of the path, a slash or the query.
+func stripPrefix(target, prefix string) string {
+	rest, ok := strings.CutPrefix(target, prefix)
+	if !ok {
+		return target
+	}
+	if rest == "" || rest[0] == '/' || rest[0] == '?' || strings.HasSuffix(prefix, "/") {
+		return rest
+	}
+	return target
+}
+
+func (p *ReverseProxy) outgoingHeaders(req *request.Request, upstream *url.URL) headers.Headers {
+	h := headers.NewHeaders()
+	for key, value := range req.Headers {
+		h[key] = value
+	}
+	removeHopByHop(h)
+
+	originalHost, _ := req.Headers.Get("Host")
+	if !p.PreserveHost || originalHost == "" {
+		h.Override("host", upstream.Host)
+	}
+
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	forwarded := []string{}
+	if clientIP != "" {
+		h.Append("x-forwarded-for", clientIP)
+		if strings.Contains(clientIP, ":") {
+			// IPv6 addresses must be bracketed and quoted (RFC 7239 section 6)
+			forwarded = append(forwarded, fmt.Sprintf("for=\"[%s]\"", clientIP))
+		} else {
+			forwarded = append(forwarded, "for="+clientIP)
+		}
+	}
+	if originalHost != "" {
+		h.Override("x-forwarded-host", originalHost)
+		forwarded = append(forwarded, fmt.Sprintf("host=%q", originalHost))
+	}
+	h.Override("x-forwarded-proto", "http")
+	forwarded = append(forwarded, "proto=http")
+	h.Append("forwarded", strings.Join(forwarded, ";"))
+
+	return h
+}
+
+// removeHopByHop deletes the standard hop-by-hop headers plus any listed in
+// the Connection header.
+func removeHopByHop(h headers.Headers) {
+	if connection, ok := h.Get("Connection"); ok {
+		for _, name := range strings.Split(connection, ",") {
+			h.Remove(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Remove(name)
+	}
+}
+
+func writeUpstreamError(w *response.Writer, err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		response.WriteError(w, response.StatusGatewayTimeout, nil, "upstream timed out")
+		return
+	}
+	response.WriteError(w, response.StatusBadGateway, nil, "upstream unavailable")
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/client"
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/server"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyForwardsMethodHeadersAndBody(t *testing.T) {
+	seen := make(chan *request.Request, 1)
+	upstream := servertest.NewServer(t, func(w *response.Writer, req *request.Request) {
+		seen <- req
+		body := "created"
+		w.WriteStatusLine(response.StatusCode(201))
+		h := response.GetDefaultHeaders(len(body))
+		h.Override("x-upstream", "yes")
+		h.Override("keep-alive", "timeout=5")
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	})
+
+	p := &ReverseProxy{Upstreams: []string{upstream.URL + "/base"}, StripPrefix: "/api"}
+	req := newRequest("PUT", "/api/items/1", `{"name":"x"}`)
+	req.Headers.Override("connection", "close, x-secret")
+	req.Headers.Override("x-secret", "hop")
+	req.Headers.Override("x-forwarded-for", "10.1.1.1")
+	out := proxyRequest(p, req)
+
+	got := <-seen
+	assert.Equal(t, "PUT", got.RequestLine.Method)
+	assert.Equal(t, "/base/items/1", got.RequestLine.RequestTarget)
+	assert.Equal(t, `{"name":"x"}`, string(got.Body))
+	host, _ := got.Headers.Get("Host")
+	assert.Equal(t, upstream.Addr, host)
+	_, ok := got.Headers.Get("X-Secret")
+	assert.False(t, ok, "headers named in Connection must not be forwarded")
+	xff, _ := got.Headers.Get("X-Forwarded-For")
+	assert.Equal(t, "10.1.1.1, 192.0.2.7", xff)
+	xfh, _ := got.Headers.Get("X-Forwarded-Host")
+	assert.Equal(t, "client.example", xfh)
+	fwd, _ := got.Headers.Get("Forwarded")
+	assert.Equal(t, `for=192.0.2.7;host="client.example";proto=http`, fwd)
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 201 \r\n"))
+	assert.Contains(t, out, "x-upstream: yes\r\n")
+	assert.NotContains(t, out, "keep-alive")
+	assert.True(t, strings.HasSuffix(out, "\r\n7\r\ncreated\r\n0\r\n\r\n"))
+}
+
+func TestProxyStreamsChunkedBodyWithTrailers(t *testing.T) {
+	upstream := servertest.NewServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetDefaultHeaders(0)
+		h.Remove("content-length")
+		h.Override("transfer-encoding", "chunked")
+		h.Override("trailer", "X-Checksum")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Override("X-Checksum", "abc")
+		w.WriteTrailers(trailers)
+	})
+
+	p := &ReverseProxy{Upstreams: []string{upstream.URL}, DigestTrailers: true}
+	res, err := servertest.Record(p.Handle, newRequest("GET", "/stream", ""))
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello world", string(res.Body))
+	assert.Equal(t, "abc", res.Trailers["x-checksum"])
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("hello world"))), res.Trailers["x-content-sha256"])
+	assert.Equal(t, "11", res.Trailers["x-content-length"])
+}
+
+func TestProxyStreamsUploads(t *testing.T) {
+	upstream := servertest.NewServer(t, func(w *response.Writer, req *request.Request) {
+		var sb strings.Builder
+		io.Copy(&sb, req.BodyReader())
+		framing, _ := req.Headers.Get("Transfer-Encoding")
+		body := framing + ":" + sb.String()
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+	p := &ReverseProxy{Upstreams: []string{upstream.URL}}
+	head := "POST /upload HTTP/1.1\r\nHost: client.example\r\nContent-Type: multipart/form-data; boundary=b\r\n"
+
+	res, err := servertest.Do(p.Handle, head+"Content-Length: 5\r\n\r\nhello")
+	require.NoError(t, err)
+	assert.Equal(t, ":hello", string(res.Body))
+
+	// Test: a chunked upload stays chunked, as its length is not known
+	res, err = servertest.Do(p.Handle, head+"Transfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "chunked:hello", string(res.Body))
+}
+
+func TestProxyUpstreamUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	p := &ReverseProxy{Upstreams: []string{"http://" + addr}}
+	out := proxyRequest(p, newRequest("GET", "/", ""))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"))
+}
+
+func TestProxyUpstreamTimeout(t *testing.T) {
+	// an upstream that accepts but never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	p := &ReverseProxy{
+		Upstreams: []string{"http://" + ln.Addr().String()},
+		Client:    &client.Client{ResponseHeaderTimeout: 50 * time.Millisecond},
+	}
+	out := proxyRequest(p, newRequest("GET", "/", ""))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 504 Gateway Timeout\r\n"))
+}
+
+func TestUpstreamRoundRobin(t *testing.T) {
+	hits := make(chan string, 4)
+	handlerFor := func(name string) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			hits <- name
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+		}
+	}
+	a := servertest.NewServer(t, handlerFor("a"))
+	b := servertest.NewServer(t, handlerFor("b"))
+	p := &ReverseProxy{Upstreams: []string{a.URL, b.URL}}
+	for i := 0; i < 4; i++ {
+		proxyRequest(p, newRequest("GET", "/", ""))
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b"}, []string{<-hits, <-hits, <-hits, <-hits})
+}
+
+func newRequest(method, target, body string) *request.Request {
+	h := headers.NewHeaders()
+	h.Override("host", "client.example")
+	if body != "" {
+		h.Override("content-length", fmt.Sprintf("%d", len(body)))
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     h,
+		Body:        []byte(body),
+		RemoteAddr:  "192.0.2.7:51234",
+	}
+}
+
+func proxyRequest(p *ReverseProxy, req *request.Request) string {
+	buf := &bytes.Buffer{}
+	p.Handle(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+func TestUpstreamTargetStripsWholeSegments(t *testing.T) {
+	upstream, err := url.Parse("http://upstream.example/base")
+	require.NoError(t, err)
+	p := &ReverseProxy{StripPrefix: "/httpbin"}
+	assert.Equal(t, "/base/get", p.upstreamTarget(upstream, "/httpbin/get"))
+	assert.Equal(t, "/base/", p.upstreamTarget(upstream, "/httpbin"))
+	assert.Equal(t, "/base/?a=1", p.upstreamTarget(upstream, "/httpbin?a=1"))
+	assert.Equal(t, "/base/httpbinfoo", p.upstreamTarget(upstream, "/httpbinfoo"))
+
+	p.StripPrefix = "/httpbin/"
+	assert.Equal(t, "/base/get", p.upstreamTarget(upstream, "/httpbin/get"))
+}
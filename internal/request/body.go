@@ -0,0 +1,148 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jonvanw/httpfromtcp/internal"
+)
+
+// BodyReader returns the body as a stream. ReadRequest reads most bodies
+// whole into Body, and then this reads from Body. A multipart/form-data body
+// is left on the connection instead, so uploads need not fit in memory; Body
+// is then empty and the stream, which can be read only once, is the way to
+// it.
+func (r *Request) BodyReader() io.Reader {
+	if r.body != nil {
+		return r.body
+	}
+	return bytes.NewReader(r.Body)
+}
+
+// Streaming reports whether the body was left on the connection for
+// BodyReader rather than read into Body.
+func (r *Request) Streaming() bool {
+	return r.body != nil
+}
+
+// ReadBody reads a streamed body into Body, for code that needs all of it at
+// once, and refuses one over maxSize bytes with ErrBodyTooLarge. It does
+// nothing when the body is already in Body.
+func (r *Request) ReadBody(maxSize int) error {
+	if r.body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.body, int64(maxSize)+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, maxSize)
+	}
+	r.body = nil
+	r.Body = body
+	r.RawBodyLength = len(body)
+	return nil
+}
+
+// streamsBody reports whether ReadRequest should leave the body on the
+// connection.
+func (r *Request) streamsBody() bool {
+	return r.hasFormBody() && r.mediaType() == "multipart/form-data"
+}
+
+// bodyStream reads a body that ReadRequest left unread, undoing its framing
+// as it goes. Trailers are added to the request once it ends.
+type bodyStream struct {
+	req    *Request
+	parser *Parser
+	src    io.Reader
+	buf    []byte
+	// buf[start:end] holds data read but not yet parsed
+	start, end int
+	// pending[off:] holds body bytes parsed but not yet returned
+	pending []byte
+	off     int
+	err     error
+}
+
+// newBodyStream starts reading a body framed as EventHeadersDone said, from
+// the bytes already read past the head and then from src.
+func newBodyStream(req *Request, contentLength int, head []byte, src io.Reader) *bodyStream {
+	b := &bodyStream{
+		req:    req,
+		parser: newBodyParser(contentLength),
+		src:    src,
+		buf:    make([]byte, max(len(head), internal.BUFFSIZE)),
+	}
+	b.end = copy(b.buf, head)
+	if b.end > 0 && !b.parser.Done() {
+		b.feed()
+	}
+	return b
+}
+
+func (b *bodyStream) Read(p []byte) (int, error) {
+	for b.off == len(b.pending) {
+		b.pending, b.off = b.pending[:0], 0
+		if b.err != nil {
+			return 0, b.err
+		}
+		if b.parser.Done() {
+			b.err = io.EOF
+			continue
+		}
+		b.fill()
+	}
+	n := copy(p, b.pending[b.off:])
+	b.off += n
+	return n, nil
+}
+
+// fill reads more of the body from src.
+func (b *bodyStream) fill() {
+	if b.end == len(b.buf) {
+		if b.start > 0 {
+			b.end = copy(b.buf, b.buf[b.start:b.end])
+			b.start = 0
+		} else {
+			grown := make([]byte, 2*len(b.buf))
+			copy(grown, b.buf[:b.end])
+			b.buf = grown
+		}
+	}
+	n, err := b.src.Read(b.buf[b.end:])
+	b.end += n
+	if n > 0 {
+		b.feed()
+	}
+	if b.err != nil || b.parser.Done() {
+		return
+	}
+	if errors.Is(err, io.EOF) {
+		b.err = &ParseError{Offset: b.parser.consumed, Stage: b.parser.state.String(), Err: io.ErrUnexpectedEOF}
+	} else if err != nil {
+		b.err = fmt.Errorf("failed to read from reader: %w", err)
+	}
+}
+
+// feed parses the unparsed bytes in buf.
+func (b *bodyStream) feed() {
+	consumed, events, err := b.parser.Feed(b.buf[b.start:b.end])
+	if err != nil {
+		b.err = err
+		return
+	}
+	for _, ev := range events {
+		switch ev.Type {
+		case EventBody:
+			// ev.Data points into buf, which is reused
+			b.pending = append(b.pending, ev.Data...)
+		case EventTrailer:
+			b.req.apply(ev)
+		}
+	}
+	b.start += consumed
+}
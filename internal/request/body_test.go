@@ -0,0 +1,80 @@
+package request
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const uploadHead = "POST /upload HTTP/1.1\r\nHost: x\r\nContent-Type: multipart/form-data; boundary=b\r\n"
+
+func TestMultipartBodyIsStreamed(t *testing.T) {
+	for name, raw := range map[string]string{
+		"length":  uploadHead + "Content-Length: 10\r\n\r\n0123456789GET / HTTP/1.1\r\n",
+		"chunked": uploadHead + "Transfer-Encoding: chunked\r\n\r\n4\r\n0123\r\n6\r\n456789\r\n0\r\nX-Sum: 45\r\n\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			reader := &chunkReader{data: raw, numBytesPerRead: 3}
+			r, rest, err := ReadRequest(reader)
+			require.NoError(t, err)
+			assert.True(t, r.Streaming())
+			assert.Empty(t, r.Body)
+			assert.Empty(t, rest)
+
+			body, err := io.ReadAll(r.BodyReader())
+			require.NoError(t, err)
+			assert.Equal(t, "0123456789", string(body))
+			if name == "chunked" {
+				assert.Equal(t, "45", r.Trailers["x-sum"])
+			}
+		})
+	}
+}
+
+func TestStreamedBodyIsNotCapped(t *testing.T) {
+	// Test: multipart uploads are bounded by MultipartLimits, not the body cap
+	r, _, err := ReadRequestLimit(strings.NewReader(uploadHead+"Content-Length: 20\r\n\r\n"+strings.Repeat("x", 20)), 10)
+	require.NoError(t, err)
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Len(t, body, 20)
+}
+
+func TestTruncatedStreamedBody(t *testing.T) {
+	r, _, err := ReadRequest(strings.NewReader(uploadHead + "Content-Length: 20\r\n\r\nshort"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestReadBody(t *testing.T) {
+	raw := uploadHead + "Content-Length: 10\r\n\r\n0123456789"
+	r, _, err := ReadRequest(strings.NewReader(raw))
+	require.NoError(t, err)
+	require.NoError(t, r.ReadBody(10))
+	assert.False(t, r.Streaming())
+	assert.Equal(t, "0123456789", string(r.Body))
+	assert.Equal(t, 10, r.RawBodyLength)
+
+	// Test: a body over the limit is refused
+	r, _, err = ReadRequest(strings.NewReader(raw))
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.ReadBody(9), ErrBodyTooLarge)
+}
+
+func TestParseMultipartFormFromStream(t *testing.T) {
+	body := fileBody("first", strings.Repeat("x", 100))
+	reader := &chunkReader{data: uploadHead + "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body, numBytesPerRead: 7}
+	r, _, err := ReadRequest(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.ParseMultipartForm(MultipartLimits{MaxMemory: 50, TempDir: t.TempDir()}))
+	defer r.MultipartForm.RemoveAll()
+	files := r.MultipartForm.File["f"]
+	require.Len(t, files, 2)
+	assert.Equal(t, int64(100), files[1].Size)
+	assert.NotEmpty(t, files[1].tmpfile)
+}
@@ -0,0 +1,96 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedEncoding is returned by DecodeBody for a Content-Encoding
+	// other than gzip, deflate or identity.
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	// ErrBodyTooLarge is returned by ReadRequest for a body over its cap,
+	// and by DecodeBody when the decoded body would be larger than the limit.
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
+// DecodeBody undoes the Content-Encoding of the body, so Body holds what the
+// client meant to send. maxSize caps the decoded size, since a few kilobytes
+// of gzip can expand to gigabytes. On success the Content-Encoding header is
+// removed and Content-Length is set to the decoded length; RawBodyLength
+// still holds the length as received.
+func (r *Request) DecodeBody(maxSize int) error {
+	value, ok := r.Headers.Get("Content-Encoding")
+	if !ok {
+		return nil
+	}
+	codings := strings.Split(value, ",")
+	for i := range codings {
+		codings[i] = strings.ToLower(strings.TrimSpace(codings[i]))
+		switch codings[i] {
+		case "gzip", "x-gzip", "deflate", "identity":
+		default:
+			return fmt.Errorf("%w: %q", ErrUnsupportedEncoding, codings[i])
+		}
+	}
+
+	// a streamed body has to be read in whole to be decoded
+	if err := r.ReadBody(maxSize); err != nil {
+		return err
+	}
+	body := r.Body
+	// codings are listed in the order they were applied
+	for i := len(codings) - 1; i >= 0; i-- {
+		decoded, err := decode(codings[i], body, maxSize)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+
+	r.Body = body
+	r.Headers.Remove("Content-Encoding")
+	if _, ok := r.Headers.Get("Content-Length"); ok {
+		r.Headers.Override("Content-Length", strconv.Itoa(len(body)))
+	}
+	return nil
+}
+
+func decode(coding string, body []byte, maxSize int) ([]byte, error) {
+	if coding == "identity" || len(body) == 0 {
+		return body, nil
+	}
+	var reader io.ReadCloser
+	var err error
+	switch coding {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			// some clients send raw deflate without the zlib wrapper
+			reader, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s body: %w", coding, err)
+	}
+	defer reader.Close()
+
+	// read one byte past the limit to tell a body at the limit from one over it
+	decoded, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s body: %w", coding, err)
+	}
+	if len(decoded) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes once decoded", ErrBodyTooLarge, maxSize)
+	}
+	return decoded, nil
+}
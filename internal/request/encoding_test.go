@@ -0,0 +1,121 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encode(t *testing.T, coding string, data []byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "deflate":
+		w = zlib.NewWriter(buf)
+	case "raw deflate":
+		w, _ = flate.NewWriter(buf, flate.DefaultCompression)
+	}
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func encodedRequest(body []byte, contentEncoding string) *Request {
+	h := headers.NewHeaders()
+	h.Override("content-length", strconv.Itoa(len(body)))
+	h.Override("content-encoding", contentEncoding)
+	return &Request{Headers: h, Body: body, RawBodyLength: len(body)}
+}
+
+func TestDecodeBody(t *testing.T) {
+	text := []byte(strings.Repeat("compress me please ", 50))
+	gzipped := encode(t, "gzip", text)
+	for name, tc := range map[string]struct {
+		body   []byte
+		header string
+	}{
+		"gzip":          {gzipped, "gzip"},
+		"x-gzip":        {gzipped, "X-Gzip"},
+		"deflate":       {encode(t, "deflate", text), "deflate"},
+		"raw deflate":   {encode(t, "raw deflate", text), "deflate"},
+		"stacked":       {encode(t, "deflate", gzipped), "gzip, deflate"},
+		"with identity": {gzipped, "identity, gzip"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := encodedRequest(tc.body, tc.header)
+			require.NoError(t, r.DecodeBody(1<<20))
+			assert.Equal(t, text, r.Body)
+			assert.Equal(t, len(tc.body), r.RawBodyLength)
+			assert.Equal(t, strconv.Itoa(len(text)), r.Headers["content-length"])
+			assert.NotContains(t, r.Headers, "content-encoding")
+		})
+	}
+}
+
+func TestDecodeBodyWithoutEncoding(t *testing.T) {
+	r := &Request{Headers: headers.Headers{"content-length": "3"}, Body: []byte("abc"), RawBodyLength: 3}
+	require.NoError(t, r.DecodeBody(1))
+	assert.Equal(t, "abc", string(r.Body))
+}
+
+func TestDecodeBodyRejectsUnsupportedEncoding(t *testing.T) {
+	r := encodedRequest([]byte("xyz"), "gzip, br")
+	err := r.DecodeBody(1 << 20)
+	require.ErrorIs(t, err, ErrUnsupportedEncoding)
+	// Test: nothing is changed when decoding fails
+	assert.Equal(t, "xyz", string(r.Body))
+	assert.Equal(t, "gzip, br", r.Headers["content-encoding"])
+}
+
+func TestDecodeBodyStopsZipBombs(t *testing.T) {
+	// 10 MB of zeros compresses to about 10 KB
+	bomb := encode(t, "gzip", make([]byte, 10<<20))
+	r := encodedRequest(bomb, "gzip")
+	require.ErrorIs(t, r.DecodeBody(1<<20), ErrBodyTooLarge)
+
+	// Test: exactly at the limit is fine
+	r = encodedRequest(encode(t, "gzip", make([]byte, 1000)), "gzip")
+	require.NoError(t, r.DecodeBody(1000))
+	assert.Len(t, r.Body, 1000)
+}
+
+func TestDecodeBodyRejectsCorruptData(t *testing.T) {
+	gzipped := encode(t, "gzip", []byte(strings.Repeat("x", 100)))
+	for _, body := range [][]byte{[]byte("not gzip"), gzipped[:len(gzipped)-5]} {
+		err := encodedRequest(body, "gzip").DecodeBody(1 << 20)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrUnsupportedEncoding)
+		assert.NotErrorIs(t, err, ErrBodyTooLarge)
+	}
+}
+
+func TestRawBodyLengthIsSetByParser(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, r.RawBodyLength)
+}
+
+func TestDecodeBodyReadsStreamedBody(t *testing.T) {
+	gzipped := encode(t, "gzip", []byte("--b--\r\n"))
+	raw := fmt.Sprintf("POST / HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=b\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(gzipped), gzipped)
+	r, _, err := ReadRequest(strings.NewReader(raw))
+	require.NoError(t, err)
+	require.True(t, r.Streaming())
+	require.NoError(t, r.DecodeBody(1<<20))
+	assert.Equal(t, "--b--\r\n", string(r.Body))
+	assert.Equal(t, len(gzipped), r.RawBodyLength)
+}
@@ -0,0 +1,126 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strings"
+)
+
+// ErrNotMultipart is returned for a body that is not multipart/form-data.
+var ErrNotMultipart = errors.New("request body is not multipart/form-data")
+
+// ParseForm fills Form with the query string and, for POST, PUT and PATCH
+// requests with an application/x-www-form-urlencoded body, the body's
+// fields, which come first and also go in PostForm. It is a no-op once Form
+// is set. On a malformed query or body it returns an error but keeps the
+// fields it could read.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+	var errs []error
+	r.PostForm = url.Values{}
+	if r.hasFormBody() && r.mediaType() == "application/x-www-form-urlencoded" {
+		values, err := url.ParseQuery(string(r.Body))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid form body: %w", err))
+		}
+		r.PostForm = values
+	}
+
+	r.Form = url.Values{}
+	for key, values := range r.PostForm {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	if _, query, ok := strings.Cut(r.RequestLine.RequestTarget, "?"); ok {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid query string: %w", err))
+		}
+		for key, vs := range values {
+			r.Form[key] = append(r.Form[key], vs...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseMultipartForm reads a multipart/form-data body into MultipartForm,
+// within the given limits, and adds its plain fields to Form and PostForm.
+// Files that did not fit in memory are kept in temporary files until
+// MultipartForm.RemoveAll is called. It is a no-op once MultipartForm is
+// set.
+func (r *Request) ParseMultipartForm(limits MultipartLimits) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	form, err := ReadForm(mr, limits)
+	if err != nil {
+		return err
+	}
+	r.MultipartForm = form
+	for key, values := range form.Value {
+		r.PostForm[key] = append(r.PostForm[key], values...)
+		// body fields go before the query's, as ParseForm does
+		r.Form[key] = append(values[:len(values):len(values)], r.Form[key]...)
+	}
+	return nil
+}
+
+// MultipartReader returns a reader over the parts of a multipart/form-data
+// body, for handlers that want to process each part as it is read instead
+// of using ParseMultipartForm. It reads from BodyReader, so parts come off
+// the connection as the handler reads them.
+func (r *Request) MultipartReader() (*multipart.Reader, error) {
+	if !r.hasFormBody() || r.mediaType() != "multipart/form-data" {
+		return nil, ErrNotMultipart
+	}
+	value, _ := r.Headers.Get("Content-Type")
+	_, params, _ := mime.ParseMediaType(value)
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 {
+		return nil, fmt.Errorf("invalid multipart boundary %q", boundary)
+	}
+	return multipart.NewReader(r.BodyReader(), boundary), nil
+}
+
+// FormValue returns the first value for key from the query string or form
+// body, or "" if there is none. It runs ParseForm if needed, ignoring
+// errors. Multipart bodies are only seen once ParseMultipartForm has run,
+// since that may leave temporary files for the caller to remove.
+func (r *Request) FormValue(key string) string {
+	if r.Form == nil {
+		r.ParseForm()
+	}
+	return r.Form.Get(key)
+}
+
+// hasFormBody reports whether the method is one that carries form bodies.
+func (r *Request) hasFormBody() bool {
+	switch r.RequestLine.Method {
+	case "POST", "PUT", "PATCH":
+		return true
+	}
+	return false
+}
+
+func (r *Request) mediaType() string {
+	value, ok := r.Headers.Get("Content-Type")
+	if !ok {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
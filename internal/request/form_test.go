@@ -0,0 +1,86 @@
+package request
+
+import (
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func formRequest(method, target, contentType, body string) *Request {
+	h := headers.NewHeaders()
+	if contentType != "" {
+		h.Override("content-type", contentType)
+	}
+	return &Request{
+		RequestLine: RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     h,
+		Body:        []byte(body),
+	}
+}
+
+func TestParseForm(t *testing.T) {
+	req := formRequest("POST", "/search?q=query&page=2", "application/x-www-form-urlencoded; charset=utf-8", "q=body&name=a+b%21")
+	require.NoError(t, req.ParseForm())
+	assert.Equal(t, url.Values{"q": {"body"}, "name": {"a b!"}}, req.PostForm)
+	assert.Equal(t, []string{"body", "query"}, req.Form["q"])
+	assert.Equal(t, "2", req.Form.Get("page"))
+	assert.Equal(t, "body", req.FormValue("q"))
+}
+
+func TestParseFormIgnoresBodyOfOtherRequests(t *testing.T) {
+	for _, req := range []*Request{
+		formRequest("GET", "/?a=1", "application/x-www-form-urlencoded", "b=2"),
+		formRequest("POST", "/?a=1", "text/plain", "b=2"),
+	} {
+		require.NoError(t, req.ParseForm())
+		assert.Equal(t, url.Values{"a": {"1"}}, req.Form)
+		assert.Empty(t, req.PostForm)
+	}
+}
+
+func TestParseFormKeepsValidFieldsOnError(t *testing.T) {
+	req := formRequest("POST", "/?a=%zz&b=1", "application/x-www-form-urlencoded", "c=3&d=%")
+	err := req.ParseForm()
+	assert.ErrorContains(t, err, "invalid query string")
+	assert.ErrorContains(t, err, "invalid form body")
+	assert.Equal(t, "1", req.Form.Get("b"))
+	assert.Equal(t, "3", req.PostForm.Get("c"))
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	req := formRequest("POST", "/upload?title=query", `multipart/form-data; boundary="xyz"`, multipartBody)
+	require.NoError(t, req.ParseMultipartForm(MultipartLimits{}))
+	defer req.MultipartForm.RemoveAll()
+
+	assert.Equal(t, []string{"Hello", "query"}, req.Form["title"])
+	assert.Equal(t, "Hello", req.PostForm.Get("title"))
+	assert.Equal(t, "Hello", req.FormValue("title"))
+	require.Len(t, req.MultipartForm.File["upload"], 1)
+	fh := req.MultipartForm.File["upload"][0]
+	assert.Equal(t, "notes.txt", fh.Filename)
+	f, err := fh.Open()
+	require.NoError(t, err)
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "line one\r\nline two", string(data))
+}
+
+func TestFormValueDoesNotParseMultipart(t *testing.T) {
+	req := formRequest("POST", "/upload?title=query", `multipart/form-data; boundary="xyz"`, multipartBody)
+	assert.Equal(t, "query", req.FormValue("title"))
+	assert.Nil(t, req.MultipartForm)
+}
+
+func TestMultipartReaderRejectsOtherBodies(t *testing.T) {
+	_, err := formRequest("POST", "/", "application/json", "{}").MultipartReader()
+	assert.ErrorIs(t, err, ErrNotMultipart)
+	_, err = formRequest("GET", "/", "multipart/form-data; boundary=xyz", "").MultipartReader()
+	assert.ErrorIs(t, err, ErrNotMultipart)
+	_, err = formRequest("POST", "/", "multipart/form-data", "").MultipartReader()
+	assert.ErrorContains(t, err, "boundary")
+}
@@ -0,0 +1,237 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"os"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+)
+
+const (
+	// DefaultMaxMemory is how much of a form's files and values are held in
+	// memory, in bytes, when MultipartLimits.MaxMemory is not set.
+	DefaultMaxMemory = 1 << 20
+	// DefaultMaxFileSize is the size limit for a single uploaded file.
+	DefaultMaxFileSize = 32 << 20
+	// DefaultMaxFormSize is the size limit for all parts of a form together.
+	DefaultMaxFormSize = 64 << 20
+	// DefaultMaxParts is the limit on the number of parts in a form.
+	DefaultMaxParts = 1000
+)
+
+var (
+	// ErrFileTooLarge is returned when an uploaded file is over
+	// MultipartLimits.MaxFileSize.
+	ErrFileTooLarge = errors.New("multipart: file too large")
+	// ErrFormTooLarge is returned when a form is over
+	// MultipartLimits.MaxFormSize, or has more plain fields than fit in
+	// MultipartLimits.MaxMemory.
+	ErrFormTooLarge = errors.New("multipart: form too large")
+	// ErrTooManyParts is returned when a form has more than
+	// MultipartLimits.MaxParts parts.
+	ErrTooManyParts = errors.New("multipart: too many parts")
+)
+
+// MultipartLimits bounds what ReadForm accepts. Zero fields take the
+// defaults above.
+type MultipartLimits struct {
+	// MaxMemory is how many bytes of file contents and values are kept in
+	// memory. Files that do not fit are written to temporary files.
+	MaxMemory int64
+	// MaxFileSize caps each uploaded file.
+	MaxFileSize int64
+	// MaxFormSize caps the parts of the form together.
+	MaxFormSize int64
+	// MaxParts caps the number of parts.
+	MaxParts int
+	// TempDir is where files that do not fit in memory go. Empty means
+	// os.TempDir().
+	TempDir string
+}
+
+func (l MultipartLimits) withDefaults() MultipartLimits {
+	if l.MaxMemory <= 0 {
+		l.MaxMemory = DefaultMaxMemory
+	}
+	if l.MaxFileSize <= 0 {
+		l.MaxFileSize = DefaultMaxFileSize
+	}
+	if l.MaxFormSize <= 0 {
+		l.MaxFormSize = DefaultMaxFormSize
+	}
+	if l.MaxParts <= 0 {
+		l.MaxParts = DefaultMaxParts
+	}
+	return l
+}
+
+// MultipartForm is a parsed multipart/form-data body.
+type MultipartForm struct {
+	Value map[string][]string
+	File  map[string][]*FileHeader
+}
+
+// RemoveAll deletes the temporary files of the form.
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, files := range f.File {
+		for _, fh := range files {
+			if fh.tmpfile == "" {
+				continue
+			}
+			if err := os.Remove(fh.tmpfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FileHeader describes an uploaded file.
+type FileHeader struct {
+	// Filename is the name the client gave, which must not be trusted as a
+	// path.
+	Filename string
+	Header   headers.Headers
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+// File is the contents of an uploaded file.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Open returns the contents of the file, from memory or its temporary file.
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+	return nopCloser{bytes.NewReader(fh.content)}, nil
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// ReadForm reads all remaining parts of mr into a MultipartForm. Plain
+// fields and small files are kept in memory; files over the memory limit are
+// written to temporary files. On error the temporary files are removed.
+// Unlike multipart.Reader.ReadForm it caps each file and the number of
+// parts, not just the memory used.
+func ReadForm(mr *multipart.Reader, limits MultipartLimits) (form *MultipartForm, err error) {
+	limits = limits.withDefaults()
+	form = &MultipartForm{Value: map[string][]string{}, File: map[string][]*FileHeader{}}
+	defer func() {
+		if err != nil {
+			form.RemoveAll()
+			form = nil
+		}
+	}()
+
+	memory, total := limits.MaxMemory, int64(0)
+	for parts := 1; ; parts++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			return form, err
+		}
+		if parts > limits.MaxParts {
+			return form, ErrTooManyParts
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		if part.FileName() == "" {
+			var value bytes.Buffer
+			n, err := io.Copy(&value, io.LimitReader(part, memory+1))
+			if err != nil {
+				return form, err
+			}
+			if n > memory {
+				return form, ErrFormTooLarge
+			}
+			memory -= n
+			total += n
+			if total > limits.MaxFormSize {
+				return form, ErrFormTooLarge
+			}
+			form.Value[name] = append(form.Value[name], value.String())
+			continue
+		}
+
+		fh := &FileHeader{Filename: part.FileName(), Header: partHeaders(part)}
+		form.File[name] = append(form.File[name], fh)
+		size, err := readFile(fh, part, memory, limits.MaxFormSize-total, limits)
+		if err != nil {
+			return form, err
+		}
+		if fh.tmpfile == "" {
+			memory -= size
+		}
+		total += size
+	}
+}
+
+// readFile reads an uploaded file into fh, in memory if it fits in memory
+// bytes and into a temporary file if not. remaining is what is left of the
+// form size limit.
+func readFile(fh *FileHeader, part *multipart.Part, memory, remaining int64, limits MultipartLimits) (int64, error) {
+	limit := min(limits.MaxFileSize, remaining)
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(part, min(memory, limit)+1))
+	if err != nil {
+		return 0, err
+	}
+	if n <= memory && n <= limit {
+		fh.content = buf.Bytes()
+		fh.Size = n
+		return n, nil
+	}
+
+	f, err := os.CreateTemp(limits.TempDir, "multipart-")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fh.tmpfile = f.Name()
+	size, err := io.Copy(f, io.MultiReader(&buf, io.LimitReader(part, limit-n+1)))
+	if err != nil {
+		return 0, err
+	}
+	if size > limits.MaxFileSize {
+		return 0, ErrFileTooLarge
+	}
+	if size > remaining {
+		return 0, ErrFormTooLarge
+	}
+	fh.Size = size
+	return size, nil
+}
+
+// partHeaders converts the headers of a part to the package's own type.
+func partHeaders(part *multipart.Part) headers.Headers {
+	h := headers.NewHeaders()
+	for key, values := range part.Header {
+		for _, value := range values {
+			h.Append(key, value)
+		}
+	}
+	return h
+}
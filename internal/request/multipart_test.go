@@ -0,0 +1,141 @@
+package request
+
+import (
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const multipartBody = "preamble to ignore\r\n" +
+	"--xyz\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n" +
+	"\r\n" +
+	"Hello\r\n" +
+	"--xyz  \r\n" +
+	"Content-Disposition: form-data; name=\"upload\"; filename=\"notes.txt\"\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"line one\r\nline two\r\n" +
+	"--xyz--\r\n" +
+	"epilogue to ignore"
+
+func TestMultipartReaderParts(t *testing.T) {
+	for name, r := range map[string]io.Reader{
+		"whole":    strings.NewReader(multipartBody),
+		"one byte": iotest.OneByteReader(strings.NewReader(multipartBody)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			mr := multipart.NewReader(r, "xyz")
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			assert.Equal(t, "title", part.FormName())
+			assert.Equal(t, "", part.FileName())
+			data, err := io.ReadAll(part)
+			require.NoError(t, err)
+			assert.Equal(t, "Hello", string(data))
+
+			part, err = mr.NextPart()
+			require.NoError(t, err)
+			assert.Equal(t, "notes.txt", part.FileName())
+			assert.Equal(t, "text/plain", part.Header.Get("Content-Type"))
+			data, err = io.ReadAll(part)
+			require.NoError(t, err)
+			assert.Equal(t, "line one\r\nline two", string(data))
+
+			_, err = mr.NextPart()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestMultipartReaderSkipsUnreadParts(t *testing.T) {
+	body := "--b\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n" + strings.Repeat("x", 10000) +
+		"\r\n--b\r\nContent-Disposition: form-data; name=\"b\"\r\n\r\nlast\r\n--b--"
+	mr := multipart.NewReader(strings.NewReader(body), "b")
+	_, err := mr.NextPart()
+	require.NoError(t, err)
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "b", part.FormName())
+	data, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "last", string(data))
+}
+
+func TestMultipartReaderMalformed(t *testing.T) {
+	for name, body := range map[string]string{
+		"no boundary":    "just some text",
+		"truncated body": "--b\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nvalue",
+		"bad header":     "--b\r\nno colon here\r\n\r\nvalue\r\n--b--",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadForm(multipart.NewReader(strings.NewReader(body), "b"), MultipartLimits{})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func fileBody(files ...string) string {
+	var sb strings.Builder
+	for i, contents := range files {
+		sb.WriteString("--b\r\nContent-Disposition: form-data; name=\"f\"; filename=\"" + string(rune('a'+i)) + ".bin\"\r\n\r\n")
+		sb.WriteString(contents + "\r\n")
+	}
+	sb.WriteString("--b--\r\n")
+	return sb.String()
+}
+
+func TestReadFormSpillsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	body := fileBody("small", strings.Repeat("x", 100))
+	form, err := ReadForm(multipart.NewReader(strings.NewReader(body), "b"), MultipartLimits{MaxMemory: 50, TempDir: dir})
+	require.NoError(t, err)
+
+	small, large := form.File["f"][0], form.File["f"][1]
+	assert.Empty(t, small.tmpfile)
+	assert.Equal(t, int64(5), small.Size)
+	require.NotEmpty(t, large.tmpfile)
+	assert.Equal(t, int64(100), large.Size)
+	f, err := large.Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, strings.Repeat("x", 100), string(data))
+
+	require.NoError(t, form.RemoveAll())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestReadFormLimits(t *testing.T) {
+	values := strings.Repeat("--b\r\nContent-Disposition: form-data; name=\"v\"\r\n\r\nvalue\r\n", 5) + "--b--"
+	for name, tc := range map[string]struct {
+		body   string
+		limits MultipartLimits
+		err    error
+	}{
+		"file too large":    {fileBody(strings.Repeat("x", 101)), MultipartLimits{MaxFileSize: 100}, ErrFileTooLarge},
+		"spilled too large": {fileBody(strings.Repeat("x", 101)), MultipartLimits{MaxMemory: 10, MaxFileSize: 100}, ErrFileTooLarge},
+		"form too large":    {fileBody(strings.Repeat("x", 60), strings.Repeat("x", 60)), MultipartLimits{MaxMemory: 10, MaxFormSize: 100}, ErrFormTooLarge},
+		"values in memory":  {values, MultipartLimits{MaxMemory: 20}, ErrFormTooLarge},
+		"too many parts":    {values, MultipartLimits{MaxParts: 4}, ErrTooManyParts},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc.limits.TempDir = t.TempDir()
+			form, err := ReadForm(multipart.NewReader(strings.NewReader(tc.body), "b"), tc.limits)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Nil(t, form)
+			entries, err := os.ReadDir(tc.limits.TempDir)
+			require.NoError(t, err)
+			assert.Empty(t, entries, "temporary files are removed on error")
+		})
+	}
+}
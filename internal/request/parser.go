@@ -0,0 +1,344 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jonvanw/httpfromtcp/internal"
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+)
+
+type requestState int
+
+const (
+	requestStateInitialized requestState = iota
+	requestStateParsingHeaders
+	requestStateParsingBody
+	requestStateParsingFixedBody
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingChunkEnd
+	requestStateParsingTrailers
+	requestStateDone
+)
+
+func (s requestState) String() string {
+	switch s {
+	case requestStateInitialized:
+		return "request line"
+	case requestStateParsingHeaders:
+		return "headers"
+	case requestStateParsingBody, requestStateParsingFixedBody,
+		requestStateParsingChunkSize, requestStateParsingChunkData, requestStateParsingChunkEnd:
+		return "body"
+	case requestStateParsingTrailers:
+		return "trailers"
+	default:
+		return "request"
+	}
+}
+
+// EventType says which part of a request an Event carries.
+type EventType int
+
+const (
+	// EventRequestLine carries the parsed RequestLine.
+	EventRequestLine EventType = iota
+	// EventHeader carries one header field as Name and Value.
+	EventHeader
+	// EventHeadersDone marks the end of the headers. ContentLength says how
+	// the body is framed.
+	EventHeadersDone
+	// EventBody carries the next piece of the body, with any chunked
+	// framing removed.
+	EventBody
+	// EventTrailer carries one trailer field of a chunked body.
+	EventTrailer
+	// EventComplete marks the end of the request.
+	EventComplete
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventRequestLine:
+		return "request line"
+	case EventHeader:
+		return "header"
+	case EventHeadersDone:
+		return "headers done"
+	case EventBody:
+		return "body"
+	case EventTrailer:
+		return "trailer"
+	case EventComplete:
+		return "complete"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event is one step of a request as Parser reads it.
+type Event struct {
+	Type EventType
+	// Consumed is how many bytes of the data passed to Feed had been used up
+	// when the event was emitted.
+	Consumed    int
+	RequestLine RequestLine
+	// Name and Value hold a header or trailer field. Name is lowercase.
+	Name  string
+	Value string
+	// Data holds body bytes. It points into the data passed to Feed, so it
+	// is only valid until that memory is reused.
+	Data []byte
+	// ContentLength is the declared body length on EventHeadersDone, or -1
+	// for a chunked body.
+	ContentLength int
+}
+
+// Parser reads requests from data pushed into it with Feed, for callers that
+// run their own read loop. ReadRequest is built on it.
+type Parser struct {
+	state requestState
+	// consumed counts the bytes parsed so far, for error offsets
+	consumed int
+	// scanned is how much of the unparsed data hasLine has searched
+	scanned   int
+	remaining int
+	// the headers that pick the body framing, merged like headers.Headers
+	contentLength       string
+	hasContentLength    bool
+	transferEncoding    string
+	hasTransferEncoding bool
+	events              []Event
+}
+
+func NewParser() *Parser {
+	return &Parser{}
+}
+
+// Feed parses as much of data as it can and returns how many bytes it used,
+// along with the events those bytes produced. Bytes it could not use yet, such
+// as half a header line, must be passed again at the start of the next call
+// with the data that follows them. Feed stops at the end of a request; the
+// returned slice is reused by the next call.
+func (p *Parser) Feed(data []byte) (int, []Event, error) {
+	p.events = p.events[:0]
+	if p.state == requestStateDone {
+		return 0, nil, errors.New("error: attempting to parse request after it is already done")
+	}
+	totalBytes := 0
+	for p.state != requestStateDone {
+		state := p.state
+		n, err := p.parseSingleItem(data[totalBytes:], totalBytes)
+		if err != nil {
+			return totalBytes, p.events, &ParseError{Offset: p.consumed, Stage: p.state.String(), Err: err}
+		}
+		totalBytes += n
+		p.consumed += n
+		// an item that neither consumed data nor moved on needs more input
+		if n == 0 && p.state == state {
+			break
+		}
+	}
+	return totalBytes, p.events, nil
+}
+
+// newBodyParser returns a parser for just a body, framed as
+// EventHeadersDone reported with contentLength.
+func newBodyParser(contentLength int) *Parser {
+	p := &Parser{}
+	switch {
+	case contentLength < 0:
+		p.state = requestStateParsingChunkSize
+	case contentLength == 0:
+		p.state = requestStateDone
+	default:
+		p.remaining = contentLength
+		p.state = requestStateParsingFixedBody
+	}
+	return p
+}
+
+// Done reports whether the parser has read a whole request.
+func (p *Parser) Done() bool {
+	return p.state == requestStateDone
+}
+
+// Reset readies the parser for the next request on the same connection.
+func (p *Parser) Reset() {
+	*p = Parser{events: p.events[:0]}
+}
+
+// emit records an event that ends at offset bytes into the current item.
+func (p *Parser) emit(ev Event, base, offset int) {
+	ev.Consumed = base + offset
+	p.events = append(p.events, ev)
+}
+
+// parseSingleItem parses one element from the start of data. base is where
+// data starts in the slice given to Feed.
+func (p *Parser) parseSingleItem(data []byte, base int) (int, error) {
+	switch p.state {
+	case requestStateInitialized:
+		if !p.hasLine(data) {
+			return 0, nil
+		}
+		n, requestLine, err := parseRequestLine(data)
+		if err != nil {
+			return 0, err
+		}
+		p.emit(Event{Type: EventRequestLine, RequestLine: requestLine}, base, n)
+		p.state = requestStateParsingHeaders
+		return n, nil
+	case requestStateParsingHeaders:
+		if !p.hasLine(data) {
+			return 0, nil
+		}
+		n, key, value, done, err := headers.ParseLine(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			p.state = requestStateParsingBody
+			return n, nil
+		}
+		p.noteFramingHeader(key, value)
+		p.emit(Event{Type: EventHeader, Name: key, Value: value}, base, n)
+		return n, nil
+	case requestStateParsingBody:
+		// decides how the body is framed; consumes nothing itself
+		return 0, p.startBody(base)
+	case requestStateParsingFixedBody, requestStateParsingChunkData:
+		n := min(len(data), p.remaining)
+		if n == 0 {
+			return 0, nil
+		}
+		p.emit(Event{Type: EventBody, Data: data[:n:n]}, base, n)
+		p.remaining -= n
+		if p.remaining > 0 {
+			return n, nil
+		}
+		if p.state == requestStateParsingChunkData {
+			p.state = requestStateParsingChunkEnd
+		} else {
+			p.complete(base, n)
+		}
+		return n, nil
+	case requestStateParsingChunkSize:
+		if !p.hasLine(data) {
+			return 0, nil
+		}
+		lineEndIndex := bytes.Index(data, crlf)
+		size, err := internal.ParseChunkSize(data[:lineEndIndex])
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			p.state = requestStateParsingTrailers
+		} else {
+			p.remaining = size
+			p.state = requestStateParsingChunkData
+		}
+		return lineEndIndex + 2, nil
+	case requestStateParsingChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, crlf) {
+			return 0, fmt.Errorf("missing CRLF after chunk data")
+		}
+		p.state = requestStateParsingChunkSize
+		return 2, nil
+	case requestStateParsingTrailers:
+		if !p.hasLine(data) {
+			return 0, nil
+		}
+		n, key, value, done, err := headers.ParseLine(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			p.complete(base, n)
+			return n, nil
+		}
+		p.emit(Event{Type: EventTrailer, Name: key, Value: value}, base, n)
+		return n, nil
+	case requestStateDone:
+		return 0, fmt.Errorf("error: attempting to parse request after it is already done")
+	default:
+		return 0, fmt.Errorf("Invalid request parser state: %v", p.state)
+	}
+}
+
+// noteFramingHeader keeps the headers that decide how the body is framed.
+func (p *Parser) noteFramingHeader(key, value string) {
+	switch key {
+	case "content-length":
+		p.contentLength = mergeValue(p.contentLength, p.hasContentLength, value)
+		p.hasContentLength = true
+	case "transfer-encoding":
+		p.transferEncoding = mergeValue(p.transferEncoding, p.hasTransferEncoding, value)
+		p.hasTransferEncoding = true
+	}
+}
+
+func mergeValue(existing string, ok bool, value string) string {
+	if ok {
+		return existing + ", " + value
+	}
+	return value
+}
+
+// startBody picks the body framing once the headers are in, following the
+// message length rules of RFC 9112 section 6.3.
+func (p *Parser) startBody(base int) error {
+	if p.hasTransferEncoding {
+		// a message with both is a request smuggling risk, so refuse it
+		if p.hasContentLength {
+			return fmt.Errorf("request has both Transfer-Encoding and Content-Length")
+		}
+		if !strings.EqualFold(p.transferEncoding, "chunked") {
+			return fmt.Errorf("unsupported transfer encoding: %q", p.transferEncoding)
+		}
+		p.emit(Event{Type: EventHeadersDone, ContentLength: -1}, base, 0)
+		p.state = requestStateParsingChunkSize
+		return nil
+	}
+	contentLength := 0
+	if p.hasContentLength {
+		var err error
+		contentLength, err = parseContentLength(p.contentLength)
+		if err != nil {
+			return err
+		}
+	}
+	p.emit(Event{Type: EventHeadersDone, ContentLength: contentLength}, base, 0)
+	if contentLength == 0 {
+		p.complete(base, 0)
+		return nil
+	}
+	p.remaining = contentLength
+	p.state = requestStateParsingFixedBody
+	return nil
+}
+
+func (p *Parser) complete(base, offset int) {
+	p.emit(Event{Type: EventComplete}, base, offset)
+	p.state = requestStateDone
+}
+
+// hasLine reports whether data holds a complete line. It remembers how far
+// it has looked, so a line that trickles in a few bytes per read is scanned
+// once rather than from the start on every read.
+func (p *Parser) hasLine(data []byte) bool {
+	// the CR of a CRLF may have been the last byte seen
+	from := max(p.scanned-1, 0)
+	if bytes.Index(data[from:], crlf) == -1 {
+		p.scanned = len(data)
+		return false
+	}
+	p.scanned = 0
+	return true
+}
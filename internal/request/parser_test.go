@@ -0,0 +1,149 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// feedAll pushes data through p in chunks of chunkSize, keeping unconsumed
+// bytes for the next call like an event loop would, and returns the events
+// with body data copied out.
+func feedAll(t *testing.T, p *Parser, data string, chunkSize int) ([]Event, string) {
+	var events []Event
+	pending := []byte{}
+	pos := 0
+	for !p.Done() {
+		require.Less(t, pos, len(data), "ran out of input")
+		end := min(pos+chunkSize, len(data))
+		pending = append(pending, data[pos:end]...)
+		pos = end
+		n, evs, err := p.Feed(pending)
+		require.NoError(t, err)
+		for _, ev := range evs {
+			require.LessOrEqual(t, ev.Consumed, n)
+			ev.Data = append([]byte(nil), ev.Data...)
+			events = append(events, ev)
+		}
+		pending = pending[n:]
+	}
+	return events, string(pending) + data[pos:]
+}
+
+func TestParserEvents(t *testing.T) {
+	data := "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n" +
+		"7;note=x\r\n, world\r\n" +
+		"0\r\n" +
+		"X-Checksum: abc\r\n" +
+		"\r\n" +
+		"GET /next HTTP/1.1\r\n"
+	for chunkSize := 1; chunkSize <= len(data); chunkSize++ {
+		events, rest := feedAll(t, NewParser(), data, chunkSize)
+		assert.Equal(t, "GET /next HTTP/1.1\r\n", rest)
+
+		var types []EventType
+		body := ""
+		for _, ev := range events {
+			if ev.Type == EventBody {
+				body += string(ev.Data)
+				continue
+			}
+			types = append(types, ev.Type)
+		}
+		assert.Equal(t, []EventType{
+			EventRequestLine, EventHeader, EventHeader, EventHeadersDone, EventTrailer, EventComplete,
+		}, types, "chunk size %d", chunkSize)
+		assert.Equal(t, "hello, world", body)
+
+		assert.Equal(t, RequestLine{Method: "POST", RequestTarget: "/upload", HttpVersion: "1.1"}, events[0].RequestLine)
+		assert.Equal(t, "transfer-encoding", events[2].Name)
+		assert.Equal(t, "chunked", events[2].Value)
+		assert.Equal(t, -1, events[3].ContentLength)
+		trailer := events[len(events)-2]
+		assert.Equal(t, "x-checksum", trailer.Name)
+		assert.Equal(t, "abc", trailer.Value)
+	}
+}
+
+func TestParserConsumedPositions(t *testing.T) {
+	// Test: Consumed marks where each event ends in the data given to Feed
+	data := "PUT /x HTTP/1.1\r\nContent-Length: 3\r\n\r\nabcextra"
+	p := NewParser()
+	n, events, err := p.Feed([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, len(data)-len("extra"), n)
+	require.Len(t, events, 5)
+	assert.Equal(t, len("PUT /x HTTP/1.1\r\n"), events[0].Consumed)
+	assert.Equal(t, len("PUT /x HTTP/1.1\r\nContent-Length: 3\r\n"), events[1].Consumed)
+	assert.Equal(t, EventHeadersDone, events[2].Type)
+	assert.Equal(t, 3, events[2].ContentLength)
+	assert.Equal(t, n-3, events[2].Consumed)
+	assert.Equal(t, "abc", string(events[3].Data))
+	assert.Equal(t, n, events[3].Consumed)
+	assert.Equal(t, EventComplete, events[4].Type)
+}
+
+func TestParserResetForPipelinedRequests(t *testing.T) {
+	data := []byte("GET /one HTTP/1.1\r\nHost: x\r\n\r\nGET /two HTTP/1.1\r\nHost: x\r\n\r\n")
+	p := NewParser()
+	var targets []string
+	for len(data) > 0 {
+		n, events, err := p.Feed(data)
+		require.NoError(t, err)
+		require.True(t, p.Done())
+		targets = append(targets, events[0].RequestLine.RequestTarget)
+		data = data[n:]
+		p.Reset()
+	}
+	assert.Equal(t, []string{"/one", "/two"}, targets)
+
+	_, _, err := p.Feed([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	_, _, err = p.Feed([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.Error(t, err, "feeding a finished parser without Reset")
+}
+
+func TestParserRejectsAmbiguousFraming(t *testing.T) {
+	for _, head := range []string{
+		"Transfer-Encoding: chunked\r\nContent-Length: 5\r\n",
+		"Transfer-Encoding: gzip, chunked\r\n",
+		"Transfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n",
+	} {
+		_, _, err := NewParser().Feed([]byte("POST / HTTP/1.1\r\n" + head + "\r\n"))
+		var perr *ParseError
+		require.ErrorAs(t, err, &perr, head)
+		assert.Equal(t, "body", perr.Stage)
+	}
+}
+
+func TestParserRejectsBadChunks(t *testing.T) {
+	for _, body := range []string{
+		"zz\r\n",
+		"-1\r\n",
+		"\r\n",
+		"3\r\nabcX\r\n",
+		"1000000000000000\r\n",
+	} {
+		_, _, err := NewParser().Feed([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" + body))
+		require.Error(t, err, "%q", body)
+	}
+}
+
+func TestReadRequestDecodesChunkedBody(t *testing.T) {
+	data := "POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"6\r\nhello \r\n5\r\nworld\r\n0\r\nX-Parts: 2\r\n\r\n"
+	for chunkSize := 1; chunkSize <= len(data); chunkSize++ {
+		r, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: chunkSize})
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(r.Body))
+		assert.Equal(t, "2", r.Trailers["x-parts"])
+	}
+}
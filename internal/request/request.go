@@ -1,30 +1,77 @@
 package request
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"strconv"
-	"strings"
+	"sync"
 
 	"github.com/jonvanw/httpfromtcp/internal"
 	"github.com/jonvanw/httpfromtcp/internal/headers"
 )
 
-type requestState int
-
-const (
-	requestStateInitialized requestState = iota
-	requestStateParsingHeaders
-	requestStateParsingBody
-	requestStateDone
-)
-
 type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body 	    []byte
-	state       requestState
+	// RemoteAddr is the client's address, set by the server.
+	RemoteAddr  string
+	// Trailers holds the trailer fields of a chunked body, if it had any.
+	Trailers    headers.Headers
+	// RawBodyLength is the length of the body as received. It differs from
+	// len(Body) once DecodeBody has removed a Content-Encoding.
+	RawBodyLength int
+	// Form holds the query string and form body fields once ParseForm or
+	// ParseMultipartForm has run. PostForm holds only the body fields.
+	Form     url.Values
+	PostForm url.Values
+	// MultipartForm is the parsed multipart/form-data body, set by
+	// ParseMultipartForm.
+	MultipartForm *MultipartForm
+
+	// values holds what middleware attached with SetValue.
+	values map[any]any
+	// body streams a body ReadRequest left unread; see BodyReader
+	body *bodyStream
+}
+
+// DefaultMaxBodySize caps the body ReadRequest reads. The whole body is held
+// in memory, so without a cap a client could make the server buffer as much
+// as it cares to send.
+const DefaultMaxBodySize = 10 << 20
+
+// maxBodyPrealloc caps how much of a declared Content-Length is allocated
+// before the body arrives.
+const maxBodyPrealloc = 64 * 1024
+
+var crlf = []byte(internal.CRLF)
+
+// commonMethods interns method names so parsing them does not allocate.
+var commonMethods = map[string]string{
+	"GET": "GET", "HEAD": "HEAD", "POST": "POST", "PUT": "PUT",
+	"DELETE": "DELETE", "CONNECT": "CONNECT", "OPTIONS": "OPTIONS",
+	"TRACE": "TRACE", "PATCH": "PATCH",
+}
+
+// ParseError is returned when a request is malformed. Offset is the number
+// of bytes into the request at which the element that failed to parse
+// starts.
+type ParseError struct {
+	Offset int
+	Stage  string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at byte %d: %v", e.Stage, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
 }
 
 type RequestLine struct {
@@ -34,32 +81,16 @@ type RequestLine struct {
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	request := &Request{}
-	data := []byte{}
-	buf := make([]byte, internal.BUFFSIZE)
-	for request.state != requestStateDone {
-		bytes, err := reader.Read(buf)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				// if we reach EOF before the request is fully parsed, that's an error
-				if request.state != requestStateDone {
-					return nil, fmt.Errorf("reader ended before request was fully parsed")
-				}
-				break
-			}	
-			return nil, fmt.Errorf("failed to read from reader: %w", err)
-		}
-		data = append(data, buf[:bytes]...)
-		bytes, err = request.parse(data)
-		if err != nil {
-			return nil, err
-		}
-		data = data[bytes:]
+	request, data, err := ReadRequest(reader)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check if the reader still has unread data (body longer than Content-Length)
-	// Note the body is ignored if Content-Length is not provided, so we only check for extra data if Content-Length is present
-	if _, ok := request.Headers.Get("Content-Length"); ok {
+	// Note the body is ignored if Content-Length is not provided, so we only check for extra data if the body is framed
+	_, hasLength := request.Headers.Get("Content-Length")
+	_, isChunked := request.Headers.Get("Transfer-Encoding")
+	if (hasLength || isChunked) && !request.Streaming() {
 		if len(data) > 0 {
 			return nil, fmt.Errorf("body is longer than reported content length")
 		}
@@ -76,111 +107,226 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 	return request, nil
 }
 
-func (r *Request) parse(data []byte) (int, error) {
-	totalBytes := 0
-	for r.state != requestStateDone{
-		n, err := r.parseSingleItem(data[totalBytes:])
-		if err != nil {
-			return 0, err
-		}
-		totalBytes += n
-		if n == 0 {
-			break
-		}
-	}
-	return totalBytes, nil
+// readerPool holds the read buffer and parser ReadRequest uses, so both are
+// reused from one request to the next. Buffers that grew past
+// maxPooledBuffer to fit an unusually large request head are dropped rather
+// than kept around.
+var readerPool = sync.Pool{
+	New: func() any {
+		return &pooledReader{buf: make([]byte, internal.BUFFSIZE)}
+	},
 }
 
-func (r *Request) parseSingleItem(data []byte) (int, error) {
-	switch r.state {
-	case requestStateInitialized:
-		var requestLine RequestLine
-		var err error
-		bytes := 0
-		bytes, requestLine, err = parseRequestLine(data)
-		if err != nil {
-			return 0, err
-		}
-		if bytes > 0 {
-			r.RequestLine = requestLine
-			r.state = requestStateParsingHeaders 
-		}	
-		return bytes, nil
-	case requestStateParsingHeaders:
-		if r.Headers == nil {
-			r.Headers = make(headers.Headers)
+type pooledReader struct {
+	buf    []byte
+	parser Parser
+}
+
+const maxPooledBuffer = 64 * 1024
+
+// ReadRequest parses a single request from a reader that may carry more data
+// after it, such as a client connection. Unlike RequestFromReader it does not
+// expect the reader to end with the request; any bytes read past the end of
+// the request are returned alongside it. Bodies over DefaultMaxBodySize are
+// refused with ErrBodyTooLarge. A multipart/form-data body is not read at
+// all: it is left for BodyReader to stream from reader, and no bytes are
+// returned, since the stream owns what follows the head.
+func ReadRequest(reader io.Reader) (*Request, []byte, error) {
+	return ReadRequestLimit(reader, DefaultMaxBodySize)
+}
+
+// ReadRequestLimit is ReadRequest with a body cap of maxBody bytes. A
+// Content-Length over the cap fails before any of the body is read, and a
+// chunked body fails as soon as it grows past it.
+func ReadRequestLimit(reader io.Reader, maxBody int) (*Request, []byte, error) {
+	pooled := readerPool.Get().(*pooledReader)
+	buf := pooled.buf
+	parser := &pooled.parser
+	parser.Reset()
+	defer func() {
+		if cap(buf) <= maxPooledBuffer {
+			pooled.buf = buf[:cap(buf)]
+			readerPool.Put(pooled)
 		}
-		bytes, isDone, err := r.Headers.Parse(data)
-		if err != nil {
-			return 0, err
+	}()
+
+	request := &Request{}
+	// buf[start:end] holds data read but not yet parsed
+	start, end := 0, 0
+	for !parser.Done() {
+		if end == len(buf) {
+			if start > 0 {
+				end = copy(buf, buf[start:end])
+				start = 0
+			} else {
+				grown := make([]byte, 2*len(buf))
+				copy(grown, buf[:end])
+				buf = grown
+			}
 		}
-		if isDone {
-			r.state = requestStateParsingBody
+		n, err := reader.Read(buf[end:])
+		end += n
+		if n > 0 {
+			consumed, events, err := parser.Feed(buf[start:end])
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, ev := range events {
+				if ev.Type == EventHeadersDone && request.streamsBody() {
+					// the parser has run on into the body; the stream
+					// starts over from the end of the head
+					head := start + ev.Consumed
+					request.body = newBodyStream(request, ev.ContentLength, buf[head:end], reader)
+					return request, nil, nil
+				}
+				if ev.Type == EventHeadersDone && ev.ContentLength > maxBody {
+					return nil, nil, fmt.Errorf("%w: Content-Length %d is over the %d byte limit", ErrBodyTooLarge, ev.ContentLength, maxBody)
+				}
+				request.apply(ev)
+				if len(request.Body) > maxBody {
+					return nil, nil, fmt.Errorf("%w: chunked body is over the %d byte limit", ErrBodyTooLarge, maxBody)
+				}
+			}
+			start += consumed
 		}
-		return bytes, nil
-	case requestStateParsingBody:
-		contentLengthStr, ok := r.Headers.Get("Content-Length")
-		if !ok {
-			r.state = requestStateDone
-			return 0, nil
+		if parser.Done() {
+			break
 		}
-		contentLength, err := strconv.Atoi(contentLengthStr)
 		if err != nil {
-			return 0, fmt.Errorf("invalid Content-Length header: %w", err)
-		}
-		if contentLength == 0 {
-			r.state = requestStateDone
-			return 0, nil
+			if errors.Is(err, io.EOF) {
+				// if we reach EOF before the request is fully parsed, that's an error
+				return nil, nil, &ParseError{
+					Offset: parser.consumed,
+					Stage:  parser.state.String(),
+					Err:    errors.New("reader ended before request was fully parsed"),
+				}
+			}
+			return nil, nil, fmt.Errorf("failed to read from reader: %w", err)
 		}
-		if len(data) < contentLength {
-			return 0, nil
+	}
+	request.RawBodyLength = len(request.Body)
+	// buf goes back to the pool, so the caller gets its own copy
+	return request, bytes.Clone(buf[start:end]), nil
+}
+
+// apply adds what an event carries to the request.
+func (r *Request) apply(ev Event) {
+	switch ev.Type {
+	case EventRequestLine:
+		r.RequestLine = ev.RequestLine
+		r.Headers = headers.NewHeaders()
+	case EventHeader:
+		r.Headers.Append(ev.Name, ev.Value)
+	case EventHeadersDone:
+		if ev.ContentLength > 0 {
+			// a bogus Content-Length should not make us allocate it up front
+			r.Body = make([]byte, 0, min(ev.ContentLength, maxBodyPrealloc))
 		}
-		if len(data) > contentLength {
-			return 0, fmt.Errorf("body is longer than reported content length")
+	case EventBody:
+		// ev.Data points into the read buffer, which is reused
+		r.Body = append(r.Body, ev.Data...)
+	case EventTrailer:
+		if r.Trailers == nil {
+			r.Trailers = headers.NewHeaders()
 		}
-		r.Body = data[:contentLength]
-		r.state = requestStateDone
-		return contentLength, nil
-	case requestStateDone:
-		return 0, fmt.Errorf("error: attempting to parser request after it is already done")
-	default:
-		return 0, fmt.Errorf("Invalid request parser state: %v", r.state)
+		r.Trailers.Append(ev.Name, ev.Value)
 	}
 }
 
 func parseRequestLine(data []byte) (int, RequestLine, error) {
-	text := string(data)
-	lineEndIndex := strings.Index(text, internal.CRLF)
+	lineEndIndex := bytes.Index(data, crlf)
 	if lineEndIndex == -1 {
 		return 0, RequestLine{}, nil
 	}
-	line := text[:lineEndIndex]
-	parts := strings.Split(line, " ")
-	if len(parts) != 3 {
-		return 0,RequestLine{}, fmt.Errorf("invalid request line: expected 3 parts, got %d", len(parts))
+	line := data[:lineEndIndex]
+	if n := bytes.Count(line, []byte(" ")) + 1; n != 3 {
+		return 0, RequestLine{}, fmt.Errorf("invalid request line: expected 3 parts, got %d", n)
 	}
-	method := parts[0]
-	if !isAllCaps(method) {
-		return 0, RequestLine{}, fmt.Errorf("invalid method: expected all uppercase, got %s", method)
+	rawMethod, rest, _ := bytes.Cut(line, []byte(" "))
+	rawTarget, rawVersion, _ := bytes.Cut(rest, []byte(" "))
+
+	if len(rawMethod) == 0 || !isAllCaps(rawMethod) {
+		return 0, RequestLine{}, fmt.Errorf("invalid method: expected all uppercase, got %q", rawMethod)
+	}
+	method, ok := commonMethods[string(rawMethod)]
+	if !ok {
+		method = string(rawMethod)
 	}
 
-	target := parts[1]
+	if !isValidTarget(rawTarget) {
+		return 0, RequestLine{}, fmt.Errorf("invalid request target: %q", rawTarget)
+	}
+	target := string(rawTarget)
+	// CONNECT is the only method that uses the authority-form (host:port)
+	if method == "CONNECT" {
+		if err := validateAuthority(target); err != nil {
+			return 0, RequestLine{}, err
+		}
+	}
 
-	version := strings.TrimPrefix(parts[2], "HTTP/")
-	if version != "1.1" {
-		return 0,RequestLine{}, fmt.Errorf("unsupported HTTP version: %s; only HTTP/1.1 is supported", version)
+	version, ok := bytes.CutPrefix(rawVersion, []byte("HTTP/"))
+	if !ok || string(version) != "1.1" {
+		return 0, RequestLine{}, fmt.Errorf("unsupported HTTP version: %s; only HTTP/1.1 is supported", version)
 	}
 
 	return lineEndIndex + 2, RequestLine{
 		Method:        method,
 		RequestTarget: target,
-		HttpVersion:   version,
+		HttpVersion:   "1.1",
 	}, nil
 }
 
-func isAllCaps(s string) bool {
+// validateAuthority checks a CONNECT target is a host and numeric port.
+func validateAuthority(target string) error {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return fmt.Errorf("invalid CONNECT target %q: %w", target, err)
+	}
+	if host == "" {
+		return fmt.Errorf("invalid CONNECT target %q: missing host", target)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid CONNECT target %q: bad port", target)
+	}
+	return nil
+}
+
+// isValidTarget checks the target is non-empty and free of control
+// characters, which have no place in any form of request target.
+func isValidTarget(target []byte) bool {
+	if len(target) == 0 {
+		return false
+	}
+	for i := 0; i < len(target); i++ {
+		if target[i] < '!' || target[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// parseContentLength accepts only a plain decimal number; strconv.Atoi on its
+// own would also take signs like "-1" and "+5".
+func parseContentLength(s string) (int, error) {
+	if s == "" {
+		return 0, fmt.Errorf("invalid Content-Length header: empty")
+	}
 	for _, r := range s {
-		if r < 'A' || r > 'Z' {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid Content-Length header: %q", s)
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Length header: %w", err)
+	}
+	return n, nil
+}
+
+func isAllCaps(s []byte) bool {
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
 			return false
 		}
 	}
@@ -0,0 +1,61 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var smallRequest = "GET /coffee HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"User-Agent: curl/7.81.0\r\n" +
+	"Accept: */*\r\n" +
+	"\r\n"
+
+// largeRequest has a browser-sized header set, a long cookie and a body.
+var largeRequest = func() string {
+	b := &strings.Builder{}
+	b.WriteString("POST /api/v1/items?sort=desc&page=2 HTTP/1.1\r\n")
+	b.WriteString("Host: localhost:42069\r\n")
+	b.WriteString("User-Agent: Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36\r\n")
+	b.WriteString("Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n")
+	b.WriteString("Accept-Language: en-US,en;q=0.5\r\n")
+	b.WriteString("Accept-Encoding: gzip, deflate, br\r\n")
+	b.WriteString("Content-Type: application/json\r\n")
+	b.WriteString("Cookie: " + strings.Repeat("session=abcdef0123456789; ", 40) + "theme=dark\r\n")
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(b, "X-Custom-Header-%d: value-%d-%s\r\n", i, i, strings.Repeat("v", 20))
+	}
+	body := strings.Repeat(`{"name":"item","count":1}`, 40)
+	fmt.Fprintf(b, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return b.String()
+}()
+
+func benchmarkReadRequest(b *testing.B, data string, chunkSize int) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		reader := &chunkReader{data: data, numBytesPerRead: chunkSize}
+		_, _, err := ReadRequest(reader)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadRequestSmall(b *testing.B) {
+	benchmarkReadRequest(b, smallRequest, len(smallRequest))
+}
+
+func BenchmarkReadRequestLarge(b *testing.B) {
+	benchmarkReadRequest(b, largeRequest, len(largeRequest))
+}
+
+// the trickle benchmarks feed a few bytes per read, like a slow client
+func BenchmarkReadRequestSmallTrickle(b *testing.B) {
+	benchmarkReadRequest(b, smallRequest, 8)
+}
+
+func BenchmarkReadRequestLargeTrickle(b *testing.B) {
+	benchmarkReadRequest(b, largeRequest, 8)
+}
@@ -0,0 +1,166 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var fuzzSeeds = []string{
+	"GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 13\r\n\r\nhello world!\n",
+	"GET /coffee HTTP/1.1\r\nHost: x\r\nAccept: a\r\nAccept: b\r\n\r\n",
+	"GET /coffee HTTP/1.1\r\nHost: x\r\nCookie: a=1\r\nCookie: b=2\r\n\r\n",
+	"POST /upload HTTP/1.1\r\nHost: x\r\nContent-Type: multipart/form-data; boundary=b\r\nContent-Length: 9\r\n\r\n--b--\r\nGET /",
+	"CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+	"PUT /x HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nabcGET / HTTP/1.1\r\n",
+	"POST /chunked HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+	"POST /chunked HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\na;ext=1\r\n0123456789\r\n0\r\nX-Sum: 45\r\n\r\nGET /",
+	"GET / HTTP/1.1\r\nHost: x\r\nContent-Length: -1\r\n\r\n",
+	"/coffee HTTP/1.1\r\nHost: x\r\n\r\n",
+	"GET / HTTP/1.1\r\nfoo\r\n\r\n",
+	"get / HTTP/1.1\r\n\r\n",
+}
+
+func FuzzReadRequest(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		for _, chunkSize := range []uint16{1, 3, 1024} {
+			f.Add([]byte(seed), chunkSize)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, chunkSize uint16) {
+		whole, wholeRest, wholeErr := ReadRequest(bytes.NewReader(data))
+
+		// the result must not depend on how the input arrives
+		reader := &chunkReader{data: string(data), numBytesPerRead: int(chunkSize%64) + 1}
+		chunked, rest, err := ReadRequest(reader)
+		if (err == nil) != (wholeErr == nil) {
+			t.Fatalf("read in one go: %v, in chunks of %d: %v", wholeErr, reader.numBytesPerRead, err)
+		}
+		if err != nil {
+			return
+		}
+		if whole.Streaming() {
+			// the stream owns what follows the head, so there is no rest
+			// to compare; read the bodies so they can be
+			if (whole.ReadBody(len(data)) == nil) != (chunked.ReadBody(len(data)) == nil) {
+				t.Fatalf("streamed body read in one go and in chunks of %d differ", reader.numBytesPerRead)
+			}
+			if !sameRequest(whole, chunked) {
+				t.Fatalf("read in one go: %+v, in chunks of %d: %+v", whole, reader.numBytesPerRead, chunked)
+			}
+			if whole.Streaming() {
+				// the body was malformed, which the head alone did not show
+				return
+			}
+			compareWithNetHTTP(t, data, whole)
+			return
+		}
+		if !sameRequest(whole, chunked) {
+			t.Fatalf("read in one go: %+v, in chunks of %d: %+v", whole, reader.numBytesPerRead, chunked)
+		}
+		// unread input plus rest must give back what followed the request
+		remaining, _ := io.ReadAll(reader)
+		if string(rest)+string(remaining) != string(wholeRest) {
+			t.Fatalf("rest differs: %q+%q vs %q", rest, remaining, wholeRest)
+		}
+
+		compareWithNetHTTP(t, data, whole)
+	})
+}
+
+// netHTTPStricter lists errors from net/http for what this parser leaves to
+// the handler: the syntax of the target URI, and repeated Host fields, which
+// are joined like any other.
+var netHTTPStricter = []string{
+	`parse "`,
+	"too many Host headers",
+}
+
+// compareWithNetHTTP checks net/http reads a request we accepted the same
+// way.
+func compareWithNetHTTP(t *testing.T, data []byte, got *Request) {
+	want, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		for _, known := range netHTTPStricter {
+			if strings.Contains(err.Error(), known) {
+				return
+			}
+		}
+		t.Fatalf("accepted %q, net/http rejected it: %v", data, err)
+	}
+	if want.Method != got.RequestLine.Method {
+		t.Fatalf("method %q, net/http %q", got.RequestLine.Method, want.Method)
+	}
+	if want.RequestURI != got.RequestLine.RequestTarget {
+		t.Fatalf("target %q, net/http %q", got.RequestLine.RequestTarget, want.RequestURI)
+	}
+	for key, values := range want.Header {
+		value, ok := got.Headers.Get(key)
+		if !ok || value != joinValues(key, values) {
+			t.Fatalf("header %q: %q, net/http %q", key, value, values)
+		}
+	}
+	// net/http prefers the host in an absolute or authority-form target
+	if host, _ := got.Headers.Get("Host"); strings.HasPrefix(want.RequestURI, "/") && host != want.Host {
+		t.Fatalf("host %q, net/http %q", host, want.Host)
+	}
+	body, err := io.ReadAll(want.Body)
+	if err != nil {
+		t.Fatalf("accepted body of %q, net/http rejected it: %v", data, err)
+	}
+	if !bytes.Equal(body, got.Body) {
+		t.Fatalf("body %q, net/http %q", got.Body, body)
+	}
+	for key, values := range want.Trailer {
+		// net/http lists names announced in a Trailer header even when unsent
+		if len(values) == 0 {
+			continue
+		}
+		value, ok := got.Trailers.Get(key)
+		if !ok || value != joinValues(key, values) {
+			t.Fatalf("trailer %q: %q, net/http %q", key, value, values)
+		}
+	}
+}
+
+// joinValues combines repeated field values the way headers.Append does.
+func joinValues(key string, values []string) string {
+	switch strings.ToLower(key) {
+	case "set-cookie":
+		return strings.Join(values, "\n")
+	case "cookie":
+		return strings.Join(values, "; ")
+	default:
+		return strings.Join(values, ", ")
+	}
+}
+
+func FuzzParseRequestLine(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		n, line, err := parseRequestLine(data)
+		if err != nil || n == 0 {
+			return
+		}
+		// an accepted line is exactly its three parts and nothing else
+		rebuilt := line.Method + " " + line.RequestTarget + " HTTP/" + line.HttpVersion + "\r\n"
+		if string(data[:n]) != rebuilt {
+			t.Fatalf("consumed %q, parsed as %q", data[:n], rebuilt)
+		}
+	})
+}
+
+func sameRequest(a, b *Request) bool {
+	return a.RequestLine == b.RequestLine &&
+		reflect.DeepEqual(a.Headers, b.Headers) &&
+		bytes.Equal(a.Body, b.Body)
+}
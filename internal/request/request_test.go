@@ -108,6 +108,21 @@ func TestBadRequestTooManyPartsInRequestLine(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestGoodConnectRequestLine(t *testing.T) {
+	// Test: CONNECT uses the authority-form target
+	r, err := RequestFromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "CONNECT", r.RequestLine.Method)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+}
+
+func TestBadConnectRequestLine(t *testing.T) {
+	for _, target := range []string{"/", "example.com", ":443", "example.com:http", "example.com:70000"} {
+		_, err := RequestFromReader(strings.NewReader("CONNECT " + target + " HTTP/1.1\r\n\r\n"))
+		require.Error(t, err, target)
+	}
+}
+
 func TestRequestWithHeaders(t *testing.T) {
 	// Test: Standard Headers
 	reader := &chunkReader{
@@ -285,6 +300,117 @@ func TestGoodChunkedBody(t *testing.T) {
 	}
 }
 
+func TestReadRequestReturnsBytesPastRequest(t *testing.T) {
+	// Test: data following the request is handed back instead of rejected
+	data := "POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"helloGET /next HTTP/1.1\r\n"
+	for chunkSize := 1; chunkSize <= len(data); chunkSize++ {
+		reader := &chunkReader{
+			data:            data,
+			numBytesPerRead: chunkSize,
+		}
+		r, rest, err := ReadRequest(reader)
+		require.NoError(t, err)
+		require.NotNil(t, r)
+		assert.Equal(t, "hello", string(r.Body))
+		// whatever was not consumed by the parser must still be in the reader
+		remaining, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "GET /next HTTP/1.1\r\n", string(rest)+string(remaining))
+	}
+}
+
+func TestReadRequestLimitRefusesLargeBodies(t *testing.T) {
+	// Test: a declared length over the cap fails before the body arrives
+	_, _, err := ReadRequestLimit(strings.NewReader("POST /upload HTTP/1.1\r\nHost: x\r\nContent-Length: 11\r\n\r\n"), 10)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	r, _, err := ReadRequestLimit(strings.NewReader("POST /upload HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\n0123456789"), 10)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+
+	// Test: a chunked body fails once it grows past the cap
+	chunked := "POST /upload HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"6\r\nabcdef\r\n6\r\nghijkl\r\n0\r\n\r\n"
+	_, _, err = ReadRequestLimit(strings.NewReader(chunked), 10)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	r, _, err = ReadRequestLimit(strings.NewReader(chunked), 12)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdefghijkl", string(r.Body))
+}
+
+func TestParseErrorReportsOffset(t *testing.T) {
+	// Test: the offset points at the start of the bad header line, whatever the read size
+	data := "GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Bad Header: x\r\n" +
+		"\r\n"
+	for chunkSize := 1; chunkSize <= len(data); chunkSize++ {
+		reader := &chunkReader{
+			data:            data,
+			numBytesPerRead: chunkSize,
+		}
+		_, err := RequestFromReader(reader)
+		var perr *ParseError
+		require.ErrorAs(t, err, &perr)
+		assert.Equal(t, 39, perr.Offset)
+		assert.Equal(t, "headers", perr.Stage)
+	}
+
+	// Test: a truncated request reports where the input ran out
+	_, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, 25, perr.Offset)
+}
+
+func TestMalformedRequestLines(t *testing.T) {
+	for _, line := range []string{
+		" / HTTP/1.1",
+		"GET  HTTP/1.1",
+		"GET / 1.1",
+		"GET /\x01 HTTP/1.1",
+	} {
+		_, err := RequestFromReader(strings.NewReader(line + "\r\nHost: x\r\n\r\n"))
+		require.Error(t, err, line)
+	}
+}
+
+func TestInvalidContentLength(t *testing.T) {
+	for _, cl := range []string{"-1", "+5", "5 5", ""} {
+		// Test: anything but digits is rejected, and never panics
+		_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: " + cl + "\r\n\r\nhello"))
+		require.Error(t, err, cl)
+	}
+}
+
+func TestReadRequestDoesNotShareBuffers(t *testing.T) {
+	// Test: nothing returned points into a read buffer another request reuses
+	first, rest, err := ReadRequest(strings.NewReader("PUT /a HTTP/1.1\r\nContent-Length: 3\r\n\r\nabcGET /next"))
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, _, err := ReadRequest(strings.NewReader("PUT /b HTTP/1.1\r\nContent-Length: 3\r\n\r\nxyzPOST /other"))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "abc", string(first.Body))
+	assert.Equal(t, "GET /next", string(rest))
+}
+
+func TestRequestHeadLargerThanReadBuffer(t *testing.T) {
+	// Test: a request head that does not fit the initial buffer still parses
+	long := strings.Repeat("x", 3*1024)
+	data := "GET / HTTP/1.1\r\nHost: x\r\nCookie: " + long + "\r\n\r\n"
+	for _, chunkSize := range []int{7, 1000, len(data)} {
+		r, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: chunkSize})
+		require.NoError(t, err)
+		assert.Equal(t, long, r.Headers["cookie"])
+	}
+}
+
 type chunkReader struct {
 	data            string
 	numBytesPerRead int
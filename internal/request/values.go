@@ -0,0 +1,20 @@
+package request
+
+// SetValue attaches value to the request under key, for handlers further
+// down the chain to read with Value. As with context keys, key should be of
+// an unexported type so packages cannot clash. A nil value removes the key.
+func (r *Request) SetValue(key, value any) {
+	if value == nil {
+		delete(r.values, key)
+		return
+	}
+	if r.values == nil {
+		r.values = map[any]any{}
+	}
+	r.values[key] = value
+}
+
+// Value returns the value attached to the request under key, or nil.
+func (r *Request) Value(key any) any {
+	return r.values[key]
+}
@@ -0,0 +1,23 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testKey struct{ name string }
+
+func TestValues(t *testing.T) {
+	r := &Request{}
+	assert.Nil(t, r.Value(testKey{"a"}))
+
+	r.SetValue(testKey{"a"}, 1)
+	r.SetValue(testKey{"b"}, "two")
+	assert.Equal(t, 1, r.Value(testKey{"a"}))
+	assert.Equal(t, "two", r.Value(testKey{"b"}))
+	assert.Nil(t, r.Value("a"), "keys of other types do not match")
+
+	r.SetValue(testKey{"a"}, nil)
+	assert.Nil(t, r.Value(testKey{"a"}))
+}
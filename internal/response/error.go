@@ -0,0 +1,33 @@
+package response
+
+import (
+	"log"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+)
+
+// WriteError writes a complete text/plain response with message as the body.
+// Fields in h are added to the defaults; h may be nil. Write errors are
+// logged, since by then there is no one left to report them to.
+func WriteError(w *Writer, status StatusCode, h headers.Headers, message string) {
+	body := message + "\n"
+	err := w.WriteStatusLine(status)
+	if err != nil {
+		log.Printf("Error writing status line: %v", err)
+		return
+	}
+	all := GetDefaultHeaders(len(body))
+	all.Override("content-type", "text/plain")
+	for key, value := range h {
+		all.Override(key, value)
+	}
+	err = w.WriteHeaders(all)
+	if err != nil {
+		log.Printf("Error writing headers: %v", err)
+		return
+	}
+	_, err = w.WriteBody([]byte(body))
+	if err != nil {
+		log.Printf("Error writing body: %v", err)
+	}
+}
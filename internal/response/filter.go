@@ -0,0 +1,26 @@
+package response
+
+import "github.com/jonvanw/httpfromtcp/internal/headers"
+
+// Filter sees each call a handler makes on a Writer before anything reaches
+// the connection, so middleware can rewrite responses, for example to
+// compress the body. It passes the result on to the Writer it wraps.
+type Filter interface {
+	WriteStatusLine(statusCode StatusCode) error
+	WriteHeaders(h headers.Headers) error
+	WriteBody(p []byte) (int, error)
+	WriteChunkedBody(p []byte) (int, error)
+	WriteChunkedBodyDone() (int, error)
+	WriteTrailers(h headers.Headers) error
+	Flush() error
+}
+
+// NewFilterWriter returns a Writer that hands every call to f. It can be
+// hijacked when w can; f is flushed first so nothing it holds back is lost.
+func NewFilterWriter(w *Writer, f Filter) *Writer {
+	return &Writer{
+		status: WriterInitialized,
+		filter: f,
+		parent: w,
+	}
+}
@@ -0,0 +1,66 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"net"
+)
+
+var (
+	ErrHijacked      = errors.New("connection has been hijacked")
+	ErrNotHijackable = errors.New("writer is not backed by a connection")
+)
+
+// CanHijack reports whether the writer is backed by a connection the handler
+// can take over.
+func (w *Writer) CanHijack() bool {
+	if w.parent != nil {
+		return w.status != WriterHijacked && w.parent.CanHijack()
+	}
+	return w.conn != nil && w.status != WriterHijacked
+}
+
+// Hijack hands the underlying connection to the caller, for protocol upgrades,
+// tunnels and the like. Output written so far is flushed first. The returned
+// reader yields any bytes the server had already read past the request,
+// followed by the rest of the connection.
+//
+// After Hijack the server no longer flushes or closes the connection, and all
+// Writer methods return ErrHijacked. Closing the connection is up to the caller.
+func (w *Writer) Hijack() (net.Conn, *bufio.Reader, error) {
+	if w.status == WriterHijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.parent != nil {
+		if !w.parent.CanHijack() {
+			return nil, nil, ErrNotHijackable
+		}
+		if err := w.filter.Flush(); err != nil {
+			return nil, nil, err
+		}
+		conn, br, err := w.parent.Hijack()
+		if err != nil {
+			return nil, nil, err
+		}
+		w.status = WriterHijacked
+		return conn, br, nil
+	}
+	if w.conn == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	err := w.Flush()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.status = WriterHijacked
+	br := w.connReader
+	if br == nil {
+		br = bufio.NewReader(w.conn)
+	}
+	return w.conn, br, nil
+}
+
+// Hijacked reports whether Hijack has been called.
+func (w *Writer) Hijacked() bool {
+	return w.status == WriterHijacked
+}
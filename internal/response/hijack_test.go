@@ -0,0 +1,77 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHijackReturnsConnAndBufferedBytes(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+
+	// Test: bytes the server read past the request come back first
+	br := bufio.NewReader(io.MultiReader(strings.NewReader("early "), serverSide))
+	w := NewConnWriter(serverSide, br)
+	require.True(t, w.CanHijack())
+
+	go clientSide.Write([]byte("late"))
+	conn, hr, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, serverSide, conn)
+	assert.True(t, w.Hijacked())
+
+	got := make([]byte, len("early late"))
+	_, err = io.ReadFull(hr, got)
+	require.NoError(t, err)
+	assert.Equal(t, "early late", string(got))
+}
+
+func TestHijackFlushesPendingOutput(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+
+	w := NewConnWriter(serverSide, bufio.NewReader(serverSide))
+	require.NoError(t, w.WriteStatusLine(StatusSwitchingProtocols))
+
+	line := make(chan string, 1)
+	go func() {
+		s, _ := bufio.NewReader(clientSide).ReadString('\n')
+		line <- s
+	}()
+	_, _, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", <-line)
+}
+
+func TestWriterMethodsFailAfterHijack(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+
+	w := NewConnWriter(serverSide, bufio.NewReader(serverSide))
+	_, _, err := w.Hijack()
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrHijacked)
+	_, err = w.WriteBody([]byte("x"))
+	assert.ErrorIs(t, err, ErrHijacked)
+	assert.ErrorIs(t, w.Flush(), ErrHijacked)
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrHijacked)
+}
+
+func TestHijackWithoutConn(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	assert.False(t, w.CanHijack())
+	_, _, err := w.Hijack()
+	assert.ErrorIs(t, err, ErrNotHijackable)
+}
@@ -0,0 +1,339 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jonvanw/httpfromtcp/internal"
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+)
+
+type responseState int
+
+const (
+	responseStateInitialized responseState = iota
+	responseStateParsingHeaders
+	responseStateParsingBody
+	responseStateParsingFixedBody
+	responseStateParsingChunkSize
+	responseStateParsingChunkData
+	responseStateParsingChunkEnd
+	responseStateParsingTrailers
+	responseStateReadingUntilClose
+	responseStateDone
+)
+
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       []byte
+	Trailers   headers.Headers
+	state      responseState
+	method     string
+	remaining  int
+	// scanned is how much of the unparsed data hasLine has searched
+	scanned int
+}
+
+// maxBodyPrealloc caps how much of a declared Content-Length is allocated
+// before the body arrives.
+const maxBodyPrealloc = 64 * 1024
+
+var crlf = []byte(internal.CRLF)
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+// ResponseFromReader parses a complete response to a request with the given
+// method from reader, skipping any 1xx interim responses before it. Like
+// request.RequestFromReader, the reader must end with the message.
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	for {
+		response, data, err := ReadResponse(reader, method)
+		if err != nil {
+			return nil, err
+		}
+		code := response.StatusLine.StatusCode
+		if code >= 100 && code < 200 && code != 101 {
+			reader = io.MultiReader(bytes.NewReader(data), reader)
+			continue
+		}
+		if len(data) > 0 {
+			return nil, fmt.Errorf("unexpected data after response")
+		}
+		return response, nil
+	}
+}
+
+// ReadResponse parses a single response from a reader that may carry more
+// data after it, returning any bytes read past the end of the response.
+// method is the method of the request being answered, since responses to
+// HEAD never have a body. A 1xx interim response is returned on its own;
+// parse the returned bytes again to get the final response.
+func ReadResponse(reader io.Reader, method string) (*Response, []byte, error) {
+	response := &Response{method: method}
+	buf := make([]byte, internal.BUFFSIZE)
+	// buf[start:end] holds data read but not yet parsed
+	start, end := 0, 0
+	for response.state != responseStateDone {
+		if end == len(buf) {
+			if start > 0 {
+				end = copy(buf, buf[start:end])
+				start = 0
+			} else {
+				grown := make([]byte, 2*len(buf))
+				copy(grown, buf[:end])
+				buf = grown
+			}
+		}
+		n, err := reader.Read(buf[end:])
+		end += n
+		consumed, perr := response.parse(buf[start:end])
+		if perr != nil {
+			return nil, nil, perr
+		}
+		start += consumed
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// only a body without framing is allowed to end with the connection
+				if response.state == responseStateReadingUntilClose {
+					response.state = responseStateDone
+					break
+				}
+				if response.state != responseStateDone {
+					return nil, nil, fmt.Errorf("reader ended before response was fully parsed")
+				}
+				break
+			}
+			return nil, nil, fmt.Errorf("failed to read from reader: %w", err)
+		}
+	}
+	return response, buf[start:end], nil
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	totalBytes := 0
+	for r.state != responseStateDone {
+		n, err := r.parseSingleItem(data[totalBytes:])
+		if err != nil {
+			return 0, err
+		}
+		totalBytes += n
+		if n == 0 && r.state != responseStateParsingBody {
+			break
+		}
+	}
+	return totalBytes, nil
+}
+
+func (r *Response) parseSingleItem(data []byte) (int, error) {
+	switch r.state {
+	case responseStateInitialized:
+		if !r.hasLine(data) {
+			return 0, nil
+		}
+		n, statusLine, err := ParseStatusLine(data)
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			r.StatusLine = statusLine
+			r.Headers = headers.NewHeaders()
+			r.state = responseStateParsingHeaders
+		}
+		return n, nil
+	case responseStateParsingHeaders:
+		if !r.hasLine(data) {
+			return 0, nil
+		}
+		n, done, err := r.Headers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.state = responseStateParsingBody
+		}
+		return n, nil
+	case responseStateParsingBody:
+		// decides how the body is framed; consumes nothing itself
+		return 0, r.startBody()
+	case responseStateParsingFixedBody:
+		n := min(len(data), r.remaining)
+		r.Body = append(r.Body, data[:n]...)
+		r.remaining -= n
+		if r.remaining == 0 {
+			r.state = responseStateDone
+		}
+		return n, nil
+	case responseStateParsingChunkSize:
+		if !r.hasLine(data) {
+			return 0, nil
+		}
+		lineEndIndex := bytes.Index(data, crlf)
+		size, err := internal.ParseChunkSize(data[:lineEndIndex])
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			r.Trailers = headers.NewHeaders()
+			r.state = responseStateParsingTrailers
+		} else {
+			r.remaining = size
+			r.state = responseStateParsingChunkData
+		}
+		return lineEndIndex + 2, nil
+	case responseStateParsingChunkData:
+		n := min(len(data), r.remaining)
+		r.Body = append(r.Body, data[:n]...)
+		r.remaining -= n
+		if r.remaining == 0 {
+			r.state = responseStateParsingChunkEnd
+		}
+		return n, nil
+	case responseStateParsingChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, crlf) {
+			return 0, fmt.Errorf("missing CRLF after chunk data")
+		}
+		r.state = responseStateParsingChunkSize
+		return 2, nil
+	case responseStateParsingTrailers:
+		if !r.hasLine(data) {
+			return 0, nil
+		}
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.state = responseStateDone
+		}
+		return n, nil
+	case responseStateReadingUntilClose:
+		r.Body = append(r.Body, data...)
+		return len(data), nil
+	case responseStateDone:
+		return 0, fmt.Errorf("error: attempting to parse response after it is already done")
+	default:
+		return 0, fmt.Errorf("Invalid response parser state: %v", r.state)
+	}
+}
+
+// Framing says how the body of a response is delimited.
+type Framing int
+
+const (
+	// FramingNone means there is no body at all.
+	FramingNone Framing = iota
+	// FramingLength means the body is exactly Content-Length bytes.
+	FramingLength
+	// FramingChunked means the body uses the chunked transfer coding.
+	FramingChunked
+	// FramingUntilClose means the body runs until the connection closes.
+	FramingUntilClose
+)
+
+// BodyFraming picks the framing of a response body to a request with the
+// given method, following the message length rules of RFC 9112 section 6.3.
+// The length is only meaningful for FramingLength.
+func BodyFraming(method string, code StatusCode, h headers.Headers) (Framing, int64, error) {
+	if method == "HEAD" || code < 200 || code == 204 || code == 304 {
+		return FramingNone, 0, nil
+	}
+	if te, ok := h.Get("Transfer-Encoding"); ok {
+		if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return 0, 0, fmt.Errorf("unsupported transfer-encoding: %s", te)
+		}
+		return FramingChunked, 0, nil
+	}
+	if contentLengthStr, ok := h.Get("Content-Length"); ok {
+		contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
+		if err != nil || contentLength < 0 {
+			return 0, 0, fmt.Errorf("invalid Content-Length header: %q", contentLengthStr)
+		}
+		return FramingLength, contentLength, nil
+	}
+	return FramingUntilClose, 0, nil
+}
+
+// startBody sets up the parser for the body once the headers are in.
+func (r *Response) startBody() error {
+	framing, length, err := BodyFraming(r.method, r.StatusLine.StatusCode, r.Headers)
+	if err != nil {
+		return err
+	}
+	switch framing {
+	case FramingNone:
+		r.state = responseStateDone
+	case FramingChunked:
+		r.Body = []byte{}
+		r.state = responseStateParsingChunkSize
+	case FramingLength:
+		// a bogus Content-Length should not make us allocate it up front
+		r.Body = make([]byte, 0, min(length, maxBodyPrealloc))
+		if length == 0 {
+			r.state = responseStateDone
+			return nil
+		}
+		r.remaining = int(length)
+		r.state = responseStateParsingFixedBody
+	case FramingUntilClose:
+		r.Body = []byte{}
+		r.state = responseStateReadingUntilClose
+	}
+	return nil
+}
+
+// hasLine reports whether data holds a complete line. It remembers how far
+// it has looked, so a line that trickles in a few bytes per read is scanned
+// once rather than from the start on every read.
+func (r *Response) hasLine(data []byte) bool {
+	// the CR of a CRLF may have been the last byte seen
+	from := max(r.scanned-1, 0)
+	if bytes.Index(data[from:], crlf) == -1 {
+		r.scanned = len(data)
+		return false
+	}
+	r.scanned = 0
+	return true
+}
+
+// ParseStatusLine parses a status line such as "HTTP/1.1 404 Not Found\r\n"
+// from the start of data. It returns 0 bytes consumed if data does not yet
+// hold a full line.
+func ParseStatusLine(data []byte) (int, StatusLine, error) {
+	lineEndIndex := bytes.Index(data, []byte(internal.CRLF))
+	if lineEndIndex == -1 {
+		return 0, StatusLine{}, nil
+	}
+	line := string(data[:lineEndIndex])
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return 0, StatusLine{}, fmt.Errorf("invalid status line: %q", line)
+	}
+	version, ok := strings.CutPrefix(parts[0], "HTTP/")
+	if !ok || (version != "1.1" && version != "1.0") {
+		return 0, StatusLine{}, fmt.Errorf("unsupported HTTP version in status line: %q", line)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 3 || code < 100 {
+		return 0, StatusLine{}, fmt.Errorf("invalid status code in status line: %q", line)
+	}
+	statusLine := StatusLine{
+		HttpVersion: version,
+		StatusCode:  StatusCode(code),
+	}
+	if len(parts) == 3 {
+		statusLine.ReasonPhrase = parts[2]
+	}
+	return lineEndIndex + 2, statusLine, nil
+}
@@ -0,0 +1,230 @@
+package response
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoodStatusLine(t *testing.T) {
+	r, err := ResponseFromReader(strings.NewReader("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"), "GET")
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, StatusCode(404), r.StatusLine.StatusCode)
+	assert.Equal(t, "Not Found", r.StatusLine.ReasonPhrase)
+	assert.Empty(t, r.Body)
+}
+
+func TestGoodStatusLineWithoutReason(t *testing.T) {
+	// Test: the reason phrase is optional, the space before it is not
+	r, err := ResponseFromReader(strings.NewReader("HTTP/1.1 201 \r\nContent-Length: 0\r\n\r\n"), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(201), r.StatusLine.StatusCode)
+	assert.Equal(t, "", r.StatusLine.ReasonPhrase)
+}
+
+func TestBadStatusLines(t *testing.T) {
+	for _, line := range []string{
+		"HTTP/2 200 OK",
+		"HTTP/1.1 20 OK",
+		"HTTP/1.1 abc OK",
+		"200 OK",
+		"HTTP/1.1",
+	} {
+		_, err := ResponseFromReader(strings.NewReader(line+"\r\n\r\n"), "GET")
+		require.Error(t, err, line)
+	}
+}
+
+func TestContentLengthBodyWithChunkedReader(t *testing.T) {
+	data := "HTTP/1.1 200 OK\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Length: 13\r\n" +
+		"\r\n" +
+		"Hello world!\n"
+	for chunkSize := 1; chunkSize <= len(data); chunkSize++ {
+		reader := &chunkReader{
+			data:            data,
+			numBytesPerRead: chunkSize,
+		}
+		r, err := ResponseFromReader(reader, "GET")
+		require.NoError(t, err)
+		require.NotNil(t, r)
+		assert.Equal(t, "text/plain", r.Headers["content-type"])
+		assert.Equal(t, "Hello world!\n", string(r.Body))
+	}
+}
+
+func TestBodyLongerThanContentLength(t *testing.T) {
+	_, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nabcDEF"), "GET")
+	require.Error(t, err)
+}
+
+func TestBodyShorterThanContentLength(t *testing.T) {
+	_, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 20\r\n\r\npartial"), "GET")
+	require.Error(t, err)
+}
+
+func TestChunkedBodyWithTrailers(t *testing.T) {
+	data := "HTTP/1.1 200 OK\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Trailer: X-Content-Length\r\n" +
+		"\r\n" +
+		"6\r\nhello \r\n" +
+		"5;name=value\r\nworld\r\n" +
+		"0\r\n" +
+		"X-Content-Length: 11\r\n" +
+		"\r\n"
+	for chunkSize := 1; chunkSize <= len(data); chunkSize++ {
+		reader := &chunkReader{
+			data:            data,
+			numBytesPerRead: chunkSize,
+		}
+		r, err := ResponseFromReader(reader, "GET")
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(r.Body))
+		assert.Equal(t, "11", r.Trailers["x-content-length"])
+	}
+}
+
+func TestChunkedBodyMissingCRLF(t *testing.T) {
+	_, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabcX0\r\n\r\n"), "GET")
+	require.Error(t, err)
+}
+
+func TestBadChunkSize(t *testing.T) {
+	for _, size := range []string{"zz", "+3", "-3", "0x3"} {
+		_, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"+size+"\r\nabc\r\n0\r\n\r\n"), "GET")
+		require.Error(t, err, size)
+	}
+}
+
+func TestHugeContentLengthIsNotPreallocated(t *testing.T) {
+	// Test: a peer's Content-Length does not decide how much is allocated
+	_, _, err := ReadResponse(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 9223372036854775807\r\n\r\nabc"), "GET")
+	require.Error(t, err)
+}
+
+func TestTrickledResponse(t *testing.T) {
+	// Test: a response arriving a byte at a time parses the same as in one read
+	data := "HTTP/1.1 200 OK\r\nX-Long: " + strings.Repeat("v", 5000) + "\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"3\r\nabc\r\n0\r\nX-Sum: 1\r\n\r\nnext"
+	r, rest, err := ReadResponse(&chunkReader{data: data, numBytesPerRead: 1}, "GET")
+	require.NoError(t, err)
+	assert.Len(t, r.Headers["x-long"], 5000)
+	assert.Equal(t, "abc", string(r.Body))
+	assert.Equal(t, "1", r.Trailers["x-sum"])
+	assert.Equal(t, "", string(rest))
+}
+
+func TestCloseDelimitedBody(t *testing.T) {
+	// Test: with no framing headers the body runs until the reader ends
+	reader := &chunkReader{
+		data:            "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nall the rest",
+		numBytesPerRead: 4,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HttpVersion)
+	assert.Equal(t, "all the rest", string(r.Body))
+}
+
+func TestBodilessResponses(t *testing.T) {
+	for _, status := range []string{"204 No Content", "304 Not Modified"} {
+		// Test: no body is read even though more bytes follow
+		r, rest, err := ReadResponse(strings.NewReader("HTTP/1.1 "+status+"\r\nETag: \"x\"\r\n\r\nHTTP/1.1 200 OK\r\n"), "GET")
+		require.NoError(t, err, status)
+		assert.Empty(t, r.Body)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", string(rest))
+	}
+}
+
+func TestHeadResponseIgnoresContentLength(t *testing.T) {
+	r, rest, err := ReadResponse(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 1234\r\n\r\n"), "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "1234", r.Headers["content-length"])
+	assert.Empty(t, r.Body)
+	assert.Empty(t, rest)
+}
+
+func TestInterimResponseThenFinal(t *testing.T) {
+	data := "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	interim, rest, err := ReadResponse(strings.NewReader(data), "POST")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(100), interim.StatusLine.StatusCode)
+
+	final, err := ResponseFromReader(strings.NewReader(string(rest)), "POST")
+	require.NoError(t, err)
+	assert.Equal(t, StatusOK, final.StatusLine.StatusCode)
+	assert.Equal(t, "ok", string(final.Body))
+
+	// Test: ResponseFromReader skips interim responses itself
+	reader := &chunkReader{data: "HTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\n" + data, numBytesPerRead: 5}
+	final, err = ResponseFromReader(reader, "POST")
+	require.NoError(t, err)
+	assert.Equal(t, StatusOK, final.StatusLine.StatusCode)
+	assert.Equal(t, "ok", string(final.Body))
+}
+
+func TestResponseFromReaderHonoursMethod(t *testing.T) {
+	r, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 1234\r\n\r\n"), "HEAD")
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 304 Not Modified\r\nContent-Length: 1234\r\n\r\n"), "GET")
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+}
+
+func TestMissingEndOfResponseHeaders(t *testing.T) {
+	_, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n"), "GET")
+	require.Error(t, err)
+}
+
+func TestRoundTripWithWriter(t *testing.T) {
+	// Test: what Writer produces, the parser reads back
+	buf := &strings.Builder{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := GetDefaultHeaders(0)
+	h.Remove("content-length")
+	h.Override("transfer-encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("streamed"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(map[string]string{"x-done": "yes"}))
+
+	r, err := ResponseFromReader(strings.NewReader(buf.String()), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "streamed", string(r.Body))
+	assert.Equal(t, "yes", r.Trailers["x-done"])
+}
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
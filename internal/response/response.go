@@ -11,35 +11,90 @@ import (
 type StatusCode int
 
 const (
+	StatusSwitchingProtocols StatusCode = 101
 	StatusOK StatusCode = 200
+	StatusPartialContent StatusCode = 206
+	StatusMovedPermanently StatusCode = 301
+	StatusNotModified StatusCode = 304
 	StatusBadRequest StatusCode = 400
+	StatusUnauthorized StatusCode = 401
+	StatusForbidden StatusCode = 403
+	StatusNotFound StatusCode = 404
+	StatusMethodNotAllowed StatusCode = 405
+	StatusNotAcceptable StatusCode = 406
+	StatusPreconditionFailed StatusCode = 412
+	StatusContentTooLarge StatusCode = 413
+	StatusUnsupportedMediaType StatusCode = 415
+	StatusRangeNotSatisfiable StatusCode = 416
+	StatusUpgradeRequired StatusCode = 426
 	StatusInternalServerError StatusCode = 500
+	StatusBadGateway StatusCode = 502
+	StatusGatewayTimeout StatusCode = 504
 )
 
-func WriteStatusLine(w io.Writer, status StatusCode) error { 
-	var reasonPhrase string
+// StatusText returns the reason phrase for a status code, or "" if it is
+// not one this package knows.
+func StatusText(status StatusCode) string {
 	switch status {
+	case StatusSwitchingProtocols:
+		return "Switching Protocols"
 	case StatusOK:
-		reasonPhrase = "OK"
+		return "OK"
+	case StatusPartialContent:
+		return "Partial Content"
+	case StatusMovedPermanently:
+		return "Moved Permanently"
+	case StatusNotModified:
+		return "Not Modified"
 	case StatusBadRequest:
-		reasonPhrase = "Bad Request"
+		return "Bad Request"
+	case StatusUnauthorized:
+		return "Unauthorized"
+	case StatusForbidden:
+		return "Forbidden"
+	case StatusNotFound:
+		return "Not Found"
+	case StatusMethodNotAllowed:
+		return "Method Not Allowed"
+	case StatusNotAcceptable:
+		return "Not Acceptable"
+	case StatusPreconditionFailed:
+		return "Precondition Failed"
+	case StatusContentTooLarge:
+		return "Content Too Large"
+	case StatusUnsupportedMediaType:
+		return "Unsupported Media Type"
+	case StatusRangeNotSatisfiable:
+		return "Range Not Satisfiable"
+	case StatusUpgradeRequired:
+		return "Upgrade Required"
 	case StatusInternalServerError:
-		reasonPhrase = "Internal Server Error"
+		return "Internal Server Error"
+	case StatusBadGateway:
+		return "Bad Gateway"
+	case StatusGatewayTimeout:
+		return "Gateway Timeout"
 	default:
-		reasonPhrase = ""
+		return ""
 	}
-	
+}
+
+func WriteStatusLine(w io.Writer, status StatusCode) error {
+	reasonPhrase := StatusText(status)
 	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, reasonPhrase)
 	_, err := io.WriteString(w, statusLine)
 	return err
 }
 
 func WriteHeaders(w io.Writer, headers headers.Headers) error {
-	for key, value := range headers {
-		headerLine := fmt.Sprintf("%s: %s\r\n", key, value)
-		_, err := io.WriteString(w, headerLine)
-		if err != nil {
-			return fmt.Errorf("error writing header %s: %v", key, err)
+	for key := range headers {
+		// repeated Set-Cookie fields each get their own line
+		for _, value := range headers.Values(key) {
+			headerLine := fmt.Sprintf("%s: %s\r\n", key, value)
+			_, err := io.WriteString(w, headerLine)
+			if err != nil {
+				return fmt.Errorf("error writing header %s: %v", key, err)
+			}
 		}
 	}
 	// Write the blank line to indicate the end of headers
@@ -1,8 +1,11 @@
 package response
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
 
 	"github.com/jonvanw/httpfromtcp/internal/headers"
 )
@@ -17,11 +20,23 @@ const (
 	WritingBody
 	WroteBody
 	WroteTrailers
+	WriterHijacked
 )
 
 type Writer struct {
 	status WriterStatus
 	IOWriter io.Writer
+	conn net.Conn
+	connReader *bufio.Reader
+	// filter and parent are set for writers made by NewFilterWriter
+	filter Filter
+	parent *Writer
+	// fixedBody is set once WriteBody has been used, so it may be called
+	// again to stream the rest of the body
+	fixedBody bool
+	// bodyRemaining is what is left of the Content-Length, or -1 when the
+	// headers did not set one
+	bodyRemaining int64
 }
 
 func NewWriter(w io.Writer) *Writer {
@@ -31,7 +46,25 @@ func NewWriter(w io.Writer) *Writer {
 	}
 }
 
+// NewConnWriter returns a Writer that buffers its output to conn and can be
+// hijacked. br must read from conn, starting with any bytes already consumed
+// past the current request.
+func NewConnWriter(conn net.Conn, br *bufio.Reader) *Writer {
+	return &Writer{
+		status: WriterInitialized,
+		IOWriter: bufio.NewWriter(conn),
+		conn: conn,
+		connReader: br,
+	}
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	if w.status == WriterHijacked {
+		return ErrHijacked
+	}
+	if w.filter != nil {
+		return w.filter.WriteStatusLine(statusCode)
+	}
 	if w.status != WriterInitialized {
 		return fmt.Errorf("already wrote status line, current status: %d", w.status)
 	}
@@ -45,6 +78,12 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 }
 
 func (w *Writer) WriteHeaders(headers headers.Headers) error { 
+	if w.status == WriterHijacked {
+		return ErrHijacked
+	}
+	if w.filter != nil {
+		return w.filter.WriteHeaders(headers)
+	}
 	if w.status != WroteStatusLine {
 		return fmt.Errorf("cannot write headers before writing status line, current status: %d", w.status)
 	}
@@ -54,11 +93,25 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 		return err
 	}
 	w.status = WroterHeaders
+	w.bodyRemaining = -1
+	if value, ok := headers.Get("content-length"); ok {
+		if length, err := strconv.ParseInt(value, 10, 64); err == nil && length >= 0 {
+			w.bodyRemaining = length
+		}
+	}
 	return nil
 }
 
+// WriteBody writes part of a body framed by Content-Length. It may be called
+// again to stream the rest of the body.
 func (w *Writer) WriteBody(p []byte) (n int, err error) {
-	if w.status != WroterHeaders {
+	if w.status == WriterHijacked {
+		return 0, ErrHijacked
+	}
+	if w.filter != nil {
+		return w.filter.WriteBody(p)
+	}
+	if w.status != WroterHeaders && !(w.status == WroteBody && w.fixedBody) {
 		return 0, fmt.Errorf("cannot write body before writing headers, current status: %d", w.status)
 	}
 	n, err = w.IOWriter.Write(p)
@@ -67,10 +120,79 @@ func (w *Writer) WriteBody(p []byte) (n int, err error) {
 		return n, err
 	}
 	w.status = WroteBody
+	w.fixedBody = true
+	if w.bodyRemaining > 0 {
+		w.bodyRemaining = max(w.bodyRemaining-int64(n), 0)
+	}
 	return n, err
 }
 
+// ReadFrom writes body bytes from r until EOF, like WriteBody, and never
+// more than the rest of the Content-Length. On a connection that supports
+// it, such as a *net.TCPConn, the copy is done by the connection itself, so
+// an *os.File or an io.LimitedReader over one is sent with sendfile and a
+// socket with splice, without passing through user space.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == WriterHijacked {
+		return 0, ErrHijacked
+	}
+	if w.filter != nil {
+		if rf, ok := w.filter.(io.ReaderFrom); ok {
+			return rf.ReadFrom(r)
+		}
+		return io.Copy(writerFunc(w.filter.WriteBody), r)
+	}
+	if w.status != WroterHeaders && !(w.status == WroteBody && w.fixedBody) {
+		return 0, fmt.Errorf("cannot write body before writing headers, current status: %d", w.status)
+	}
+	if w.bodyRemaining >= 0 {
+		// a new LimitedReader over the same file, rather than one wrapping
+		// the caller's, keeps sendfile usable
+		limit := w.bodyRemaining
+		if lr, ok := r.(*io.LimitedReader); ok {
+			r, limit = lr.R, min(lr.N, limit)
+		}
+		r = &io.LimitedReader{R: r, N: limit}
+	}
+
+	var n int64
+	var err error
+	if rf, ok := w.conn.(io.ReaderFrom); ok {
+		// the status line and headers must reach the connection first
+		if err = w.Flush(); err != nil {
+			return 0, err
+		}
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(writerFunc(w.IOWriter.Write), r)
+	}
+	if w.bodyRemaining > 0 {
+		w.bodyRemaining -= n
+	}
+	if err != nil {
+		w.status = WriterError
+		return n, err
+	}
+	w.status = WroteBody
+	w.fixedBody = true
+	return n, nil
+}
+
+// writerFunc hides any ReadFrom method of the writer it came from, so
+// io.Copy into it cannot loop back into Writer.ReadFrom.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.status == WriterHijacked {
+		return 0, ErrHijacked
+	}
+	if w.filter != nil {
+		return w.filter.WriteChunkedBody(p)
+	}
 	if w.status != WroterHeaders && w.status != WritingBody {
 		return 0, fmt.Errorf("can only call WriteChunkedBody() after calling WriteHeaders() or WriteChunkedBody(), current status: %d", w.status)
 	}
@@ -93,6 +215,12 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.status == WriterHijacked {
+		return 0, ErrHijacked
+	}
+	if w.filter != nil {
+		return w.filter.WriteChunkedBodyDone()
+	}
 	if w.status != WroterHeaders && w.status != WritingBody {
 		return 0, fmt.Errorf("can only call WriteChunkedBodyDone() after calling WriteChunkedBody() (or after WriteHeaders() for empty chunked body), current status: %d", w.status)
 	}
@@ -106,8 +234,14 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 }
 
 func (w *Writer) WriteTrailers(h headers.Headers) error {
-	if w.status != WroteBody {
-		return fmt.Errorf("can only call WriteTrailers() after body was written, current status: %d", w.status)
+	if w.status == WriterHijacked {
+		return ErrHijacked
+	}
+	if w.filter != nil {
+		return w.filter.WriteTrailers(h)
+	}
+	if w.status != WroteBody || w.fixedBody {
+		return fmt.Errorf("can only call WriteTrailers() after a chunked body was written, current status: %d", w.status)
 	}
 	err := WriteHeaders(w.IOWriter, h)
 	if err != nil {
@@ -116,4 +250,24 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	}
 	w.status = WroteTrailers
 	return nil
-}
\ No newline at end of file
+}
+
+// Flush pushes any buffered output to the client. It is a no-op when the
+// underlying writer does not buffer.
+func (w *Writer) Flush() error {
+	if w.status == WriterHijacked {
+		return ErrHijacked
+	}
+	if w.filter != nil {
+		return w.filter.Flush()
+	}
+	f, ok := w.IOWriter.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+	err := f.Flush()
+	if err != nil {
+		w.status = WriterError
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFromStopsAtContentLength(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+
+	// Test: WriteBody and ReadFrom share the Content-Length
+	_, err := w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	n, err := w.ReadFrom(strings.NewReader("defghijklmnop"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), n)
+
+	res, rest, err := ReadResponse(buf, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "abcdefghij", string(res.Body))
+	assert.Empty(t, rest)
+}
+
+func TestReadFromKeepsTighterLimit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"content-length": "4"}))
+	n, err := w.ReadFrom(io.LimitReader(strings.NewReader("abcdef"), 2))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	n, err = w.ReadFrom(strings.NewReader("cdef"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nabcd"))
+}
+
+func TestReadFromBeforeHeaders(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	_, err := w.ReadFrom(strings.NewReader("x"))
+	assert.Error(t, err)
+}
+
+func TestReadFromFileOverTCP(t *testing.T) {
+	// Test: a file range sent through the connection's own ReadFrom arrives
+	// after the buffered status line and headers
+	data := bytes.Repeat([]byte("0123456789"), 50_000)
+	path := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		f, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		w := NewConnWriter(conn, bufio.NewReader(conn))
+		w.WriteStatusLine(StatusPartialContent)
+		w.WriteHeaders(GetDefaultHeaders(len(data) - 10))
+		f.Seek(10, io.SeekStart)
+		w.ReadFrom(f)
+		w.Flush()
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	res, _, err := ReadResponse(conn, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusPartialContent, res.StatusLine.StatusCode)
+	assert.Equal(t, data[10:], res.Body)
+}
@@ -2,11 +2,16 @@ package server
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"sync/atomic"
+	"time"
 
+	"github.com/jonvanw/httpfromtcp/internal/capture"
 	"github.com/jonvanw/httpfromtcp/internal/request"
 	"github.com/jonvanw/httpfromtcp/internal/response"
 )
@@ -16,9 +21,16 @@ type Server struct {
 	handler  Handler
 	listener net.Listener
 	closed   atomic.Bool
+	recorder *capture.Recorder
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeRecording(port, handler, nil)
+}
+
+// ServeRecording is like Serve but writes every exchange to recorder, when
+// it is not nil, for later replay.
+func ServeRecording(port int, handler Handler, recorder *capture.Recorder) (*Server, error) {
 	addr := fmt.Sprintf(":%d", port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -26,9 +38,11 @@ func Serve(port int, handler Handler) (*Server, error) {
 	}
 
 	s := &Server{
-		Port: port, 
+		// report the actual port when asked to listen on port 0
+		Port: listener.Addr().(*net.TCPAddr).Port, 
 		handler: handler,
 		listener: listener,
+		recorder: recorder,
 	}
 	go s.listen()
 	
@@ -57,17 +71,125 @@ func (s *Server) listen() {
 	}
 }
 
+// ServeConn serves a single request on conn exactly as a listening server
+// would, then closes conn unless the handler hijacked it.
+func ServeConn(conn net.Conn, handler Handler) {
+	s := &Server{handler: handler}
+	s.handle(conn)
+}
+
 func (s *Server) handle(conn net.Conn) { 
-	defer conn.Close()
-	bw := bufio.NewWriter(conn)
-	defer bw.Flush()
-	 
-	req, err := request.RequestFromReader(conn)
+	start := time.Now()
+	var reader io.Reader = conn
+	var rawRequest *bytes.Buffer
+	if s.recorder != nil {
+		rawRequest = &bytes.Buffer{}
+		reader = io.TeeReader(conn, rawRequest)
+	}
+	req, rest, err := request.ReadRequest(reader)
 	if err != nil {
 		log.Printf("Error parsing request: %v", err)
+		if errors.Is(err, request.ErrBodyTooLarge) {
+			// unlike a malformed request, this one deserves to know why
+			response.WriteError(response.NewWriter(conn), response.StatusContentTooLarge, nil, "request body is too large")
+			lingerClose(conn)
+		} else {
+			conn.Close()
+		}
+		if s.recorder != nil {
+			s.record(&capture.Record{
+				Time:       start,
+				RemoteAddr: conn.RemoteAddr().String(),
+				Request:    capture.NewBadRequest(rawRequest.Bytes(), err),
+				Timing:     capture.Timing{Read: time.Since(start), Total: time.Since(start)},
+			})
+		}
 		return
 	}
+	read := time.Now()
 	
-	rw := response.NewWriter(bw)
+	req.RemoteAddr = conn.RemoteAddr().String()
+
+	// the handler writes through out, which copies the response for the recorder
+	var out net.Conn = conn
+	var rawResponse *capture.LimitedBuffer
+	if s.recorder != nil {
+		rawResponse = &capture.LimitedBuffer{}
+		out = &teeConn{Conn: conn, w: rawResponse}
+	}
+	br := bufio.NewReader(io.MultiReader(bytes.NewReader(rest), conn))
+	rw := response.NewConnWriter(out, br)
 	s.handler(rw, req)
-}
\ No newline at end of file
+	handled := time.Now()
+
+	// a hijacked connection belongs to the handler now
+	if !rw.Hijacked() {
+		rw.Flush()
+		if req.Streaming() {
+			// the handler may have left some of the body unread
+			lingerClose(conn)
+		} else {
+			conn.Close()
+		}
+	}
+
+	if s.recorder != nil {
+		raw, truncated := rawResponse.Bytes()
+		s.record(&capture.Record{
+			Time:       start,
+			RemoteAddr: req.RemoteAddr,
+			// bytes read past the request are not part of it
+			Request:  capture.NewRequest(rawRequest.Bytes()[:rawRequest.Len()-len(rest)], req),
+			Response: capture.NewResponse(raw, req.RequestLine.Method, truncated),
+			Timing: capture.Timing{
+				Read:   read.Sub(start),
+				Handle: handled.Sub(read),
+				Total:  time.Since(start),
+			},
+		})
+	}
+}
+
+// lingerTimeout bounds how long lingerClose waits for the client to stop
+// sending.
+const lingerTimeout = 2 * time.Second
+
+// lingerClose closes conn after a response sent with part of the request
+// still unread. Closing straight away answers that data with a reset, which
+// can discard the response before the client reads it, so the write side is
+// shut first and the rest of the request drained for a while.
+func lingerClose(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+		io.Copy(io.Discard, conn)
+	}
+	conn.Close()
+}
+
+func (s *Server) record(rec *capture.Record) {
+	err := s.recorder.Write(rec)
+	if err != nil {
+		log.Printf("Error recording request: %v", err)
+	}
+}
+
+// teeConn copies everything written to the connection to w.
+type teeConn struct {
+	net.Conn
+	w io.Writer
+}
+
+func (t *teeConn) Write(p []byte) (int, error) {
+	n, err := t.Conn.Write(p)
+	t.w.Write(p[:n])
+	return n, err
+}
+
+// CloseWrite keeps half-closing working for tunnels over a recorded connection.
+func (t *teeConn) CloseWrite() error {
+	if cw, ok := t.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return t.Conn.Close()
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/capture"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lineWriter hands each write, which the recorder makes one per record, to a
+// channel.
+type lineWriter chan []byte
+
+func (l lineWriter) Write(p []byte) (int, error) {
+	l <- bytes.Clone(p)
+	return len(p), nil
+}
+
+func TestServeRecording(t *testing.T) {
+	lines := make(lineWriter, 2)
+	s, err := ServeRecording(0, func(w *response.Writer, req *request.Request) {
+		body := []byte("hi " + req.RequestLine.RequestTarget)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, capture.NewRecorder(lines))
+	require.NoError(t, err)
+	defer s.Close()
+
+	raw := "GET /there HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	got := roundTrip(t, s.Port, raw)
+	assert.Contains(t, got, "\r\n\r\nhi /there")
+
+	rec := nextRecord(t, lines)
+	assert.Equal(t, raw, string(rec.Request.Raw))
+	assert.Equal(t, "/there", rec.Request.Target)
+	require.NotNil(t, rec.Response)
+	assert.Equal(t, got, string(rec.Response.Raw))
+	assert.Equal(t, 200, rec.Response.StatusCode)
+	assert.Equal(t, "hi /there", rec.Response.Text)
+	assert.NotZero(t, rec.Timing.Total)
+
+	// Test: a request that cannot be parsed is recorded with its error
+	roundTrip(t, s.Port, "get / HTTP/1.1\r\n\r\n")
+	rec = nextRecord(t, lines)
+	require.NotNil(t, rec.Request.Error)
+	assert.Equal(t, "request line", rec.Request.Error.Stage)
+	assert.Nil(t, rec.Response)
+}
+
+func TestServeRefusesLargeBodies(t *testing.T) {
+	var called atomic.Bool
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		called.Store(true)
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", s.Port))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Test: the 413 survives a client that is still sending the body
+	fmt.Fprintf(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n", request.DefaultMaxBodySize+1)
+	go io.Copy(conn, strings.NewReader(strings.Repeat("x", 1<<20)))
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(got), "HTTP/1.1 413 ")
+	assert.False(t, called.Load())
+}
+
+func TestServeStreamsUploads(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/ignore" {
+			response.WriteError(w, response.StatusForbidden, nil, "no uploads here")
+			return
+		}
+		n, err := io.Copy(io.Discard, req.BodyReader())
+		if err != nil {
+			response.WriteError(w, response.StatusBadRequest, nil, err.Error())
+			return
+		}
+		body := strconv.FormatInt(n, 10)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	upload := func(target string, size int) string {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", s.Port))
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: localhost\r\nContent-Type: multipart/form-data; boundary=b\r\nContent-Length: %d\r\n\r\n", target, size)
+		go io.Copy(conn, strings.NewReader(strings.Repeat("x", size)))
+		got, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(got)
+	}
+
+	// Test: a multipart body is not held to the in-memory body cap
+	got := upload("/upload", request.DefaultMaxBodySize+1)
+	assert.Contains(t, got, "HTTP/1.1 200 ")
+	assert.True(t, strings.HasSuffix(got, strconv.Itoa(request.DefaultMaxBodySize+1)))
+
+	// Test: the response survives a handler that leaves the body unread
+	got = upload("/ignore", 1<<20)
+	assert.Contains(t, got, "HTTP/1.1 403 ")
+}
+
+func roundTrip(t *testing.T, port int, raw string) string {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(got)
+}
+
+func nextRecord(t *testing.T, lines lineWriter) *capture.Record {
+	select {
+	case line := <-lines:
+		rec, err := capture.NewReader(bytes.NewReader(line)).Next()
+		require.NoError(t, err)
+		return rec
+	case <-time.After(5 * time.Second):
+		t.Fatal("no record written")
+		return nil
+	}
+}
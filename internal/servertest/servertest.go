@@ -0,0 +1,124 @@
+package servertest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/server"
+)
+
+// RemoteAddr is the client address given to requests made by NewRequest.
+const RemoteAddr = "192.0.2.1:1234"
+
+// Result is a response as the client saw it: the parsed status line,
+// headers, decoded body and trailers, and the raw bytes they came from.
+type Result struct {
+	*response.Response
+	Raw []byte
+}
+
+// NewRequest builds a request as the server would have parsed it, with a
+// Host header and, for a non-empty body, a Content-Length. The fields in
+// extra, which may be nil, are added on top.
+func NewRequest(method, target string, body []byte, extra map[string]string) *request.Request {
+	h := headers.NewHeaders()
+	h.Override("host", "example.com")
+	if len(body) > 0 {
+		h.Override("content-length", strconv.Itoa(len(body)))
+	}
+	for key, value := range extra {
+		h.Override(key, value)
+	}
+	return &request.Request{
+		RequestLine:   request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:       h,
+		Body:          body,
+		RemoteAddr:    RemoteAddr,
+		RawBodyLength: len(body),
+	}
+}
+
+// Record runs handler against req with a response.Writer that writes to
+// memory, then parses what the handler wrote. Writers made this way cannot
+// be hijacked; use Do or Dial for handlers that need the connection.
+func Record(handler server.Handler, req *request.Request) (*Result, error) {
+	buf := &bytes.Buffer{}
+	handler(response.NewWriter(buf), req)
+	return parse(buf.Bytes(), req.RequestLine.Method)
+}
+
+// Run is Record for tests: it fails t if the response cannot be parsed.
+func Run(t testing.TB, handler server.Handler, req *request.Request) *Result {
+	t.Helper()
+	res, err := Record(handler, req)
+	if err != nil {
+		t.Fatalf("servertest: %v", err)
+	}
+	return res
+}
+
+// Do sends the raw request to handler over an in-memory connection, through
+// the same request parsing and connection handling as the real server, and
+// reads back the response.
+func Do(handler server.Handler, raw string) (*Result, error) {
+	conn := Dial(handler)
+	defer conn.Close()
+	go io.WriteString(conn, raw)
+
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		return nil, err
+	}
+	method, _, _ := strings.Cut(raw, " ")
+	return parse(got, method)
+}
+
+// Dial returns the client end of an in-memory connection whose server end
+// is served by handler. It is useful for handlers that hijack the
+// connection, like websocket upgrades.
+func Dial(handler server.Handler) net.Conn {
+	client, srv := net.Pipe()
+	go server.ServeConn(srv, handler)
+	return client
+}
+
+func parse(raw []byte, method string) (*Result, error) {
+	resp, rest, err := response.ReadResponse(bytes.NewReader(raw), method)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("handler wrote %d bytes after the response", len(rest))
+	}
+	return &Result{Response: resp, Raw: raw}, nil
+}
+
+// Server is a server.Server listening on a random local port.
+type Server struct {
+	*server.Server
+	// URL is the base URL of the server, e.g. "http://127.0.0.1:54321".
+	URL string
+	// Addr is the host:port to dial.
+	Addr string
+}
+
+// NewServer starts handler on a random port. The server is closed when the
+// test finishes.
+func NewServer(t testing.TB, handler server.Handler) *Server {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	if err != nil {
+		t.Fatalf("servertest: failed to start server: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	addr := fmt.Sprintf("127.0.0.1:%d", s.Port)
+	return &Server{Server: s, URL: "http://" + addr, Addr: addr}
+}
@@ -0,0 +1,106 @@
+package servertest
+
+import (
+	"bufio"
+	"io"
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/client"
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echo(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body))
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func chunked(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusOK)
+	h := response.GetDefaultHeaders(0)
+	h.Remove("content-length")
+	h.Override("transfer-encoding", "chunked")
+	w.WriteHeaders(h)
+	w.WriteChunkedBody([]byte("part one, "))
+	w.WriteChunkedBody([]byte("part two"))
+	w.WriteChunkedBodyDone()
+	w.WriteTrailers(headers.Headers{"x-parts": "2"})
+}
+
+func TestRecord(t *testing.T) {
+	res, err := Record(echo, NewRequest("POST", "/things", []byte("payload"), nil))
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "OK", res.StatusLine.ReasonPhrase)
+	assert.Equal(t, "text/html", res.Headers["content-type"])
+	assert.Equal(t, "POST /things payload", string(res.Body))
+	assert.Contains(t, string(res.Raw), "HTTP/1.1 200 OK\r\n")
+}
+
+func TestRecordChunkedWithTrailers(t *testing.T) {
+	res, err := Record(chunked, NewRequest("GET", "/", nil, nil))
+	require.NoError(t, err)
+	assert.Equal(t, "part one, part two", string(res.Body))
+	assert.Equal(t, "2", res.Trailers["x-parts"])
+}
+
+func TestRecordRejectsMalformedOutput(t *testing.T) {
+	_, err := Record(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(10))
+		w.WriteBody([]byte("short"))
+	}, NewRequest("GET", "/", nil, nil))
+	require.Error(t, err)
+}
+
+func TestDo(t *testing.T) {
+	res, err := Do(echo, "PUT /pipe HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nabc")
+	require.NoError(t, err)
+	assert.Equal(t, "PUT /pipe abc", string(res.Body))
+	assert.Equal(t, "close", res.Headers["connection"])
+}
+
+func TestDialHijackedConnection(t *testing.T) {
+	conn := Dial(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusSwitchingProtocols)
+		w.WriteHeaders(headers.Headers{"upgrade": "echo", "connection": "upgrade"})
+		c, br, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		line, _ := br.ReadString('\n')
+		io.WriteString(c, "echo: "+line)
+	})
+	defer conn.Close()
+
+	go io.WriteString(conn, "GET /upgrade HTTP/1.1\r\nHost: x\r\n\r\nhello\n")
+	br := bufio.NewReader(conn)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello\n", line)
+}
+
+func TestNewServer(t *testing.T) {
+	s := NewServer(t, echo)
+	resp, err := (&client.Client{}).Get(s.URL + "/over/tcp")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "GET /over/tcp ", string(body))
+}
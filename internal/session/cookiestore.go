@@ -0,0 +1,175 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxCookieSize is the longest cookie value a cookie store makes. Browsers
+// keep at least 4096 bytes per cookie, name and attributes included.
+const maxCookieSize = 3800
+
+// ErrCookieTooLarge is returned by the cookie stores when a session has more
+// data than fits in a cookie.
+var ErrCookieTooLarge = errors.New("session too large for a cookie")
+
+// SignedCookieStore keeps the whole session in the cookie, signed with
+// HMAC-SHA256 so the client cannot change it. The client can read it, so it
+// must not hold secrets; use EncryptedCookieStore for those.
+//
+// The server keeps nothing, so Delete cannot revoke a cookie: one copied
+// before Regenerate or Destroy is still accepted until the Expires inside
+// it, which the Manager's IdleTimeout and MaxLifetime bound. Use a
+// server-side store when logging out must end the session at once.
+type SignedCookieStore struct {
+	keys [][]byte
+}
+
+// NewSignedCookieStore returns a store that signs new cookies with keys[0]
+// and accepts cookies signed with any of keys, so keys can be rotated by
+// putting a new key first and dropping the old one once its cookies have
+// expired. Each key must be at least 32 bytes.
+func NewSignedCookieStore(keys ...[]byte) (*SignedCookieStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no session signing key")
+	}
+	for i, key := range keys {
+		if len(key) < 32 {
+			return nil, fmt.Errorf("session signing key %d must be at least 32 bytes", i)
+		}
+	}
+	return &SignedCookieStore{keys: keys}, nil
+}
+
+func (s *SignedCookieStore) Load(value string) (*Record, error) {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrNotFound
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	for _, key := range s.keys {
+		if hmac.Equal(mac, sign(key, payload)) {
+			data, err := base64.RawURLEncoding.DecodeString(payload)
+			if err != nil {
+				return nil, ErrNotFound
+			}
+			return decodeRecord(data)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *SignedCookieStore) Save(rec *Record) (string, error) {
+	if len(s.keys) == 0 {
+		return "", errors.New("SignedCookieStore must be made with NewSignedCookieStore")
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	value := payload + "." + base64.RawURLEncoding.EncodeToString(sign(s.keys[0], payload))
+	return checkCookieSize(value)
+}
+
+// Delete does nothing, as the store keeps nothing to delete. The Manager
+// deletes the cookie, but a copy of it stays valid; see SignedCookieStore.
+func (s *SignedCookieStore) Delete(value string) error {
+	return nil
+}
+
+func sign(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// EncryptedCookieStore keeps the whole session in the cookie, encrypted and
+// authenticated with AES-GCM, so the client can neither read nor change it.
+// Like SignedCookieStore, it cannot revoke a cookie before it expires.
+type EncryptedCookieStore struct {
+	aeads []cipher.AEAD
+}
+
+// NewEncryptedCookieStore returns a store that encrypts new cookies with
+// keys[0] and accepts cookies encrypted with any of keys, which allows key
+// rotation as for NewSignedCookieStore. Keys must be 16, 24 or 32 bytes, for
+// AES-128, AES-192 or AES-256.
+func NewEncryptedCookieStore(keys ...[]byte) (*EncryptedCookieStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no session encryption key")
+	}
+	aeads := make([]cipher.AEAD, len(keys))
+	for i, key := range keys {
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, fmt.Errorf("session encryption key %d: %w", i, err)
+		}
+		aeads[i] = aead
+	}
+	return &EncryptedCookieStore{aeads: aeads}, nil
+}
+
+func (s *EncryptedCookieStore) Load(value string) (*Record, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	for _, aead := range s.aeads {
+		if len(sealed) < aead.NonceSize() {
+			return nil, ErrNotFound
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if data, err := aead.Open(nil, nonce, ciphertext, nil); err == nil {
+			return decodeRecord(data)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *EncryptedCookieStore) Save(rec *Record) (string, error) {
+	if len(s.aeads) == 0 {
+		return "", errors.New("EncryptedCookieStore must be made with NewEncryptedCookieStore")
+	}
+	aead := s.aeads[0]
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return checkCookieSize(base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, data, nil)))
+}
+
+// Delete does nothing, as the store keeps nothing to delete.
+func (s *EncryptedCookieStore) Delete(value string) error {
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func checkCookieSize(value string) (string, error) {
+	if len(value) > maxCookieSize {
+		return "", ErrCookieTooLarge
+	}
+	return value, nil
+}
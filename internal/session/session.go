@@ -0,0 +1,318 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"maps"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/cookie"
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/server"
+)
+
+const (
+	// DefaultCookieName is the session cookie name when Manager.Cookie has
+	// none.
+	DefaultCookieName = "session"
+	// DefaultIdleTimeout ends sessions that have not been used for this long.
+	DefaultIdleTimeout = 30 * time.Minute
+	// DefaultMaxLifetime ends sessions this long after they began, however
+	// active they are.
+	DefaultMaxLifetime = 24 * time.Hour
+
+	// touchInterval is how stale the last access time may get before an
+	// unchanged session is saved again to push its idle expiry back.
+	touchInterval = time.Minute
+)
+
+// Manager is middleware that gives each request a session, kept in Store
+// and identified by a cookie. Handlers get it with Get, and must change it
+// before writing the response headers, which carry the updated cookie.
+type Manager struct {
+	Store Store
+	// Cookie is the template for the session cookie; Value, Expires and
+	// MaxAge are ignored. The name defaults to DefaultCookieName, the path
+	// to "/" and SameSite to Lax. HttpOnly is always set.
+	Cookie cookie.Cookie
+	// IdleTimeout is how long a session lasts without requests. Zero means
+	// DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// MaxLifetime is how long a session lasts at most. Zero means
+	// DefaultMaxLifetime.
+	MaxLifetime time.Duration
+
+	now func() time.Time
+}
+
+// Session is the state kept for one client across requests.
+type Session struct {
+	record *Record
+	// cookieValue is what the client sent, empty for a new session
+	cookieValue string
+	modified    bool
+	regenerate  bool
+	destroyed   bool
+}
+
+// Wrap returns a handler that loads the session of each request before
+// calling next and saves it when next writes the response headers.
+func (m *Manager) Wrap(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		s := m.load(req)
+		req.SetValue(sessionKey{m}, s)
+		next(response.NewFilterWriter(w, &sessionFilter{w: w, m: m, s: s}), req)
+	}
+}
+
+// sessionKey is the request value key for the session of a Manager, so
+// several Managers can serve the same request.
+type sessionKey struct{ m *Manager }
+
+// Get returns the session of a request passed through Wrap, or nil.
+func (m *Manager) Get(req *request.Request) *Session {
+	s, _ := req.Value(sessionKey{m}).(*Session)
+	return s
+}
+
+func (m *Manager) load(req *request.Request) *Session {
+	now := m.clock()
+	c, ok := cookie.Get(req, m.cookieName())
+	if !ok {
+		return newSession(now)
+	}
+	rec, err := m.Store.Load(c.Value)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Printf("Error loading session: %v", err)
+		}
+		return newSession(now)
+	}
+	if m.expired(rec, now) {
+		if err := m.Store.Delete(c.Value); err != nil {
+			log.Printf("Error deleting expired session: %v", err)
+		}
+		return newSession(now)
+	}
+	return &Session{record: rec, cookieValue: c.Value}
+}
+
+func (m *Manager) expired(rec *Record, now time.Time) bool {
+	return !now.Before(rec.Accessed.Add(m.idleTimeout())) ||
+		!now.Before(rec.Created.Add(m.maxLifetime())) ||
+		!now.Before(rec.Expires)
+}
+
+// commit saves s if it needs saving and returns the cookie to send, if any.
+func (m *Manager) commit(s *Session) (*cookie.Cookie, error) {
+	now := m.clock()
+	if s.destroyed {
+		if s.cookieValue == "" {
+			return nil, nil
+		}
+		if err := m.Store.Delete(s.cookieValue); err != nil {
+			return nil, err
+		}
+		c := m.cookie("")
+		c.MaxAge = -1
+		c.Expires = time.Unix(0, 0)
+		return c, nil
+	}
+	if s.regenerate {
+		if s.cookieValue != "" {
+			if err := m.Store.Delete(s.cookieValue); err != nil {
+				return nil, err
+			}
+		}
+		s.record.ID = newID()
+	} else if !s.modified && (s.cookieValue == "" || now.Sub(s.record.Accessed) < touchInterval) {
+		return nil, nil
+	}
+
+	s.record.Accessed = now
+	s.record.Expires = now.Add(m.idleTimeout())
+	if end := s.record.Created.Add(m.maxLifetime()); end.Before(s.record.Expires) {
+		s.record.Expires = end
+	}
+	value, err := m.Store.Save(s.record)
+	if err != nil {
+		return nil, err
+	}
+	s.cookieValue = value
+	s.modified, s.regenerate = false, false
+	c := m.cookie(value)
+	c.MaxAge = max(int(s.record.Expires.Sub(now).Seconds()), 1)
+	return c, nil
+}
+
+func (m *Manager) cookie(value string) *cookie.Cookie {
+	c := m.Cookie
+	c.Name = m.cookieName()
+	c.Value = value
+	c.Expires = time.Time{}
+	c.MaxAge = 0
+	c.HttpOnly = true
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.SameSite == cookie.SameSiteDefault {
+		c.SameSite = cookie.SameSiteLax
+	}
+	return &c
+}
+
+func (m *Manager) cookieName() string {
+	if m.Cookie.Name == "" {
+		return DefaultCookieName
+	}
+	return m.Cookie.Name
+}
+
+func (m *Manager) idleTimeout() time.Duration {
+	if m.IdleTimeout <= 0 {
+		return DefaultIdleTimeout
+	}
+	return m.IdleTimeout
+}
+
+func (m *Manager) maxLifetime() time.Duration {
+	if m.MaxLifetime <= 0 {
+		return DefaultMaxLifetime
+	}
+	return m.MaxLifetime
+}
+
+func (m *Manager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+func newSession(now time.Time) *Session {
+	return &Session{record: &Record{
+		ID:       newID(),
+		Values:   map[string]string{},
+		Created:  now,
+		Accessed: now,
+	}}
+}
+
+// newID returns a random session ID with 256 bits of entropy.
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ID returns the session ID. It changes when Regenerate is called.
+func (s *Session) ID() string {
+	return s.record.ID
+}
+
+// IsNew reports whether the client had no valid session before this request.
+func (s *Session) IsNew() bool {
+	return s.cookieValue == ""
+}
+
+// Created returns when the session began.
+func (s *Session) Created() time.Time {
+	return s.record.Created
+}
+
+// Get returns a value stored in the session.
+func (s *Session) Get(key string) (string, bool) {
+	value, ok := s.record.Values[key]
+	return value, ok
+}
+
+// Set stores a value in the session.
+func (s *Session) Set(key, value string) {
+	s.record.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes a value from the session.
+func (s *Session) Delete(key string) {
+	if _, ok := s.record.Values[key]; ok {
+		delete(s.record.Values, key)
+		s.modified = true
+	}
+}
+
+// Values returns a copy of the values stored in the session.
+func (s *Session) Values() map[string]string {
+	return maps.Clone(s.record.Values)
+}
+
+// Regenerate gives the session a new ID, keeping its values, and drops the
+// old one from the store. Call it when the user logs in or gains
+// privileges, so an ID planted by an attacker before then is useless.
+func (s *Session) Regenerate() {
+	s.regenerate = true
+}
+
+// Destroy ends the session: it is removed from the store and the cookie is
+// deleted.
+func (s *Session) Destroy() {
+	s.destroyed = true
+	clear(s.record.Values)
+}
+
+// sessionFilter adds the session cookie to the response headers.
+type sessionFilter struct {
+	w *response.Writer
+	m *Manager
+	s *Session
+}
+
+func (f *sessionFilter) WriteStatusLine(statusCode response.StatusCode) error {
+	return f.w.WriteStatusLine(statusCode)
+}
+
+func (f *sessionFilter) WriteHeaders(h headers.Headers) error {
+	c, err := f.m.commit(f.s)
+	if err != nil {
+		log.Printf("Error saving session: %v", err)
+	}
+	if c != nil {
+		if err := cookie.Set(h, c); err != nil {
+			log.Printf("Error setting session cookie: %v", err)
+		}
+		// a response that sets a session cookie must not be shared
+		h.Override("Cache-Control", "no-store")
+	}
+	return f.w.WriteHeaders(h)
+}
+
+func (f *sessionFilter) WriteBody(p []byte) (int, error) {
+	return f.w.WriteBody(p)
+}
+
+// ReadFrom keeps the zero-copy path of the underlying Writer.
+func (f *sessionFilter) ReadFrom(r io.Reader) (int64, error) {
+	return f.w.ReadFrom(r)
+}
+
+func (f *sessionFilter) WriteChunkedBody(p []byte) (int, error) {
+	return f.w.WriteChunkedBody(p)
+}
+
+func (f *sessionFilter) WriteChunkedBodyDone() (int, error) {
+	return f.w.WriteChunkedBodyDone()
+}
+
+func (f *sessionFilter) WriteTrailers(h headers.Headers) error {
+	return f.w.WriteTrailers(h)
+}
+
+func (f *sessionFilter) Flush() error {
+	return f.w.Flush()
+}
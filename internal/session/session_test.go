@@ -0,0 +1,275 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/cookie"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/jonvanw/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func signedStore(t *testing.T, keys ...[]byte) *SignedCookieStore {
+	s, err := NewSignedCookieStore(keys...)
+	require.NoError(t, err)
+	return s
+}
+
+func encryptedStore(t *testing.T, keys ...[]byte) *EncryptedCookieStore {
+	s, err := NewEncryptedCookieStore(keys...)
+	require.NoError(t, err)
+	return s
+}
+
+func stores(t *testing.T) map[string]Store {
+	return map[string]Store{
+		"signed":    signedStore(t, key1),
+		"encrypted": encryptedStore(t, key1),
+		"memory":    &MemoryStore{},
+		"file":      &FileStore{Dir: t.TempDir()},
+	}
+}
+
+// do sends a request carrying the session cookie value, if any, through m
+// to handle, and returns the response with the cookie value it set.
+func do(t *testing.T, m *Manager, value string, handle func(s *Session)) (*servertest.Result, string) {
+	t.Helper()
+	var h map[string]string
+	if value != "" {
+		h = map[string]string{"cookie": "other=1; " + m.cookieName() + "=" + value}
+	}
+	res := servertest.Run(t, m.Wrap(func(w *response.Writer, req *request.Request) {
+		handle(m.Get(req))
+		require.NoError(t, w.WriteStatusLine(response.StatusOK))
+		require.NoError(t, w.WriteHeaders(response.GetDefaultHeaders(0)))
+	}), servertest.NewRequest("GET", "/", nil, h))
+	setCookie, ok := res.Headers.Get("set-cookie")
+	if !ok {
+		return res, ""
+	}
+	pair, _, _ := strings.Cut(setCookie, ";")
+	return res, strings.TrimPrefix(pair, m.cookieName()+"=")
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			m := &Manager{Store: store}
+			res, value := do(t, m, "", func(s *Session) {
+				assert.True(t, s.IsNew())
+				s.Set("user", "ada")
+			})
+			require.NotEmpty(t, value)
+			setCookie, _ := res.Headers.Get("set-cookie")
+			assert.Contains(t, setCookie, "; Max-Age=1800; Path=/; HttpOnly; SameSite=Lax")
+			assert.Equal(t, "no-store", res.Headers["cache-control"])
+
+			var id string
+			_, again := do(t, m, value, func(s *Session) {
+				assert.False(t, s.IsNew())
+				user, ok := s.Get("user")
+				assert.True(t, ok)
+				assert.Equal(t, "ada", user)
+				id = s.ID()
+			})
+			assert.Empty(t, again, "an unchanged session is not sent again")
+			assert.NotEmpty(t, id)
+		})
+	}
+}
+
+func TestUnchangedNewSessionSetsNoCookie(t *testing.T) {
+	m := &Manager{Store: &MemoryStore{}}
+	res, value := do(t, m, "", func(s *Session) {})
+	assert.Empty(t, value)
+	assert.NotContains(t, res.Headers, "cache-control")
+	assert.Equal(t, 0, m.Store.(*MemoryStore).Len())
+}
+
+func TestForgedCookiesStartNewSessions(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			m := &Manager{Store: store}
+			_, value := do(t, m, "", func(s *Session) { s.Set("role", "user") })
+			// flip the first character, whatever it is
+			first := "x"
+			if value[0] == 'x' {
+				first = "y"
+			}
+			for _, forged := range []string{first + value[1:], value[:len(value)-2], "../../etc/passwd", "a.b"} {
+				do(t, m, forged, func(s *Session) {
+					assert.True(t, s.IsNew(), forged)
+					_, ok := s.Get("role")
+					assert.False(t, ok)
+				})
+			}
+		})
+	}
+}
+
+func TestEncryptedCookieHidesValues(t *testing.T) {
+	_, value := do(t, &Manager{Store: encryptedStore(t, key1)}, "", func(s *Session) { s.Set("secret", "hunter2") })
+	require.NotEmpty(t, value)
+	assert.NotContains(t, value, ".")
+	rec, err := signedStore(t, key1).Load(value)
+	assert.Nil(t, rec)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestKeyRotation(t *testing.T) {
+	for name, newStore := range map[string]func(keys ...[]byte) Store{
+		"signed":    func(keys ...[]byte) Store { return signedStore(t, keys...) },
+		"encrypted": func(keys ...[]byte) Store { return encryptedStore(t, keys...) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, value := do(t, &Manager{Store: newStore(key1)}, "", func(s *Session) { s.Set("user", "ada") })
+
+			// the old key still opens old cookies, and the next save uses the new one
+			rotated := &Manager{Store: newStore(key2, key1)}
+			_, resaved := do(t, rotated, value, func(s *Session) {
+				assert.False(t, s.IsNew())
+				s.Set("seen", "yes")
+			})
+			require.NotEmpty(t, resaved)
+
+			retired := &Manager{Store: newStore(key2)}
+			do(t, retired, value, func(s *Session) { assert.True(t, s.IsNew()) })
+			do(t, retired, resaved, func(s *Session) {
+				user, _ := s.Get("user")
+				assert.Equal(t, "ada", user)
+			})
+		})
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			m := &Manager{Store: store, IdleTimeout: 10 * time.Minute, MaxLifetime: time.Hour}
+			m.now = func() time.Time { return now }
+
+			_, value := do(t, m, "", func(s *Session) { s.Set("user", "ada") })
+			// each request within the idle timeout pushes the expiry back
+			for i := 0; i < 5; i++ {
+				now = now.Add(9 * time.Minute)
+				_, touched := do(t, m, value, func(s *Session) { assert.False(t, s.IsNew(), "after %d touches", i) })
+				if touched != "" {
+					value = touched
+				}
+			}
+
+			// the absolute lifetime caps it, however active the session is
+			now = now.Add(9 * time.Minute)
+			res, touched := do(t, m, value, func(s *Session) { assert.False(t, s.IsNew()) })
+			assert.Contains(t, res.Headers["set-cookie"], "Max-Age=360;")
+			value = touched
+			now = now.Add(8 * time.Minute)
+			do(t, m, value, func(s *Session) { assert.True(t, s.IsNew()) })
+
+			// and an idle session ends after the idle timeout
+			now = now.Add(time.Minute)
+			_, value = do(t, m, "", func(s *Session) { s.Set("user", "bob") })
+			now = now.Add(10 * time.Minute)
+			do(t, m, value, func(s *Session) { assert.True(t, s.IsNew()) })
+		})
+	}
+}
+
+func TestExpiryFollowsManagerClock(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			// a clock well behind the wall clock: every record the Manager
+			// saves has Expires in the real past
+			now := time.Now().Add(-24 * time.Hour)
+			m := &Manager{Store: store, IdleTimeout: 10 * time.Minute}
+			m.now = func() time.Time { return now }
+
+			_, value := do(t, m, "", func(s *Session) { s.Set("user", "ada") })
+			now = now.Add(5 * time.Minute)
+			do(t, m, value, func(s *Session) { assert.False(t, s.IsNew()) })
+		})
+	}
+}
+
+func TestRegenerate(t *testing.T) {
+	store := &MemoryStore{}
+	m := &Manager{Store: store}
+	var oldID, newID string
+	_, value := do(t, m, "", func(s *Session) {
+		s.Set("cart", "3 items")
+		oldID = s.ID()
+	})
+	_, regenerated := do(t, m, value, func(s *Session) {
+		s.Regenerate()
+		s.Set("user", "ada")
+	})
+	require.NotEmpty(t, regenerated)
+	assert.NotEqual(t, value, regenerated)
+
+	do(t, m, value, func(s *Session) { assert.True(t, s.IsNew(), "the old ID is gone") })
+	do(t, m, regenerated, func(s *Session) {
+		newID = s.ID()
+		assert.Equal(t, map[string]string{"cart": "3 items", "user": "ada"}, s.Values())
+	})
+	assert.NotEqual(t, oldID, newID)
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestDestroy(t *testing.T) {
+	store := &MemoryStore{}
+	m := &Manager{Store: store, Cookie: cookie.Cookie{Name: "sid", Domain: "example.com", Secure: true, SameSite: cookie.SameSiteStrict}}
+	_, value := do(t, m, "", func(s *Session) { s.Set("user", "ada") })
+	require.Equal(t, 1, store.Len())
+
+	res, _ := do(t, m, value, func(s *Session) { s.Destroy() })
+	assert.Equal(t, 0, store.Len())
+	assert.Equal(t, "sid=; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; Domain=example.com; Path=/; Secure; HttpOnly; SameSite=Strict", res.Headers["set-cookie"])
+}
+
+func TestCookieTooLarge(t *testing.T) {
+	m := &Manager{Store: signedStore(t, key1)}
+	_, value := do(t, m, "", func(s *Session) { s.Set("big", strings.Repeat("x", 4000)) })
+	assert.Empty(t, value)
+}
+
+func TestCookieStoreKeys(t *testing.T) {
+	// Test: every key is checked up front, not only the one that signs
+	for _, keys := range [][][]byte{nil, {[]byte("short")}, {key1, nil}, {key1, []byte("short")}} {
+		_, err := NewSignedCookieStore(keys...)
+		assert.Error(t, err, "%q", keys)
+		_, err = NewEncryptedCookieStore(keys...)
+		assert.Error(t, err, "%q", keys)
+	}
+
+	// Test: a store made without its constructor has no keys to accept
+	value, err := signedStore(t, key1).Save(&Record{ID: "x"})
+	require.NoError(t, err)
+	_, err = (&SignedCookieStore{}).Load(value)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = (&SignedCookieStore{}).Save(&Record{})
+	assert.ErrorContains(t, err, "NewSignedCookieStore")
+	_, err = (&EncryptedCookieStore{}).Save(&Record{})
+	assert.ErrorContains(t, err, "NewEncryptedCookieStore")
+}
+
+func TestCookieStoreCannotRevoke(t *testing.T) {
+	// Test: a cookie copied before logout still loads, until it expires
+	m := &Manager{Store: signedStore(t, key1)}
+	_, value := do(t, m, "", func(s *Session) { s.Set("user", "ada") })
+	do(t, m, value, func(s *Session) { s.Destroy() })
+	do(t, m, value, func(s *Session) {
+		user, _ := s.Get("user")
+		assert.Equal(t, "ada", user)
+	})
+}
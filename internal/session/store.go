@@ -0,0 +1,233 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Store.Load for a session that does not exist
+// or whose cookie could not be verified.
+var ErrNotFound = errors.New("session not found")
+
+// Record is the stored form of a session.
+type Record struct {
+	ID       string            `json:"id"`
+	Values   map[string]string `json:"values"`
+	Created  time.Time         `json:"created"`
+	Accessed time.Time         `json:"accessed"`
+	// Expires is when the store may forget the session.
+	Expires time.Time `json:"expires"`
+}
+
+// Store keeps session records. Load and Delete take the cookie value that
+// Save returned: the session ID for server-side stores, or the encoded
+// record itself for cookie stores. Load does not check Expires; the Manager
+// does, against its own clock. Stores may use Expires to clean up.
+type Store interface {
+	Load(value string) (*Record, error)
+	Save(rec *Record) (string, error)
+	Delete(value string) error
+}
+
+// sweepInterval is how often MemoryStore drops expired sessions.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps sessions in memory, so they are lost on restart and not
+// shared between processes. The zero value is ready to use.
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	// data is the encoded record, so handlers never share the stored one
+	data    []byte
+	expires time.Time
+}
+
+func (s *MemoryStore) Load(id string) (*Record, error) {
+	s.mu.Lock()
+	entry, ok := s.sessions[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return decodeRecord(entry.data)
+}
+
+func (s *MemoryStore) Save(rec *Record) (string, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = map[string]memoryEntry{}
+	}
+	if now.Sub(s.lastSweep) > sweepInterval {
+		s.deleteExpired(now)
+	}
+	s.sessions[rec.ID] = memoryEntry{data: data, expires: rec.Expires}
+	return rec.ID, nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// Len returns the number of sessions held, including expired ones not yet
+// dropped.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+func (s *MemoryStore) deleteExpired(now time.Time) {
+	for id, entry := range s.sessions {
+		if !now.Before(entry.expires) {
+			delete(s.sessions, id)
+		}
+	}
+	s.lastSweep = now
+}
+
+// FileStore keeps each session in a JSON file in Dir, which must exist. Run
+// DeleteExpired now and then to clear out sessions that were abandoned.
+type FileStore struct {
+	Dir string
+}
+
+func (s *FileStore) Load(id string) (*Record, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeRecord(data)
+}
+
+// Save writes the record to a temporary file and renames it into place, so
+// a concurrent Load never sees half of it.
+func (s *FileStore) Save(rec *Record) (string, error) {
+	path, err := s.path(rec.ID)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(s.Dir, ".session-")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return rec.ID, nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, err := s.path(id)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// DeleteExpired removes the files of sessions that expired before now.
+func (s *FileStore) DeleteExpired() error {
+	now := time.Now()
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+		rec, err := s.Load(id)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if !now.Before(rec.Expires) {
+			if err := s.Delete(id); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// path returns the file for a session ID. IDs come from clients, so any
+// that newID could not have made are turned away before touching the disk.
+func (s *FileStore) path(id string) (string, error) {
+	if !validID(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.Dir, id+".json"), nil
+}
+
+// validID reports whether id has the form of a newID result.
+func validID(id string) bool {
+	if len(id) != 43 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeRecord(data []byte) (*Record, error) {
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("invalid session record: %w", err)
+	}
+	if rec.Values == nil {
+		rec.Values = map[string]string{}
+	}
+	return &rec, nil
+}
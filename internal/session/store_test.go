@@ -0,0 +1,76 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func record(expires time.Time) *Record {
+	return &Record{ID: newID(), Values: map[string]string{"k": "v"}, Created: time.Now(), Accessed: time.Now(), Expires: expires}
+}
+
+func TestStoresLeaveExpiryToManager(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			live, err := store.Save(record(time.Now().Add(time.Hour)))
+			require.NoError(t, err)
+			dead, err := store.Save(record(time.Now().Add(-time.Second)))
+			require.NoError(t, err)
+
+			rec, err := store.Load(live)
+			require.NoError(t, err)
+			assert.Equal(t, "v", rec.Values["k"])
+			// whether dead has expired is up to the Manager's clock
+			rec, err = store.Load(dead)
+			require.NoError(t, err)
+			assert.Equal(t, "v", rec.Values["k"])
+
+			require.NoError(t, store.Delete(live))
+			require.NoError(t, store.Delete(live))
+		})
+	}
+}
+
+func TestMemoryStoreCopiesRecords(t *testing.T) {
+	store := &MemoryStore{}
+	rec := record(time.Now().Add(time.Hour))
+	id, err := store.Save(rec)
+	require.NoError(t, err)
+	rec.Values["k"] = "changed"
+	loaded, err := store.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, "v", loaded.Values["k"])
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store := &FileStore{Dir: dir}
+	live, err := store.Save(record(time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	_, err = store.Save(record(time.Now().Add(-time.Second)))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600))
+
+	require.NoError(t, store.DeleteExpired())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{live + ".json", "notes.txt"}, names)
+
+	// IDs from clients never reach the file system unless newID could have made them
+	for _, id := range []string{"../notes", "notes", live[:42] + "/"} {
+		_, err := store.Load(id)
+		assert.ErrorIs(t, err, ErrNotFound, id)
+		assert.NoError(t, store.Delete(id))
+	}
+	_, err = store.Save(&Record{ID: "../escape"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
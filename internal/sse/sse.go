@@ -0,0 +1,199 @@
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+var ErrStreamClosed = errors.New("sse: stream closed")
+
+// Event is a single server-sent event. Empty fields are omitted from the wire.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// Stream writes text/event-stream events over a chunked response.
+type Stream struct {
+	// LastEventID is the Last-Event-ID sent by a reconnecting client, if any.
+	LastEventID string
+
+	w         *response.Writer
+	mu        sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// LastEventID returns the Last-Event-ID header of the request, or "" if the
+// client did not send one.
+func LastEventID(req *request.Request) string {
+	id, _ := req.Headers.Get("Last-Event-ID")
+	return id
+}
+
+// NewStream writes the status line and event-stream headers and returns a
+// Stream ready to send events.
+func NewStream(w *response.Writer, req *request.Request) (*Stream, error) {
+	err := w.WriteStatusLine(response.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	h := response.GetDefaultHeaders(0)
+	h.Remove("content-length")
+	h.Override("content-type", "text/event-stream")
+	h.Override("cache-control", "no-cache")
+	h.Override("transfer-encoding", "chunked")
+	// stops nginx and friends from buffering the stream
+	h.Override("x-accel-buffering", "no")
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{
+		LastEventID: LastEventID(req),
+		w:           w,
+		done:        make(chan struct{}),
+	}
+	if err := w.Flush(); err != nil {
+		s.fail(err)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Send writes a single event and flushes it to the client.
+func (s *Stream) Send(e Event) error {
+	return s.write(formatEvent(e))
+}
+
+// Comment writes a comment line, which clients ignore. Useful as a keep-alive.
+func (s *Stream) Comment(text string) error {
+	var sb strings.Builder
+	for _, line := range splitLines(text) {
+		sb.WriteString(": ")
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+	return s.write(sb.String())
+}
+
+// Heartbeat sends a comment every interval until the stream is closed or the
+// client goes away, so idle proxies do not time out the connection.
+func (s *Stream) Heartbeat(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				if err := s.Comment("heartbeat"); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Done is closed when the stream is closed or a write to the client fails,
+// which is how a client disconnect surfaces.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the write error that ended the stream, if any.
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close terminates the chunked body. It is safe to call after a disconnect.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return s.err
+	default:
+	}
+	s.closeOnce.Do(func() { close(s.done) })
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	if err := s.w.WriteTrailers(headers.NewHeaders()); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *Stream) write(data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		if s.err != nil {
+			return s.err
+		}
+		return ErrStreamClosed
+	default:
+	}
+	_, err := s.w.WriteChunkedBody([]byte(data))
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if err != nil {
+		s.fail(err)
+		return err
+	}
+	return nil
+}
+
+// fail records err and ends the stream. The caller must hold s.mu unless the
+// stream has not been shared yet.
+func (s *Stream) fail(err error) {
+	s.err = err
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+func formatEvent(e Event) string {
+	var sb strings.Builder
+	if e.ID != "" {
+		sb.WriteString("id: " + stripNewlines(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		sb.WriteString("event: " + stripNewlines(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		sb.WriteString(fmt.Sprintf("retry: %d\n", e.Retry.Milliseconds()))
+	}
+	for _, line := range splitLines(e.Data) {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// splitLines splits on any of the line endings the event-stream format
+// recognises: CRLF, LF or a lone CR.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
+
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
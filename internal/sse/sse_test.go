@@ -0,0 +1,113 @@
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatEventAllFields(t *testing.T) {
+	e := Event{ID: "7", Event: "update", Data: "hello", Retry: 3 * time.Second}
+	assert.Equal(t, "id: 7\nevent: update\nretry: 3000\ndata: hello\n\n", formatEvent(e))
+}
+
+func TestFormatEventMultilineData(t *testing.T) {
+	// Test: every line ending style becomes its own data field
+	e := Event{Data: "one\ntwo\r\nthree\rfour"}
+	assert.Equal(t, "data: one\ndata: two\ndata: three\ndata: four\n\n", formatEvent(e))
+}
+
+func TestFormatEventStripsNewlinesFromID(t *testing.T) {
+	e := Event{ID: "a\nb", Data: "x"}
+	assert.Equal(t, "id: ab\ndata: x\n\n", formatEvent(e))
+}
+
+func TestStreamWritesHeadersAndEvents(t *testing.T) {
+	buf := &bytes.Buffer{}
+	req := &request.Request{Headers: headers.Headers{"last-event-id": "41"}}
+	s, err := NewStream(response.NewWriter(buf), req)
+	require.NoError(t, err)
+	assert.Equal(t, "41", s.LastEventID)
+
+	require.NoError(t, s.Send(Event{ID: "42", Data: "hi"}))
+	require.NoError(t, s.Close())
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/event-stream\r\n")
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n11\r\nid: 42\ndata: hi\n\n\r\n0\r\n\r\n"))
+
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("expected Done to be closed after Close")
+	}
+	assert.ErrorIs(t, s.Send(Event{Data: "late"}), ErrStreamClosed)
+}
+
+func TestStreamHeartbeat(t *testing.T) {
+	w := &syncBuffer{}
+	s, err := NewStream(response.NewWriter(w), &request.Request{Headers: headers.NewHeaders()})
+	require.NoError(t, err)
+	s.Heartbeat(5 * time.Millisecond)
+	require.Eventually(t, func() bool {
+		return strings.Contains(w.String(), ": heartbeat\n\n")
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, s.Close())
+}
+
+func TestStreamEndsOnClientDisconnect(t *testing.T) {
+	w := &failingWriter{}
+	s, err := NewStream(response.NewWriter(w), &request.Request{Headers: headers.NewHeaders()})
+	require.NoError(t, err)
+
+	w.fail = true
+	err = s.Send(Event{Data: "lost"})
+	require.Error(t, err)
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("expected Done to be closed after a failed write")
+	}
+	assert.Equal(t, err, s.Err())
+	assert.Equal(t, err, s.Close())
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// failingWriter simulates a connection that breaks once fail is set
+type failingWriter struct {
+	fail bool
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.fail {
+		return 0, errors.New("broken pipe")
+	}
+	return len(p), nil
+}
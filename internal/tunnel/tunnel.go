@@ -0,0 +1,219 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+const (
+	DefaultDialTimeout = 10 * time.Second
+	DefaultIdleTimeout = 2 * time.Minute
+)
+
+// Stats describes a finished tunnel.
+type Stats struct {
+	Destination string
+	// BytesUp counts bytes sent from the client to the destination,
+	// BytesDown the bytes sent back.
+	BytesUp   int64
+	BytesDown int64
+	Duration  time.Duration
+}
+
+// Proxy serves CONNECT requests by dialling the requested host:port and
+// splicing bytes in both directions until either side closes or the tunnel
+// sits idle for too long.
+type Proxy struct {
+	// Allow lists the destinations clients may reach. Entries are "host:port",
+	// "host" (any port), "host:*" or "*.domain" patterns, or "*" for any
+	// destination. An empty list allows none, so the proxy is never open by
+	// accident.
+	Allow []string
+	// DialTimeout bounds connecting to the destination. Zero means
+	// DefaultDialTimeout.
+	DialTimeout time.Duration
+	// IdleTimeout closes the tunnel when no bytes flow in either direction for
+	// this long. Zero means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// OnClose, if set, is called with the byte counters of each tunnel.
+	OnClose func(Stats)
+}
+
+// Handle is a server.Handler for CONNECT requests.
+func (p *Proxy) Handle(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "CONNECT" {
+		response.WriteError(w, response.StatusMethodNotAllowed, nil, "only CONNECT is supported")
+		return
+	}
+	dest := req.RequestLine.RequestTarget
+	if !p.allowed(dest) {
+		response.WriteError(w, response.StatusForbidden, nil, fmt.Sprintf("destination %s is not allowed", dest))
+		return
+	}
+	if !w.CanHijack() {
+		response.WriteError(w, response.StatusInternalServerError, nil, "connection cannot be tunnelled")
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", dest, p.dialTimeout())
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			response.WriteError(w, response.StatusGatewayTimeout, nil, fmt.Sprintf("timed out connecting to %s", dest))
+		} else {
+			response.WriteError(w, response.StatusBadGateway, nil, fmt.Sprintf("cannot connect to %s", dest))
+		}
+		return
+	}
+	defer upstream.Close()
+
+	// a 2xx reply to CONNECT carries no body framing headers at all
+	err = w.WriteStatusLine(response.StatusOK)
+	if err != nil {
+		return
+	}
+	err = w.WriteHeaders(headers.NewHeaders())
+	if err != nil {
+		return
+	}
+	client, clientReader, err := w.Hijack()
+	if err != nil {
+		log.Printf("Error hijacking connection for tunnel: %v", err)
+		return
+	}
+	defer client.Close()
+
+	start := time.Now()
+	stats := Stats{Destination: dest}
+	stats.BytesUp, stats.BytesDown = p.splice(client, clientReader, upstream)
+	stats.Duration = time.Since(start)
+	if p.OnClose != nil {
+		p.OnClose(stats)
+	}
+}
+
+// splice copies client to upstream and back until both directions finish,
+// returning the byte counts of each.
+func (p *Proxy) splice(client net.Conn, clientReader io.Reader, upstream net.Conn) (up, down int64) {
+	idle := p.idleTimeout()
+	// lastActive is shared by both directions, so a download with nothing
+	// sent back does not count as idle
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		up = copyWithIdleTimeout(upstream, clientReader, client, idle, &lastActive)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		down = copyWithIdleTimeout(client, upstream, upstream, idle, &lastActive)
+		closeWrite(client)
+	}()
+	wg.Wait()
+	return up, down
+}
+
+// copyWithIdleTimeout copies src to dst, keeping conn's read deadline idle
+// after the last activity in either direction. When the deadline passes and
+// neither direction has moved since, both are torn down.
+func copyWithIdleTimeout(dst net.Conn, src io.Reader, conn net.Conn, idle time.Duration, lastActive *atomic.Int64) int64 {
+	buf := make([]byte, 32*1024)
+	var total int64
+	for {
+		conn.SetReadDeadline(time.Unix(0, lastActive.Load()).Add(idle))
+		n, err := src.Read(buf)
+		if n > 0 {
+			lastActive.Store(time.Now().UnixNano())
+			dst.SetWriteDeadline(time.Now().Add(idle))
+			written, werr := dst.Write(buf[:n])
+			total += int64(written)
+			if werr != nil {
+				conn.Close()
+				return total
+			}
+		}
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				if time.Since(time.Unix(0, lastActive.Load())) < idle {
+					// the other direction was busy: wait out the rest
+					continue
+				}
+				// idle: make sure the other direction stops as well
+				conn.Close()
+				dst.Close()
+			}
+			return total
+		}
+	}
+}
+
+// closeWrite half-closes conn so the peer sees EOF while the other direction
+// keeps flowing.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+func (p *Proxy) allowed(dest string) bool {
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(host)
+	for _, entry := range p.Allow {
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			// no port in the entry: any port is fine
+			entryHost, entryPort = entry, "*"
+		}
+		if entryPort != "*" && entryPort != port {
+			continue
+		}
+		if matchHost(strings.ToLower(entryHost), host) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchHost(pattern, host string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
+
+func (p *Proxy) dialTimeout() time.Duration {
+	if p.DialTimeout > 0 {
+		return p.DialTimeout
+	}
+	return DefaultDialTimeout
+}
+
+func (p *Proxy) idleTimeout() time.Duration {
+	if p.IdleTimeout > 0 {
+		return p.IdleTimeout
+	}
+	return DefaultIdleTimeout
+}
@@ -0,0 +1,176 @@
+package tunnel
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTunnelSplicesBothDirections(t *testing.T) {
+	dest := startEchoServer(t)
+	stats := make(chan Stats, 1)
+	p := &Proxy{Allow: []string{"127.0.0.1"}, OnClose: func(s Stats) { stats <- s }}
+
+	client, br := connect(t, p, dest)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+	blank, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank)
+
+	_, err = client.Write([]byte("ping through the tunnel"))
+	require.NoError(t, err)
+	client.(*net.TCPConn).CloseWrite()
+	echoed, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Equal(t, "ping through the tunnel", string(echoed))
+
+	s := <-stats
+	assert.Equal(t, dest, s.Destination)
+	assert.Equal(t, int64(23), s.BytesUp)
+	assert.Equal(t, int64(23), s.BytesDown)
+}
+
+func TestTunnelIdleTimeout(t *testing.T) {
+	dest := startEchoServer(t)
+	stats := make(chan Stats, 1)
+	p := &Proxy{Allow: []string{"127.0.0.1"}, IdleTimeout: 50 * time.Millisecond, OnClose: func(s Stats) { stats <- s }}
+
+	_, br := connect(t, p, dest)
+	_, err := io.ReadAll(br)
+	require.NoError(t, err)
+	select {
+	case s := <-stats:
+		assert.Equal(t, int64(0), s.BytesUp)
+	case <-time.After(2 * time.Second):
+		t.Fatal("tunnel did not close after going idle")
+	}
+}
+
+func TestTunnelOneWayTrafficIsNotIdle(t *testing.T) {
+	// the destination streams for several idle timeouts without the client
+	// sending anything
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; i < 10; i++ {
+			time.Sleep(20 * time.Millisecond)
+			conn.Write([]byte("x"))
+		}
+	}()
+	p := &Proxy{Allow: []string{"127.0.0.1"}, IdleTimeout: 60 * time.Millisecond}
+
+	_, br := connect(t, p, ln.Addr().String())
+	_, err = br.ReadString('\n')
+	require.NoError(t, err)
+	_, err = br.ReadString('\n')
+	require.NoError(t, err)
+	body, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Equal(t, "xxxxxxxxxx", string(body))
+}
+
+func TestTunnelRejectsDestinationNotOnAllowList(t *testing.T) {
+	dest := startEchoServer(t)
+	p := &Proxy{Allow: []string{"example.com:443"}}
+	_, br := connect(t, p, dest)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", status)
+}
+
+func TestTunnelDialFailure(t *testing.T) {
+	// grab a free port, then close it so nothing is listening there
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dest := ln.Addr().String()
+	ln.Close()
+
+	_, br := connect(t, &Proxy{Allow: []string{"*"}}, dest)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 502 Bad Gateway\r\n", status)
+}
+
+func TestAllowList(t *testing.T) {
+	p := &Proxy{Allow: []string{"example.com:443", "localhost", "*.internal.test", "10.0.0.1:*"}}
+	assert.True(t, p.allowed("example.com:443"))
+	assert.True(t, p.allowed("EXAMPLE.com:443"))
+	assert.False(t, p.allowed("example.com:80"))
+	assert.True(t, p.allowed("localhost:8080"))
+	assert.True(t, p.allowed("api.internal.test:443"))
+	assert.False(t, p.allowed("internal.test:443"))
+	assert.True(t, p.allowed("10.0.0.1:22"))
+	assert.False(t, p.allowed("10.0.0.2:22"))
+
+	// Test: an empty list allows nothing; only a wildcard opens the proxy up
+	assert.False(t, (&Proxy{}).allowed("example.com:443"))
+	assert.True(t, (&Proxy{Allow: []string{"*"}}).allowed("example.com:443"))
+	assert.True(t, (&Proxy{Allow: []string{"*:443"}}).allowed("example.com:443"))
+	assert.False(t, (&Proxy{Allow: []string{"*:443"}}).allowed("example.com:80"))
+}
+
+// connect runs the proxy against a real TCP connection, as if the client had
+// sent "CONNECT dest", and returns the client side of it.
+func connect(t *testing.T, p *Proxy, dest string) (net.Conn, *bufio.Reader) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		req, err := request.RequestFromReader(strings.NewReader("CONNECT " + dest + " HTTP/1.1\r\nHost: " + dest + "\r\n\r\n"))
+		if err != nil {
+			conn.Close()
+			return
+		}
+		w := response.NewConnWriter(conn, bufio.NewReader(conn))
+		p.Handle(w, req)
+		if !w.Hijacked() {
+			w.Flush()
+			conn.Close()
+		}
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client, bufio.NewReader(client)
+}
+
+func startEchoServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
@@ -0,0 +1,415 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xA
+)
+
+// Close status codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+const DefaultMaxMessageSize = 1 << 20
+
+// closeTimeout bounds how long Close waits for the peer to answer.
+const closeTimeout = 5 * time.Second
+
+const maxControlPayload = 125
+
+var ErrCloseSent = errors.New("websocket: close frame already sent")
+
+// CloseError is returned by ReadMessage once the peer closes the connection,
+// or once we close it because the peer broke the protocol.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with status %d: %s", e.Code, e.Reason)
+}
+
+type Conn struct {
+	// MaxMessageSize caps the size of a reassembled message. Larger messages
+	// close the connection with status 1009. Zero or less disables the limit.
+	MaxMessageSize int64
+	// WriteFragmentSize splits outgoing messages into frames of at most this
+	// many bytes. Zero sends every message as a single frame.
+	WriteFragmentSize int
+	// PongHandler, if set, is called with the payload of each pong received.
+	PongHandler func(data []byte)
+
+	conn     net.Conn
+	br       *bufio.Reader
+	isServer bool
+
+	writeMu   sync.Mutex
+	closeSent bool
+	closeErr  *CloseError
+}
+
+func newConn(c net.Conn, br *bufio.Reader, isServer bool) *Conn {
+	return &Conn{
+		MaxMessageSize: DefaultMaxMessageSize,
+		conn:           c,
+		br:             br,
+		isServer:       isServer,
+	}
+}
+
+// NetConn returns the underlying network connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// ReadMessage returns the next complete data message, reassembling fragments
+// and answering pings along the way. Once the peer closes the connection it
+// returns a *CloseError.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.closeErr != nil {
+		return 0, nil, c.closeErr
+	}
+	var msgType MessageType
+	var msg []byte
+	inMessage := false
+	for {
+		limit := int64(-1)
+		if c.MaxMessageSize > 0 {
+			limit = c.MaxMessageSize - int64(len(msg))
+		}
+		f, err := c.readFrame(limit)
+		if err != nil {
+			return 0, nil, c.handleReadError(err)
+		}
+		switch f.opcode {
+		case opPing:
+			err := c.writeFrame(true, opPong, f.payload)
+			if err != nil && !errors.Is(err, ErrCloseSent) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			if c.PongHandler != nil {
+				c.PongHandler(f.payload)
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handlePeerClose(f.payload)
+		case opText, opBinary:
+			if inMessage {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			inMessage = true
+			msgType = MessageType(f.opcode)
+		case opContinuation:
+			if !inMessage {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		}
+		msg = append(msg, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if msgType == TextMessage && !utf8.Valid(msg) {
+			return 0, nil, c.fail(CloseInvalidPayload, "text message is not valid UTF-8")
+		}
+		if msg == nil {
+			msg = []byte{}
+		}
+		return msgType, msg, nil
+	}
+}
+
+// WriteMessage sends a complete data message, fragmenting it if
+// WriteFragmentSize is set.
+func (c *Conn) WriteMessage(t MessageType, data []byte) error {
+	if t != TextMessage && t != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", t)
+	}
+	opcode := byte(t)
+	size := c.WriteFragmentSize
+	for size > 0 && len(data) > size {
+		err := c.writeFrame(false, opcode, data[:size])
+		if err != nil {
+			return err
+		}
+		data = data[size:]
+		opcode = opContinuation
+	}
+	return c.writeFrame(true, opcode, data)
+}
+
+// Ping sends a ping frame. The peer's pong is delivered to PongHandler by
+// ReadMessage.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: ping payload exceeds %d bytes", maxControlPayload)
+	}
+	return c.writeFrame(true, opPing, data)
+}
+
+// Close performs the closing handshake: it sends a close frame, waits briefly
+// for the peer's close frame and then closes the connection. It must not be
+// called concurrently with ReadMessage.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.writeClose(code, reason)
+	if err != nil && !errors.Is(err, ErrCloseSent) {
+		c.conn.Close()
+		return err
+	}
+	if c.closeErr == nil {
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		for {
+			f, err := c.readFrame(-1)
+			if err != nil || f.opcode == opClose {
+				break
+			}
+		}
+	}
+	return c.conn.Close()
+}
+
+func (c *Conn) writeClose(code int, reason string) error {
+	var payload []byte
+	if code != CloseNoStatusReceived {
+		payload = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > maxControlPayload {
+			payload = payload[:maxControlPayload]
+		}
+	}
+	return c.writeFrame(true, opClose, payload)
+}
+
+// handlePeerClose validates a received close frame and echoes it back.
+func (c *Conn) handlePeerClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close frame payload")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(CloseInvalidPayload, "close reason is not valid UTF-8")
+		}
+	}
+	err := c.writeClose(closeErr.Code, "")
+	if err != nil && !errors.Is(err, ErrCloseSent) {
+		return err
+	}
+	c.closeErr = closeErr
+	return closeErr
+}
+
+// handleReadError turns protocol violations found while reading a frame into
+// a close handshake; I/O errors are returned as is.
+func (c *Conn) handleReadError(err error) error {
+	var pe *protocolError
+	if errors.As(err, &pe) {
+		return c.fail(pe.code, pe.reason)
+	}
+	return err
+}
+
+// fail sends a close frame with the given status and returns the matching
+// CloseError for the caller to report.
+func (c *Conn) fail(code int, reason string) error {
+	c.writeClose(code, reason)
+	c.closeErr = &CloseError{Code: code, Reason: reason}
+	return c.closeErr
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code >= 1000 && code <= 1011:
+		return code != 1004 && code != CloseNoStatusReceived && code != 1006
+	}
+	return false
+}
+
+type protocolError struct {
+	code   int
+	reason string
+}
+
+func (e *protocolError) Error() string {
+	return "websocket: " + e.reason
+}
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// readFrame reads one frame. Data frames larger than limit are rejected
+// before their payload is read; a negative limit accepts any size.
+func (c *Conn) readFrame(limit int64) (frame, error) {
+	var head [2]byte
+	_, err := io.ReadFull(c.br, head[:])
+	if err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		fin:    head[0]&0x80 != 0,
+		opcode: head[0] & 0x0f,
+	}
+	if head[0]&0x70 != 0 {
+		return frame{}, &protocolError{CloseProtocolError, "reserved bits set"}
+	}
+	isControl := f.opcode&0x8 != 0
+	switch f.opcode {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return frame{}, &protocolError{CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode)}
+	}
+
+	masked := head[1]&0x80 != 0
+	if masked != c.isServer {
+		return frame{}, &protocolError{CloseProtocolError, "incorrect frame masking"}
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return frame{}, &protocolError{CloseProtocolError, "invalid payload length"}
+		}
+	}
+
+	if isControl {
+		if !f.fin {
+			return frame{}, &protocolError{CloseProtocolError, "fragmented control frame"}
+		}
+		if length > maxControlPayload {
+			return frame{}, &protocolError{CloseProtocolError, "control frame too large"}
+		}
+	} else if limit >= 0 && length > uint64(limit) {
+		return frame{}, &protocolError{CloseMessageTooBig, "message too big"}
+	}
+
+	var maskKey [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, maskKey[:]); err != nil {
+			return frame{}, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return frame{}, err
+	}
+	if masked {
+		maskBytes(maskKey, f.payload)
+	}
+	return f, nil
+}
+
+func (c *Conn) writeFrame(fin bool, opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	buf := make([]byte, 0, 14+len(payload))
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	buf = append(buf, b0)
+
+	var maskBit byte
+	if !c.isServer {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		buf = append(buf, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(payload)))
+	}
+
+	if c.isServer {
+		buf = append(buf, payload...)
+	} else {
+		// clients must mask every frame with a fresh random key
+		var maskKey [4]byte
+		if _, err := rand.Read(maskKey[:]); err != nil {
+			return err
+		}
+		buf = append(buf, maskKey[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(maskKey, buf[start:])
+	}
+
+	_, err := c.conn.Write(buf)
+	if err != nil {
+		return err
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+	return nil
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
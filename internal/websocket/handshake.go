@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+)
+
+// acceptGUID is the fixed value RFC 6455 section 1.3 appends to the client key.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrNotHijackable = errors.New("websocket: response writer cannot be hijacked")
+
+// HandshakeError is returned by Upgrade when the request is not a valid
+// websocket opening handshake. A response has already been sent to the client.
+type HandshakeError struct {
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket: bad handshake: " + e.Reason
+}
+
+// Upgrade validates the opening handshake in req, replies 101 Switching
+// Protocols and returns a server-side Conn over the hijacked connection. The
+// caller must Close the returned Conn.
+func Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	key, status, reason := checkHandshake(req)
+	if reason != "" {
+		rejectHandshake(w, status, reason)
+		return nil, &HandshakeError{Reason: reason}
+	}
+	if !w.CanHijack() {
+		return nil, ErrNotHijackable
+	}
+
+	err := w.WriteStatusLine(response.StatusSwitchingProtocols)
+	if err != nil {
+		return nil, err
+	}
+	h := headers.NewHeaders()
+	h.Override("upgrade", "websocket")
+	h.Override("connection", "Upgrade")
+	h.Override("sec-websocket-accept", AcceptKey(key))
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return nil, err
+	}
+	conn, br, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, br, true), nil
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// checkHandshake returns the client key, or a status and reason describing why
+// the request cannot be upgraded.
+func checkHandshake(req *request.Request) (string, response.StatusCode, string) {
+	if req.RequestLine.Method != "GET" {
+		return "", response.StatusBadRequest, "method must be GET"
+	}
+	if v, _ := req.Headers.Get("Upgrade"); !hasToken(v, "websocket") {
+		return "", response.StatusBadRequest, "missing 'Upgrade: websocket' header"
+	}
+	if v, _ := req.Headers.Get("Connection"); !hasToken(v, "upgrade") {
+		return "", response.StatusBadRequest, "missing 'Connection: Upgrade' header"
+	}
+	if v, _ := req.Headers.Get("Sec-WebSocket-Version"); strings.TrimSpace(v) != "13" {
+		return "", response.StatusUpgradeRequired, "unsupported Sec-WebSocket-Version"
+	}
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	key = strings.TrimSpace(key)
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		return "", response.StatusBadRequest, "invalid Sec-WebSocket-Key"
+	}
+	return key, 0, ""
+}
+
+func rejectHandshake(w *response.Writer, status response.StatusCode, reason string) {
+	h := headers.NewHeaders()
+	if status == response.StatusUpgradeRequired {
+		h.Override("sec-websocket-version", "13")
+	}
+	response.WriteError(w, status, h, "websocket handshake failed: "+reason)
+}
+
+// hasToken reports whether the comma separated header value contains token,
+// compared case-insensitively.
+func hasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
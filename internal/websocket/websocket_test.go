@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jonvanw/httpfromtcp/internal/headers"
+	"github.com/jonvanw/httpfromtcp/internal/request"
+	"github.com/jonvanw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// Test: example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestUpgradeWritesSwitchingProtocols(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+
+	go func() {
+		w := response.NewConnWriter(serverSide, bufio.NewReader(serverSide))
+		conn, err := Upgrade(w, handshakeRequest())
+		if err != nil {
+			serverSide.Close()
+			return
+		}
+		// echo a single message back
+		mt, msg, err := conn.ReadMessage()
+		if err == nil {
+			conn.WriteMessage(mt, msg)
+		}
+		conn.Close(CloseNormalClosure, "")
+	}()
+
+	br := bufio.NewReader(clientSide)
+	head := readHead(t, br)
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.Contains(t, head, "sec-websocket-accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, head, "upgrade: websocket\r\n")
+
+	client := newConn(clientSide, br, false)
+	require.NoError(t, client.WriteMessage(TextMessage, []byte("hello")))
+	mt, msg, err := client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, mt)
+	assert.Equal(t, "hello", string(msg))
+
+	_, _, err = client.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseNormalClosure, closeErr.Code)
+}
+
+func TestUpgradeRejectsBadHandshake(t *testing.T) {
+	cases := map[string]func(*request.Request){
+		"post":        func(r *request.Request) { r.RequestLine.Method = "POST" },
+		"no upgrade":  func(r *request.Request) { r.Headers.Remove("upgrade") },
+		"bad key":     func(r *request.Request) { r.Headers.Override("sec-websocket-key", "short") },
+		"old version": func(r *request.Request) { r.Headers.Override("sec-websocket-version", "8") },
+	}
+	for name, mutate := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			w := response.NewWriter(buf)
+			req := handshakeRequest()
+			mutate(req)
+			_, err := Upgrade(w, req)
+			var he *HandshakeError
+			require.ErrorAs(t, err, &he)
+			assert.False(t, strings.HasPrefix(buf.String(), "HTTP/1.1 101"))
+		})
+	}
+}
+
+func TestFragmentedMessage(t *testing.T) {
+	server, client := pipePair()
+	client.WriteFragmentSize = 3
+	go client.WriteMessage(BinaryMessage, []byte("fragmented payload"))
+
+	mt, msg, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, mt)
+	assert.Equal(t, "fragmented payload", string(msg))
+}
+
+func TestPingIsAnsweredWithPong(t *testing.T) {
+	server, client := pipePair()
+	pongs := make(chan string, 1)
+	client.PongHandler = func(data []byte) { pongs <- string(data) }
+
+	go server.ReadMessage()
+	require.NoError(t, client.Ping([]byte("are you there")))
+	go client.ReadMessage()
+	assert.Equal(t, "are you there", <-pongs)
+}
+
+func TestMessageTooBig(t *testing.T) {
+	server, client := pipePair()
+	server.MaxMessageSize = 4
+	go client.WriteMessage(TextMessage, []byte("too long"))
+	clientErr := make(chan error, 1)
+	go func() {
+		_, _, err := client.ReadMessage()
+		clientErr <- err
+	}()
+
+	_, _, err := server.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+
+	// keep reading so the client can echo the close frame over the pipe
+	go io.Copy(io.Discard, server.br)
+	require.ErrorAs(t, <-clientErr, &closeErr)
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+}
+
+func TestUnmaskedClientFrameIsRejected(t *testing.T) {
+	server, client := pipePair()
+	// a client conn flagged as server skips masking, which servers must reject
+	client.isServer = true
+	go client.WriteMessage(TextMessage, []byte("hi"))
+	go func() { client.readFrame(-1) }()
+
+	_, _, err := server.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseProtocolError, closeErr.Code)
+}
+
+func TestInvalidUTF8TextIsRejected(t *testing.T) {
+	server, client := pipePair()
+	go client.writeFrame(true, opText, []byte{0xff, 0xfe})
+	go func() { client.readFrame(-1) }()
+
+	_, _, err := server.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseInvalidPayload, closeErr.Code)
+}
+
+func TestCloseHandshake(t *testing.T) {
+	server, client := pipePair()
+	serverErr := make(chan error, 1)
+	go func() {
+		_, _, err := server.ReadMessage()
+		serverErr <- err
+	}()
+
+	require.NoError(t, client.Close(CloseGoingAway, "bye"))
+	var closeErr *CloseError
+	require.ErrorAs(t, <-serverErr, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Reason)
+}
+
+func pipePair() (server *Conn, client *Conn) {
+	a, b := net.Pipe()
+	return newConn(a, bufio.NewReader(a), true), newConn(b, bufio.NewReader(b), false)
+}
+
+func handshakeRequest() *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/ws", HttpVersion: "1.1"},
+		Headers: headers.Headers{
+			"host":                  "localhost:42069",
+			"upgrade":               "websocket",
+			"connection":            "keep-alive, Upgrade",
+			"sec-websocket-key":     "dGhlIHNhbXBsZSBub25jZQ==",
+			"sec-websocket-version": "13",
+		},
+	}
+}
+
+func readHead(t *testing.T, br *bufio.Reader) string {
+	var sb strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		sb.WriteString(line)
+		if line == "\r\n" {
+			return sb.String()
+		}
+	}
+}